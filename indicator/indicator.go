@@ -0,0 +1,257 @@
+package indicator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"emmon/monitor"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	ledClassPath  = "/sys/class/leds"
+	gpioClassPath = "/sys/class/gpio"
+)
+
+// Config describes the status output and the pattern shown for each health level
+type Config struct {
+	Enabled      bool               `mapstructure:"enabled"`
+	LED          string             `mapstructure:"led"`           // LED name under /sys/class/leds or a full path
+	GPIO         string             `mapstructure:"gpio"`          // GPIO line name, e.g. "gpio17"
+	AllowedGPIOs []string           `mapstructure:"allowed_gpios"` // GPIO lines emmon may drive
+	Interval     time.Duration      `mapstructure:"interval"`      // how often the health state is checked
+	Patterns     map[string]Pattern `mapstructure:"patterns"`      // health level -> pattern
+}
+
+// Pattern describes how the output looks for one health level. A pattern
+// with both On and Off set blinks; otherwise the output is held steady at
+// Brightness, or handed to the given LED trigger.
+type Pattern struct {
+	Trigger    string        `mapstructure:"trigger"`
+	Brightness int           `mapstructure:"brightness"`
+	On         time.Duration `mapstructure:"on"`
+	Off        time.Duration `mapstructure:"off"`
+}
+
+// blinks reports whether the pattern toggles the output
+func (p Pattern) blinks() bool {
+	return p.On > 0 && p.Off > 0
+}
+
+// DefaultConfig returns the patterns used when none are configured
+func DefaultConfig() Config {
+	return Config{
+		Interval: 2 * time.Second,
+		Patterns: map[string]Pattern{
+			string(monitor.HealthOK):       {Brightness: 1},
+			string(monitor.HealthWarning):  {On: time.Second, Off: time.Second},
+			string(monitor.HealthCritical): {On: 100 * time.Millisecond, Off: 100 * time.Millisecond},
+			string(monitor.HealthFailure):  {On: 100 * time.Millisecond, Off: 900 * time.Millisecond},
+		},
+	}
+}
+
+// Indicator maps the overall health state onto an LED or GPIO line
+type Indicator struct {
+	cfg     Config
+	log     *logrus.Logger
+	ledPath string
+	gpioDir string
+
+	mu    sync.Mutex
+	level monitor.HealthLevel
+	stop  chan struct{} // stops the software blink of the current pattern
+}
+
+// NewIndicator creates an indicator for the configured LED or GPIO line
+func NewIndicator(cfg Config, log *logrus.Logger) (*Indicator, error) {
+	ind := &Indicator{
+		cfg: cfg,
+		log: log,
+	}
+
+	switch {
+	case cfg.LED != "":
+		ind.ledPath = cfg.LED
+		if !filepath.IsAbs(ind.ledPath) {
+			ind.ledPath = filepath.Join(ledClassPath, cfg.LED)
+		}
+	case cfg.GPIO != "":
+		if !ind.gpioAllowed(cfg.GPIO) {
+			return nil, fmt.Errorf("gpio %s is not in allowed_gpios", cfg.GPIO)
+		}
+		ind.gpioDir = cfg.GPIO
+		if !filepath.IsAbs(ind.gpioDir) {
+			ind.gpioDir = filepath.Join(gpioClassPath, cfg.GPIO)
+		}
+	default:
+		return nil, fmt.Errorf("no led or gpio configured")
+	}
+
+	return ind, nil
+}
+
+// gpioAllowed reports whether the GPIO line is in the allowlist
+func (ind *Indicator) gpioAllowed(gpio string) bool {
+	for _, allowed := range ind.cfg.AllowedGPIOs {
+		if allowed == gpio {
+			return true
+		}
+	}
+	return false
+}
+
+// Run shows the health of the monitor's latest sample until stop is closed,
+// then turns the output off. A stalled sampling loop shows as a failure.
+func (ind *Indicator) Run(sm *monitor.SystemMonitor, stop <-chan struct{}) {
+	interval := ind.cfg.Interval
+	if interval <= 0 {
+		interval = DefaultConfig().Interval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		level := monitor.HealthFailure
+		if stats, err := sm.LatestStats(); err == nil && !sm.Stalled() {
+			level = stats.Health.Level
+		}
+		ind.Update(level)
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			ind.Close()
+			return
+		}
+	}
+}
+
+// Update switches the output to the pattern for the given health level
+func (ind *Indicator) Update(level monitor.HealthLevel) {
+	ind.mu.Lock()
+	defer ind.mu.Unlock()
+
+	if level == ind.level {
+		return
+	}
+
+	pattern, ok := ind.cfg.Patterns[string(level)]
+	if !ok {
+		ind.log.Warnf("No indicator pattern for health level %s", level)
+		return
+	}
+
+	ind.log.Infof("Health changed from %s to %s", ind.level, level)
+	ind.level = level
+	ind.stopBlink()
+
+	if err := ind.apply(pattern); err != nil {
+		ind.log.Errorf("Failed to set indicator pattern: %v", err)
+	}
+}
+
+// Close stops blinking and turns the output off
+func (ind *Indicator) Close() {
+	ind.mu.Lock()
+	defer ind.mu.Unlock()
+
+	ind.stopBlink()
+	if err := ind.apply(Pattern{Trigger: "none"}); err != nil {
+		ind.log.Errorf("Failed to turn indicator off: %v", err)
+	}
+}
+
+// apply programs the output with the pattern
+func (ind *Indicator) apply(p Pattern) error {
+	if ind.ledPath != "" {
+		return ind.applyLED(p)
+	}
+	return ind.applyGPIO(p)
+}
+
+// applyLED programs the LED through its sysfs attributes. Blinking uses the
+// kernel's timer trigger so it keeps going without emmon's help.
+func (ind *Indicator) applyLED(p Pattern) error {
+	if p.blinks() {
+		if err := writeAttr(ind.ledPath, "trigger", "timer"); err != nil {
+			return err
+		}
+		if err := writeAttr(ind.ledPath, "delay_on", strconv.FormatInt(p.On.Milliseconds(), 10)); err != nil {
+			return err
+		}
+		return writeAttr(ind.ledPath, "delay_off", strconv.FormatInt(p.Off.Milliseconds(), 10))
+	}
+
+	trigger := p.Trigger
+	if trigger == "" {
+		trigger = "none"
+	}
+	if err := writeAttr(ind.ledPath, "trigger", trigger); err != nil {
+		return err
+	}
+	if trigger != "none" {
+		return nil
+	}
+	return writeAttr(ind.ledPath, "brightness", strconv.Itoa(p.Brightness))
+}
+
+// applyGPIO drives the GPIO line, toggling it from a goroutine to blink
+func (ind *Indicator) applyGPIO(p Pattern) error {
+	if !p.blinks() {
+		return ind.setGPIO(p.Brightness > 0)
+	}
+
+	stop := make(chan struct{})
+	ind.stop = stop
+
+	go func() {
+		on := true
+		for {
+			if err := ind.setGPIO(on); err != nil {
+				ind.log.Errorf("Failed to toggle %s: %v", ind.cfg.GPIO, err)
+			}
+
+			delay := p.Off
+			if on {
+				delay = p.On
+			}
+			select {
+			case <-time.After(delay):
+				on = !on
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// setGPIO writes the GPIO line's value
+func (ind *Indicator) setGPIO(on bool) error {
+	value := "0"
+	if on {
+		value = "1"
+	}
+	return writeAttr(ind.gpioDir, "value", value)
+}
+
+// stopBlink stops the software blink goroutine, if any
+func (ind *Indicator) stopBlink() {
+	if ind.stop != nil {
+		close(ind.stop)
+		ind.stop = nil
+	}
+}
+
+// writeAttr writes a sysfs attribute
+func writeAttr(dir, name, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
@@ -0,0 +1,104 @@
+package indicator
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"emmon/monitor"
+
+	"github.com/sirupsen/logrus"
+)
+
+func readAttr(t *testing.T, dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatalf("read %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestLEDPatterns(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.LED = dir
+
+	ind, err := NewIndicator(cfg, logrus.New())
+	if err != nil {
+		t.Fatalf("NewIndicator: %v", err)
+	}
+
+	ind.Update(monitor.HealthOK)
+	if got := readAttr(t, dir, "trigger"); got != "none" {
+		t.Errorf("ok trigger = %q, want none", got)
+	}
+	if got := readAttr(t, dir, "brightness"); got != "1" {
+		t.Errorf("ok brightness = %q, want 1", got)
+	}
+
+	ind.Update(monitor.HealthCritical)
+	if got := readAttr(t, dir, "trigger"); got != "timer" {
+		t.Errorf("critical trigger = %q, want timer", got)
+	}
+	if got := readAttr(t, dir, "delay_on"); got != "100" {
+		t.Errorf("critical delay_on = %q, want 100", got)
+	}
+}
+
+func TestGPIOAllowlist(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.GPIO = "gpio17"
+
+	if _, err := NewIndicator(cfg, logrus.New()); err == nil {
+		t.Error("expected an error for a GPIO outside the allowlist")
+	}
+
+	cfg.AllowedGPIOs = []string{"gpio17"}
+	if _, err := NewIndicator(cfg, logrus.New()); err != nil {
+		t.Errorf("allowlisted GPIO rejected: %v", err)
+	}
+}
+
+func TestRunWithoutSamples(t *testing.T) {
+	dir := t.TempDir()
+	cfg := DefaultConfig()
+	cfg.LED = dir
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	ind, err := NewIndicator(cfg, log)
+	if err != nil {
+		t.Fatalf("NewIndicator: %v", err)
+	}
+	sm := monitor.NewSystemMonitor(log, monitor.DefaultConfig())
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ind.Run(sm, stop)
+		close(done)
+	}()
+
+	// A monitor that has not sampled yet shows as a failure
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "delay_off")); err == nil && string(data) == "900" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("indicator did not show the failure pattern")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(stop)
+	<-done
+	if got := readAttr(t, dir, "trigger"); got != "none" {
+		t.Errorf("trigger after stop = %q, want none", got)
+	}
+	if got := readAttr(t, dir, "brightness"); got != "0" {
+		t.Errorf("brightness after stop = %q, want 0", got)
+	}
+}
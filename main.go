@@ -1,9 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"emmon/indicator"
 	"emmon/monitor"
 	"emmon/terminal"
 	"emmon/web"
@@ -41,7 +47,7 @@ var webCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		port := viper.GetString("web.port")
 		log.Infof("Starting web interface on port %s", port)
-		startWebInterface(port)
+		startWebInterface(port, newMonitor())
 	},
 }
 
@@ -51,7 +57,112 @@ var terminalCmd = &cobra.Command{
 	Long:  `Start the embedded monitor with terminal UI using tcell`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Info("Starting terminal interface")
-		startTerminalInterface()
+		startTerminalInterface(newMonitor())
+	},
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run the monitor as a daemon",
+	Long: `Run the collectors, history, health events, status indicator and watchdog
+without a user interface, optionally serving the web interface and API. The
+agent notifies systemd when it is ready and sends watchdog keepalives when
+NOTIFY_SOCKET is set. SIGHUP reloads the configuration.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runAgent(viper.GetString("agent.port"), viper.GetDuration("agent.interval"))
+	},
+}
+
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Record the stats to a file",
+	Long: `Record the system stats, events and kernel messages to a compressed file
+that emmon replay plays back in the web or terminal interface`,
+	Run: func(cmd *cobra.Command, args []string) {
+		out, _ := cmd.Flags().GetString("out")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		duration, _ := cmd.Flags().GetDuration("duration")
+		startRecording(out, interval, duration)
+	},
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot",
+	Short: "Print the stats",
+	Long: `Collect the system stats and print them to stdout as JSON, YAML, a table
+or Prometheus text, once or a number of times`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format, _ := cmd.Flags().GetString("format")
+		fields, _ := cmd.Flags().GetStringSlice("fields")
+		count, _ := cmd.Flags().GetInt("count")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		printSnapshots(format, fields, count, interval)
+	},
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Write a diagnostic bundle for support",
+	Long: `Write a tar.gz with the inventory, a snapshot, the recent history, kernel
+log and events, the process table, mounts, network configuration, the
+configuration with secrets redacted and the collector errors`,
+	Run: func(cmd *cobra.Command, args []string) {
+		out, _ := cmd.Flags().GetString("out")
+		history, _ := cmd.Flags().GetDuration("history")
+		wait, _ := cmd.Flags().GetDuration("wait")
+		writeBundle(out, history, wait)
+	},
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check what emmon can see on this board",
+	Long: `Probe the data sources of every collector: thermal zones, hwmon chips, GPIO
+chips, block devices, CPU frequency policies, power supplies and pressure stall
+information, and whether they can be read. The report ends with file metrics
+suggested for the sensors no built-in collector reads.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		asJSON, _ := cmd.Flags().GetBool("json")
+		report := monitor.Doctor(monitorConfig())
+
+		var err error
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(report)
+		} else {
+			err = report.WriteText(os.Stdout)
+		}
+		if err != nil {
+			log.Fatalf("Failed to print the report: %v", err)
+		}
+	},
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <file>",
+	Short: "Replay a recording",
+	Long:  `Play a recording made with emmon record back in the web or terminal interface`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		speed, _ := cmd.Flags().GetFloat64("speed")
+		loop, _ := cmd.Flags().GetBool("loop")
+		ui, _ := cmd.Flags().GetString("ui")
+		port, _ := cmd.Flags().GetString("port")
+
+		monitor := newMonitor()
+		if err := monitor.Replay(args[0], speed, loop); err != nil {
+			log.Fatalf("Failed to replay: %v", err)
+		}
+		switch ui {
+		case "web":
+			log.Infof("Starting web interface on port %s", port)
+			startWebInterface(port, monitor)
+		case "terminal":
+			startTerminalInterface(monitor)
+		default:
+			log.Fatalf("Unknown interface %q, use web or terminal", ui)
+		}
 	},
 }
 
@@ -61,14 +172,55 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.emmon.yaml)")
 	rootCmd.PersistentFlags().String("log-level", "info", "log level (debug, info, warn, error)")
 
+	rootCmd.PersistentFlags().Bool("simulate", false, "simulate a device instead of reading this one")
+
 	viper.BindPFlag("log.level", rootCmd.PersistentFlags().Lookup("log-level"))
+	viper.BindPFlag("simulation.enabled", rootCmd.PersistentFlags().Lookup("simulate"))
 
 	// Web command flags
 	webCmd.Flags().String("port", "8080", "port for web interface")
 	viper.BindPFlag("web.port", webCmd.Flags().Lookup("port"))
 
+	// Agent command flags
+	agentCmd.Flags().String("port", "", "port for the web interface and API (default none)")
+	agentCmd.Flags().Duration("interval", 2*time.Second, "time between samples")
+	viper.BindPFlag("agent.port", agentCmd.Flags().Lookup("port"))
+	viper.BindPFlag("agent.interval", agentCmd.Flags().Lookup("interval"))
+
+	// Record command flags
+	recordCmd.Flags().StringP("out", "o", "", "file to record to")
+	recordCmd.Flags().Duration("interval", 2*time.Second, "time between samples")
+	recordCmd.Flags().Duration("duration", 0, "stop recording after this long (default until interrupted)")
+	recordCmd.MarkFlagRequired("out")
+
+	// Snapshot command flags
+	snapshotCmd.Flags().StringP("format", "f", "json", "output format (json, yaml, table, prometheus)")
+	snapshotCmd.Flags().StringSlice("fields", nil, "fields to print, e.g. cpu,temperature.cpu (default all)")
+	snapshotCmd.Flags().IntP("count", "n", 1, "number of snapshots")
+	snapshotCmd.Flags().Duration("interval", time.Second, "time between snapshots")
+
+	// Bundle command flags
+	bundleCmd.Flags().StringP("out", "o", "", "file to write, - for stdout (default emmon-bundle-<host>-<time>.tar.gz)")
+	bundleCmd.Flags().Duration("history", 0, "history to include (default bundle.history, 6h)")
+	bundleCmd.Flags().Duration("wait", 3*time.Second, "time the background collectors run before the bundle is written")
+
+	// Doctor command flags
+	doctorCmd.Flags().Bool("json", false, "print the report as JSON")
+
+	// Replay command flags
+	replayCmd.Flags().Float64("speed", 1, "replay speed, e.g. 10 for ten times faster")
+	replayCmd.Flags().Bool("loop", false, "start over at the end of the recording")
+	replayCmd.Flags().String("ui", "web", "interface to replay in (web, terminal)")
+	replayCmd.Flags().String("port", "8080", "port for web interface")
+
 	rootCmd.AddCommand(webCmd)
 	rootCmd.AddCommand(terminalCmd)
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(recordCmd)
+	rootCmd.AddCommand(snapshotCmd)
+	rootCmd.AddCommand(bundleCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(replayCmd)
 }
 
 func initConfig() {
@@ -110,22 +262,370 @@ func Execute() {
 	}
 }
 
-// startWebInterface starts the web interface
-func startWebInterface(port string) {
-	monitor := monitor.NewSystemMonitor(log)
-	server := web.NewWebServer(port, log, monitor)
+// monitorConfig reads the monitor settings from the loaded configuration
+func monitorConfig() monitor.Config {
+	cfg := monitor.DefaultConfig()
+	if err := viper.Unmarshal(&cfg); err != nil {
+		log.Warnf("Invalid monitor configuration: %v", err)
+	}
+	return cfg
+}
+
+// persistHistory reports whether the agent stores the history on disk. It
+// does unless the configuration turns it off, while the interactive commands,
+// often run by a user who cannot write the state directory, only store it
+// when the configuration turns it on.
+func persistHistory() bool {
+	return !viper.IsSet("history.persist.enabled") || viper.GetBool("history.persist.enabled")
+}
+
+// newMonitor creates a system monitor from the loaded configuration
+func newMonitor() *monitor.SystemMonitor {
+	return monitor.NewSystemMonitor(log, monitorConfig())
+}
+
+// startIndicator drives the status LED or GPIO from the health state, if
+// enabled, and returns a function that turns it off again. Replayed or
+// simulated stats do not drive the hardware.
+func startIndicator(sm *monitor.SystemMonitor) func() {
+	noop := func() {}
+	cfg := indicator.DefaultConfig()
+	if err := viper.UnmarshalKey("indicator", &cfg); err != nil {
+		log.Warnf("Invalid indicator configuration: %v", err)
+		return noop
+	}
+	if !cfg.Enabled {
+		return noop
+	}
+	if !sm.Live() {
+		log.Info("Not driving the status indicator from replayed or simulated stats")
+		return noop
+	}
 
-	if err := server.Start(); err != nil {
+	ind, err := indicator.NewIndicator(cfg, log)
+	if err != nil {
+		log.Errorf("Failed to set up status indicator: %v", err)
+		return noop
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ind.Run(sm, stop)
+		close(done)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// startWebInterface starts the web interface. The port is opened before the
+// monitor starts, so a busy port exits before the watchdog is armed.
+func startWebInterface(port string, monitor *monitor.SystemMonitor) {
+	server := web.NewWebServer(port, log, monitor)
+	listener, err := server.Listen()
+	if err != nil {
 		log.Fatalf("Failed to start web server: %v", err)
 	}
+
+	monitor.Start()
+	stopIndicator := startIndicator(monitor)
+
+	// Stop the monitor on SIGINT or SIGTERM so the indicator is turned off
+	// and the watchdog is disarmed cleanly
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Infof("Received %s, shutting down", sig)
+		stopIndicator()
+		monitor.Stop()
+		os.Exit(0)
+	}()
+
+	// log.Fatalf would skip the stops and leave the watchdog armed
+	err = server.Serve(listener)
+	log.Errorf("Web server stopped: %v", err)
+	stopIndicator()
+	monitor.Stop()
+	os.Exit(1)
 }
 
 // startTerminalInterface starts the terminal interface
-func startTerminalInterface() {
-	monitor := monitor.NewSystemMonitor(log)
+func startTerminalInterface(monitor *monitor.SystemMonitor) {
+	monitor.Start()
 	ui := terminal.NewTerminalUI(monitor, log)
+	stopIndicator := startIndicator(monitor)
 
-	if err := ui.Start(); err != nil {
+	// SIGINT and SIGTERM quit like Escape does, so the stops below turn the
+	// indicator off and disarm the watchdog
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Infof("Received %s, shutting down", sig)
+		ui.Stop()
+	}()
+
+	err := ui.Start()
+	stopIndicator()
+	monitor.Stop()
+	if err != nil {
 		log.Fatalf("Failed to start terminal UI: %v", err)
 	}
 }
+
+// runAgent runs the sampling loop every interval, which keeps the history,
+// health events, indicator and watchdog going, and serves the web interface
+// and API when a port is set. SIGINT and SIGTERM stop it cleanly; SIGHUP
+// stops it and starts it again in the same process with the configuration
+// read anew.
+func runAgent(port string, interval time.Duration) {
+	if interval <= 0 {
+		log.Fatalf("Invalid interval %s", interval)
+	}
+	notify := func(state string) {
+		if err := sdNotify(state); err != nil {
+			log.Warnf("Failed to notify the service manager: %v", err)
+		}
+	}
+
+	cfg := monitorConfig()
+	cfg.SampleInterval = interval
+	cfg.History.Persist.Enabled = persistHistory()
+	sm := monitor.NewSystemMonitor(log, cfg)
+	sm.Start()
+	stopIndicator := startIndicator(sm)
+	shutdown := func() {
+		stopIndicator()
+		sm.Stop()
+	}
+
+	if port != "" {
+		server := web.NewWebServer(port, log, sm)
+		listener, err := server.Listen()
+		if err != nil {
+			shutdown()
+			log.Fatalf("Failed to start web server: %v", err)
+		}
+		go func() {
+			if err := server.Serve(listener); err != nil {
+				log.Errorf("Web server stopped: %v", err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	notify("READY=1\nSTATUS=Sampling every " + interval.String())
+	log.Infof("Agent running, sampling every %s", interval)
+
+	// Keepalives stop while the sampling loop is stalled, e.g. by a hung collector
+	var keepalive <-chan time.Time
+	if every := sdWatchdogInterval(); every > 0 {
+		keepaliveTicker := time.NewTicker(every)
+		defer keepaliveTicker.Stop()
+		keepalive = keepaliveTicker.C
+	}
+
+	for {
+		select {
+		case <-keepalive:
+			if !sm.Stalled() {
+				notify("WATCHDOG=1")
+			}
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if err := checkConfig(); err != nil {
+					log.Errorf("Not reloading, the configuration is invalid: %v", err)
+					continue
+				}
+				log.Info("Received SIGHUP, reloading the configuration")
+				notify(sdReloading())
+				shutdown()
+				reexec()
+			}
+			log.Infof("Received %s, shutting down", sig)
+			notify("STOPPING=1")
+			shutdown()
+			return
+		}
+	}
+}
+
+// checkConfig reads the configuration file again, so a reload does not
+// replace a running agent with one that fails to start
+func checkConfig() error {
+	path := viper.ConfigFileUsed()
+	if path == "" {
+		return nil
+	}
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return err
+	}
+	cfg := monitor.DefaultConfig()
+	return v.Unmarshal(&cfg)
+}
+
+// reexec replaces the process with a new run of the same command, keeping
+// the process ID the service manager knows
+func reexec() {
+	exe, err := os.Executable()
+	if err == nil {
+		err = syscall.Exec(exe, os.Args, os.Environ())
+	}
+	log.Fatalf("Failed to restart: %v", err)
+}
+
+// startRecording records a sample every interval until interrupted or until
+// the duration has passed
+func startRecording(path string, interval, duration time.Duration) {
+	if interval <= 0 {
+		log.Fatalf("Invalid interval %s", interval)
+	}
+
+	// The agent may be running on the same device, and it owns the stored
+	// history, the state directory and the watchdog
+	cfg := monitorConfig()
+	cfg.SampleInterval = interval
+	cfg.History.Persist.Enabled = false
+	cfg.Watchdog.Enabled = false
+	cfg.ReadOnlyState = true
+	sm := monitor.NewSystemMonitor(log, cfg)
+	samples, cancel := sm.Subscribe()
+	defer cancel()
+	sm.Start()
+	defer sm.Stop()
+
+	recorder, err := monitor.NewRecorder(path, sm, interval)
+	if err != nil {
+		log.Fatalf("Failed to create recording: %v", err)
+	}
+	log.Infof("Recording to %s every %s", path, interval)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var deadline <-chan time.Time
+	if duration > 0 {
+		deadline = time.After(duration)
+	}
+
+record:
+	for {
+		select {
+		case stats := <-samples:
+			if err := recorder.Record(stats); err != nil {
+				log.Errorf("Failed to record stats: %v", err)
+				break record
+			}
+		case sig := <-signals:
+			log.Infof("Received %s, stopping the recording", sig)
+			break record
+		case <-deadline:
+			break record
+		}
+	}
+
+	if err := recorder.Close(); err != nil {
+		log.Errorf("Failed to close recording: %v", err)
+	}
+	log.Infof("Recorded %d samples to %s", recorder.Samples(), path)
+}
+
+// printSnapshots prints count samples of the stats to stdout, interval apart.
+// The background collectors run as they do in the agent, and the first sample
+// only primes the rates, such as the CPU usage. Nothing is written to the
+// state directory and the watchdog is left alone.
+func printSnapshots(format string, fields []string, count int, interval time.Duration) {
+	out, err := monitor.NewSnapshotWriter(os.Stdout, format, fields)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	if count < 1 || interval <= 0 {
+		log.Fatalf("Invalid count %d or interval %s", count, interval)
+	}
+
+	cfg := monitorConfig()
+	cfg.SampleInterval = interval
+	cfg.History.Enabled = false
+	cfg.Watchdog.Enabled = false
+	cfg.ReadOnlyState = true
+	sm := monitor.NewSystemMonitor(log, cfg)
+	samples, cancel := sm.Subscribe()
+	defer cancel()
+	sm.Start()
+	defer sm.Stop()
+
+	<-samples
+	for i := 0; i < count; i++ {
+		if err := out.Write(<-samples); err != nil {
+			log.Fatalf("Failed to print stats: %v", err)
+		}
+	}
+}
+
+// writeBundle writes a diagnostic bundle to path. The monitor reads the
+// stored history but writes nothing to the state directory, and runs the
+// background collectors for wait first so the kernel log and probes are
+// filled in.
+func writeBundle(path string, history, wait time.Duration) {
+	cfg := monitorConfig()
+	loadHistory := cfg.History.Enabled && persistHistory()
+	cfg.History.Persist.Enabled = false
+	cfg.Watchdog.Enabled = false
+	cfg.ReadOnlyState = true
+	sm := monitor.NewSystemMonitor(log, cfg)
+	if loadHistory {
+		if err := sm.LoadHistory(); err != nil {
+			log.Warnf("Failed to load the stored history: %v", err)
+		}
+	}
+
+	sm.Start()
+	defer sm.Stop()
+	time.Sleep(wait)
+
+	name := monitor.BundleName(time.Now())
+	out := os.Stdout
+	if path != "-" {
+		if path == "" {
+			path = name + ".tar.gz"
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			log.Fatalf("Failed to create bundle: %v", err)
+		}
+		out = f
+	}
+
+	manifest, err := sm.WriteBundle(out, name, history)
+	if err != nil {
+		log.Fatalf("Failed to write bundle: %v", err)
+	}
+	if path != "-" {
+		if err := out.Close(); err != nil {
+			log.Fatalf("Failed to write bundle: %v", err)
+		}
+	}
+	for _, f := range manifest.Files {
+		switch {
+		case f.Skipped:
+			log.Warnf("Left out %s at the bundle size limit", f.Name)
+		case f.Truncated:
+			log.Warnf("Truncated %s at %d bytes", f.Name, f.Size)
+		case f.Error != "":
+			log.Debugf("Incomplete %s: %s", f.Name, f.Error)
+		}
+	}
+	if path != "-" {
+		log.Infof("Wrote %d files to %s", len(manifest.Files)+1, path)
+	}
+}
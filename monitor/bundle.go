@@ -0,0 +1,466 @@
+package monitor
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BundleManifest describes the files of a diagnostic bundle. It is the last
+// file of the bundle, so it can list the sizes of the others.
+type BundleManifest struct {
+	Format   string       `json:"format"` // always "emmon-bundle"
+	Version  int          `json:"version"`
+	Created  time.Time    `json:"created"`
+	Hostname string       `json:"hostname"`
+	History  string       `json:"history"` // period of history included
+	Files    []BundleFile `json:"files"`
+	Limits   BundleLimits `json:"limits"`
+	Size     int64        `json:"size"` // bytes of all files before compression
+}
+
+// BundleFile describes one file of a diagnostic bundle
+type BundleFile struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"` // cut at the file size limit
+	Skipped   bool   `json:"skipped,omitempty"`   // left out at the bundle size limit
+	Error     string `json:"error,omitempty"`     // the file is incomplete or empty
+}
+
+// BundleLimits are the size limits a bundle was written with
+type BundleLimits struct {
+	MaxFileSize int64 `json:"max_file_size"`
+	MaxSize     int64 `json:"max_size"`
+}
+
+// bundleErrors holds the error state of every collector in a bundle
+type bundleErrors struct {
+	Collectors map[string]string `json:"collectors,omitempty"` // collector name -> error of the snapshot
+	KernelLog  string            `json:"kernel_log,omitempty"`
+	Probes     map[string]string `json:"probes,omitempty"`  // probe name -> last error
+	Plugins    map[string]string `json:"plugins,omitempty"` // plugin name -> error of the last run
+	Files      map[string]string `json:"files,omitempty"`
+	Derived    map[string]string `json:"derived,omitempty"`
+	Watchdog   []string          `json:"watchdog,omitempty"` // watchdog checks that failed
+}
+
+// bundleCopies are the system files copied into a bundle as they are
+var bundleCopies = []struct{ name, path string }{
+	{"mounts.txt", "/proc/mounts"},
+	{"network/dev.txt", "/proc/net/dev"},
+	{"network/route.txt", "/proc/net/route"},
+	{"network/ipv6_route.txt", "/proc/net/ipv6_route"},
+	{"network/resolv.conf", "/etc/resolv.conf"},
+}
+
+// BundleName returns the name of a bundle made at t, which is also the
+// directory its files are in
+func BundleName(t time.Time) string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return fmt.Sprintf("emmon-bundle-%s-%s", host, t.UTC().Format("20060102T150405Z"))
+}
+
+// WriteBundle writes a diagnostic bundle to w as a gzipped tar of the files
+// in directory name. A history of 0 includes the configured period. Files
+// that fail are included as far as they were written, with the error in the
+// manifest.
+func (sm *SystemMonitor) WriteBundle(w io.Writer, name string, history time.Duration) (*BundleManifest, error) {
+	if history <= 0 {
+		history = sm.cfg.Bundle.History
+	}
+	hostname, _ := os.Hostname()
+	started := time.Now()
+
+	gz := gzip.NewWriter(w)
+	bw := &bundleWriter{
+		tw:  tar.NewWriter(gz),
+		dir: name,
+		cfg: sm.cfg.Bundle,
+		manifest: BundleManifest{
+			Format:   "emmon-bundle",
+			Version:  1,
+			Created:  started,
+			Hostname: hostname,
+			History:  history.String(),
+			Limits:   BundleLimits{MaxFileSize: sm.cfg.Bundle.MaxFileSize, MaxSize: sm.cfg.Bundle.MaxSize},
+		},
+	}
+
+	stats, statsErr := sm.LatestStats()
+	writeJSON := func(v interface{}) func(io.Writer) error {
+		return func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(v)
+		}
+	}
+	fromStats := func(part func(*SystemStats) interface{}) func(io.Writer) error {
+		return func(w io.Writer) error {
+			if statsErr != nil {
+				return statsErr
+			}
+			return writeJSON(part(stats))(w)
+		}
+	}
+
+	// The most useful files come first, so the size limit leaves out the rest
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"snapshot.json", fromStats(func(s *SystemStats) interface{} { return s })},
+		{"errors.json", fromStats(func(s *SystemStats) interface{} { return collectorErrors(s) })},
+		{"inventory.json", writeJSON(sm.GetInventory())},
+		{"events.json", writeJSON(sm.RecentEvents())},
+		{"kmsg.json", writeJSON(sm.KernelMessages())},
+		{"config.yaml", func(w io.Writer) error { return writeRedactedConfig(w, sm.cfg) }},
+		{"netconfig.json", fromStats(func(s *SystemStats) interface{} { return s.NetConfig })},
+		{"processes.txt", writeProcessTable},
+	}
+	for _, f := range files {
+		if err := bw.add(f.name, f.write); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range bundleCopies {
+		path := c.path
+		if err := bw.add(c.name, func(w io.Writer) error { return copyFileTo(w, path) }); err != nil {
+			return nil, err
+		}
+	}
+	err := bw.add("history.csv", func(w io.Writer) error {
+		return writeHistoryRollups(w, sm.history, started.Add(-history), started)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := json.MarshalIndent(bw.manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := bw.writeFile("manifest.json", append(manifest, '\n')); err != nil {
+		return nil, err
+	}
+	if err := bw.tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return &bw.manifest, nil
+}
+
+// bundleWriter adds files to the tar of a bundle within the size limits
+type bundleWriter struct {
+	tw       *tar.Writer
+	dir      string
+	cfg      BundleConfig
+	manifest BundleManifest
+}
+
+// add writes a file produced by write, cut at the file size limit or the
+// room left in the bundle. Only errors writing the tar itself are returned.
+func (bw *bundleWriter) add(name string, write func(io.Writer) error) error {
+	file := BundleFile{Name: name}
+	limit := bw.cfg.MaxFileSize
+	if bw.cfg.MaxSize > 0 {
+		room := bw.cfg.MaxSize - bw.manifest.Size
+		if room <= 0 {
+			file.Skipped = true
+			bw.manifest.Files = append(bw.manifest.Files, file)
+			return nil
+		}
+		if limit <= 0 || room < limit {
+			limit = room
+		}
+	}
+
+	out := &cappedBuffer{limit: limit}
+	if err := write(out); err != nil {
+		file.Error = err.Error()
+	}
+	file.Size = int64(out.buf.Len())
+	file.Truncated = out.written > file.Size
+	bw.manifest.Files = append(bw.manifest.Files, file)
+	bw.manifest.Size += file.Size
+	return bw.writeFile(name, out.buf.Bytes())
+}
+
+// writeFile adds a file to the tar
+func (bw *bundleWriter) writeFile(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    bw.dir + "/" + name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: bw.manifest.Created,
+	}
+	if err := bw.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := bw.tw.Write(data)
+	return err
+}
+
+// cappedBuffer keeps the first limit bytes written, or everything for a
+// limit of 0, and counts the rest
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	limit   int64
+	written int64
+}
+
+// Write stores what fits within the limit and reports everything as written
+func (cb *cappedBuffer) Write(p []byte) (int, error) {
+	cb.written += int64(len(p))
+	if cb.limit <= 0 {
+		return cb.buf.Write(p)
+	}
+	if room := cb.limit - int64(cb.buf.Len()); room > 0 {
+		if int64(len(p)) > room {
+			cb.buf.Write(p[:room])
+		} else {
+			cb.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// collectorErrors gathers the errors reported anywhere in the stats
+func collectorErrors(stats *SystemStats) bundleErrors {
+	errs := bundleErrors{
+		Collectors: stats.Errors,
+		KernelLog:  stats.Kernel.Error,
+		Probes:     make(map[string]string),
+		Plugins:    make(map[string]string),
+		Files:      make(map[string]string),
+		Derived:    make(map[string]string),
+	}
+	for name, probe := range stats.Probes {
+		if probe.LastError != "" {
+			errs.Probes[name] = probe.LastError
+		}
+	}
+	for name, plugin := range stats.Plugins {
+		if plugin.Error != "" {
+			errs.Plugins[name] = plugin.Error
+		}
+	}
+	for name, file := range stats.Files {
+		if file.Error != "" {
+			errs.Files[name] = file.Error
+		}
+	}
+	for name, derived := range stats.Derived {
+		if derived.Error != "" {
+			errs.Derived[name] = derived.Error
+		}
+	}
+	if stats.Watchdog.Enabled && !stats.Watchdog.Healthy {
+		errs.Watchdog = stats.Watchdog.Reasons
+	}
+	return errs
+}
+
+// writeProcessTable writes a table of the running processes ordered by PID
+func writeProcessTable(w io.Writer) error {
+	procs, err := readProcesses("/proc")
+	if err != nil {
+		return err
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].PID < procs[j].PID })
+
+	pageKiB := uint64(os.Getpagesize() / 1024)
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "PID\tRSS_KIB\tCPU_SECONDS\tCOMMAND")
+	for _, proc := range procs {
+		command := proc.Cmdline
+		if command == "" {
+			command = "[" + proc.Comm + "]"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%.2f\t%s\n", proc.PID, proc.RSSPages*pageKiB,
+			float64(proc.CPUTicks)/clockTicks, redactString(command))
+	}
+	return tw.Flush()
+}
+
+// copyFileTo copies a file to w
+func copyFileTo(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// writeHistoryRollups writes the rollups of every metric in the history between
+// from and to, one row per metric and bucket
+func writeHistoryRollups(w io.Writer, h *History, from, to time.Time) error {
+	if h == nil {
+		return fmt.Errorf("history is disabled")
+	}
+	if _, err := io.WriteString(w, "metric,time,min,max,avg,last,count\n"); err != nil {
+		return err
+	}
+	format := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+	for _, metric := range h.Metrics() {
+		points, err := h.Query(metric, from, to, 0)
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			_, err := fmt.Fprintf(w, "%s,%s,%s,%s,%s,%s,%d\n", metric, p.Time.UTC().Format(time.RFC3339),
+				format(p.Min), format(p.Max), format(p.Avg), format(p.Last), p.Count)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeRedactedConfig writes the configuration as YAML under its
+// configuration file names, with secrets replaced by redactedValue
+func writeRedactedConfig(w io.Writer, cfg Config) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(configNode(reflect.ValueOf(cfg), "")); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// redactedValue replaces secrets in a bundle
+const redactedValue = "<redacted>"
+
+var (
+	// secretKeyParts are the words of configuration keys that hold secrets
+	secretKeyParts = map[string]bool{
+		"password": true, "passwd": true, "secret": true, "token": true, "key": true,
+		"apikey": true, "auth": true, "credentials": true, "private": true,
+	}
+
+	// urlPasswordPattern matches the password in the user info of a URL
+	urlPasswordPattern = regexp.MustCompile(`(://[^/:@\s]*:)[^@/\s]+@`)
+
+	// secretAssignmentPattern matches a secret given as name=value or name: value
+	secretAssignmentPattern = regexp.MustCompile(
+		`(?i)((?:password|passwd|secret|token|api[_-]?key|auth\w*)["']?\s*[=:]\s*)(?:(?:bearer|basic)\s+)?[^\s&"',;]+`)
+
+	// secretFlagPattern matches a command line flag followed by a secret
+	secretFlagPattern = regexp.MustCompile(`(?i)^--?[\w-]*(?:password|passwd|secret|token|api[_-]?key)$`)
+)
+
+// secretKey reports whether a configuration key names a secret
+func secretKey(key string) bool {
+	parts := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return r < 'a' || r > 'z'
+	})
+	for _, part := range parts {
+		if secretKeyParts[part] {
+			return true
+		}
+	}
+	return false
+}
+
+// redactString replaces the passwords of URLs and secrets assigned or
+// passed as flags in command lines or query strings
+func redactString(s string) string {
+	s = urlPasswordPattern.ReplaceAllString(s, "${1}"+redactedValue+"@")
+	s = secretAssignmentPattern.ReplaceAllString(s, "${1}"+redactedValue)
+
+	words := strings.Split(s, " ")
+	for i := 1; i < len(words); i++ {
+		if secretFlagPattern.MatchString(words[i-1]) && words[i] != "" {
+			words[i] = redactedValue
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// configNode converts a configuration value to a YAML node named after the
+// mapstructure tags, redacting strings under secret keys
+func configNode(v reflect.Value, key string) *yaml.Node {
+	scalar := func(tag, value string) *yaml.Node {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: value}
+	}
+
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		return scalar("!!str", time.Duration(v.Int()).String())
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return scalar("!!null", "null")
+		}
+		return configNode(v.Elem(), key)
+	case reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+			if field.PkgPath != "" || name == "" || name == "-" {
+				continue
+			}
+			node.Content = append(node.Content, scalar("!!str", name), configNode(v.Field(i), name))
+		}
+		return node
+	case reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			name := fmt.Sprint(k)
+			node.Content = append(node.Content, scalar("!!str", name), configNode(v.MapIndex(k), name))
+		}
+		return node
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return scalar("!!null", "null")
+		}
+		// Arguments after a flag naming a secret are redacted too
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		for i := 0; i < v.Len(); i++ {
+			item := configNode(v.Index(i), key)
+			if i > 0 && item.Tag == "!!str" && secretFlagPattern.MatchString(node.Content[i-1].Value) {
+				item.Value = redactedValue
+			}
+			node.Content = append(node.Content, item)
+		}
+		return node
+	case reflect.String:
+		if s := v.String(); s != "" && secretKey(key) {
+			return scalar("!!str", redactedValue)
+		}
+		return scalar("!!str", redactString(v.String()))
+	case reflect.Bool:
+		return scalar("!!bool", strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return scalar("!!int", strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return scalar("!!int", strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		return scalar("!!float", strconv.FormatFloat(v.Float(), 'g', -1, 64))
+	}
+	return scalar("!!str", fmt.Sprint(v.Interface()))
+}
@@ -0,0 +1,330 @@
+package monitor
+
+import "time"
+
+// Config holds the tunable settings of the system monitor. It is decoded
+// from the emmon configuration file, so every field carries a mapstructure tag.
+type Config struct {
+	StateDir       string        `mapstructure:"state_dir"`       // where counters that survive reboots are kept
+	SampleInterval time.Duration `mapstructure:"sample_interval"` // time between samples of the sampling loop
+	ReadOnlyState  bool          `mapstructure:"-"`               // read the state dir but never write it, for one-shot commands
+
+	Health  HealthConfig  `mapstructure:"health"`
+	Storage StorageConfig `mapstructure:"storage"`
+
+	WriteBudget WriteBudgetConfig `mapstructure:"write_budget"`
+	KernelLog   KernelLogConfig   `mapstructure:"kernel_log"`
+	EventBuffer int               `mapstructure:"event_buffer"` // number of recent events kept
+
+	InventoryRefresh time.Duration `mapstructure:"inventory_refresh"` // how often the inventory is collected again
+	TopInterrupts    int           `mapstructure:"top_interrupts"`    // number of IRQ sources reported
+
+	Probes   []ProbeConfig   `mapstructure:"probes"`
+	Services []ServiceConfig `mapstructure:"services"`
+	Plugins  []PluginConfig  `mapstructure:"plugins"`
+
+	FileMetrics []FileMetricConfig    `mapstructure:"file_metrics"`
+	Derived     []DerivedMetricConfig `mapstructure:"derived"`
+
+	Watchdog WatchdogConfig `mapstructure:"watchdog"`
+	History  HistoryConfig  `mapstructure:"history"`
+
+	Simulation SimulationConfig `mapstructure:"simulation"`
+	Bundle     BundleConfig     `mapstructure:"bundle"`
+}
+
+// HealthConfig holds the rules used to derive the overall health state
+type HealthConfig struct {
+	Rules []HealthRule `mapstructure:"rules"`
+}
+
+// StorageConfig holds the settings of the flash storage health collector
+type StorageConfig struct {
+	PersistInterval time.Duration     `mapstructure:"persist_interval"` // how often write counters are saved
+	Endurance       map[string]uint64 `mapstructure:"endurance"`        // device -> rated bytes written
+}
+
+// WriteBudgetConfig holds the daily write budgets used to protect flash storage
+type WriteBudgetConfig struct {
+	Daily           uint64            `mapstructure:"daily"`   // default bytes per day for every device, 0 for none
+	Budgets         map[string]uint64 `mapstructure:"budgets"` // device name or mount point -> bytes per day
+	TopProcesses    int               `mapstructure:"top_processes"`
+	KeepDays        int               `mapstructure:"keep_days"`
+	PersistInterval time.Duration     `mapstructure:"persist_interval"`
+}
+
+// KernelLogConfig holds the settings of the kernel log follower
+type KernelLogConfig struct {
+	Enabled bool            `mapstructure:"enabled"`
+	Path    string          `mapstructure:"path"`   // /dev/kmsg, or a file in the same format for testing
+	Buffer  int             `mapstructure:"buffer"` // number of recent messages kept
+	Window  time.Duration   `mapstructure:"window"` // period the "recent" counters cover
+	Rules   []KernelLogRule `mapstructure:"rules"`
+}
+
+// ProbeConfig describes one connectivity check run in the background
+type ProbeConfig struct {
+	Name         string        `mapstructure:"name"`
+	Type         string        `mapstructure:"type"`          // tcp, http, dns or icmp
+	Target       string        `mapstructure:"target"`        // host:port, URL, host name or host
+	Interval     time.Duration `mapstructure:"interval"`      // time between checks, default 30s
+	Timeout      time.Duration `mapstructure:"timeout"`       // default 5s
+	Window       int           `mapstructure:"window"`        // checks the success ratio covers, default 20
+	ExpectStatus int           `mapstructure:"expect_status"` // HTTP status, 0 accepts any 2xx or 3xx
+	Expect       string        `mapstructure:"expect"`        // regex the HTTP body or a resolved address must match
+	Server       string        `mapstructure:"server"`        // DNS server host:port, default the system resolver
+}
+
+// ServiceConfig describes a service that must be running. Processes are
+// matched by name and command line, or taken from a pidfile.
+type ServiceConfig struct {
+	Name    string `mapstructure:"name"`
+	Process string `mapstructure:"process"` // regex on the process name (at most 15 characters)
+	Cmdline string `mapstructure:"cmdline"` // regex on the full command line
+	Pidfile string `mapstructure:"pidfile"`
+}
+
+// PluginConfig describes an external command whose output is reported as
+// custom metrics
+type PluginConfig struct {
+	Name     string        `mapstructure:"name"`
+	Command  string        `mapstructure:"command"`
+	Args     []string      `mapstructure:"args"`
+	Format   string        `mapstructure:"format"`   // prometheus, json or influx
+	Interval time.Duration `mapstructure:"interval"` // time between runs, default 1m
+	Timeout  time.Duration `mapstructure:"timeout"`  // default 10s
+}
+
+// FileMetricConfig describes a metric read from a single file, typically a
+// sysfs or procfs node
+type FileMetricConfig struct {
+	Name     string   `mapstructure:"name"`
+	Path     string   `mapstructure:"path"`
+	Regex    string   `mapstructure:"regex"`  // the first capture group, or the match, holds the value
+	Field    int      `mapstructure:"field"`  // 1-based whitespace-separated field holding the value
+	Scale    float64  `mapstructure:"scale"`  // multiplier, e.g. 0.001 for milli-units; 0 means 1
+	Offset   float64  `mapstructure:"offset"` // added after scaling
+	Unit     string   `mapstructure:"unit"`
+	Warning  *float64 `mapstructure:"warning"`
+	Critical *float64 `mapstructure:"critical"`
+	Below    bool     `mapstructure:"below"` // trigger when the value drops below the threshold
+}
+
+// DerivedMetricConfig describes a metric computed from other metrics with an
+// expression, e.g. "temperature.cpu - temperature.ambient"
+type DerivedMetricConfig struct {
+	Name     string   `mapstructure:"name"`
+	Expr     string   `mapstructure:"expr"`
+	Unit     string   `mapstructure:"unit"`
+	Warning  *float64 `mapstructure:"warning"`
+	Critical *float64 `mapstructure:"critical"`
+	Below    bool     `mapstructure:"below"` // trigger when the value drops below the threshold
+}
+
+// WatchdogConfig holds the settings of the hardware watchdog. The watchdog is
+// only petted while every check passes, so the device reboots when they keep
+// failing for longer than the watchdog timeout.
+type WatchdogConfig struct {
+	Enabled         bool          `mapstructure:"enabled"`
+	Device          string        `mapstructure:"device"`           // /dev/watchdog, or a file for testing
+	Interval        time.Duration `mapstructure:"interval"`         // time between checks, well below the timeout
+	Collectors      []string      `mapstructure:"collectors"`       // collectors that must not fail, default cpu, memory and disk
+	Services        []string      `mapstructure:"services"`         // watched services that must be up
+	RequireWritable bool          `mapstructure:"require_writable"` // the root filesystem must not be read-only
+	MaxTemperature  float64       `mapstructure:"max_temperature"`  // highest CPU temperature, 0 for no limit
+	Conditions      []string      `mapstructure:"conditions"`       // expressions that must be true
+}
+
+// HistoryConfig holds the settings of the in-memory metrics history
+type HistoryConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	MaxMemory uint64        `mapstructure:"max_memory"` // bytes, 0 for no limit
+	Tiers     []HistoryTier `mapstructure:"tiers"`      // default DefaultHistoryTiers
+	Metrics   []string      `mapstructure:"metrics"`    // patterns of the metrics kept, most important first
+
+	Persist HistoryPersistConfig `mapstructure:"persist"`
+}
+
+// HistoryPersistConfig holds the settings of the on-disk history store. Only
+// the tiers at least as coarse as MinResolution are written, in one block
+// per flush, to keep flash wear low.
+type HistoryPersistConfig struct {
+	Enabled       bool          `mapstructure:"enabled"`        // emmon agent turns it on unless configured off
+	Dir           string        `mapstructure:"dir"`            // default <state_dir>/history
+	FlushInterval time.Duration `mapstructure:"flush_interval"` // how often completed buckets are written
+	MinResolution time.Duration `mapstructure:"min_resolution"` // finest tier written
+	SegmentSize   int64         `mapstructure:"segment_size"`   // bytes per segment file
+	MaxSize       int64         `mapstructure:"max_size"`       // bytes of all segments, 0 for no limit
+	MaxAge        time.Duration `mapstructure:"max_age"`        // segments older than this are deleted, 0 to keep them
+}
+
+// HistoryTier is one resolution of the history and how long it is kept
+type HistoryTier struct {
+	Resolution time.Duration `mapstructure:"resolution" json:"resolution"` // whole seconds
+	Retention  time.Duration `mapstructure:"retention" json:"retention"`
+}
+
+// SimulationConfig holds the settings of the simulated device that replaces
+// the collectors for development and demos
+type SimulationConfig struct {
+	Enabled       bool                 `mapstructure:"enabled"`
+	Seed          int64                `mapstructure:"seed"`  // random seed, 0 for a different run every time
+	Speed         float64              `mapstructure:"speed"` // simulated seconds per second
+	Cores         int                  `mapstructure:"cores"`
+	CPUBase       float64              `mapstructure:"cpu_base"`       // average CPU usage in percent
+	CPUAmplitude  float64              `mapstructure:"cpu_amplitude"`  // swing of the load waves in percent
+	CPUPeriod     time.Duration        `mapstructure:"cpu_period"`     // length of one load wave
+	Ambient       float64              `mapstructure:"ambient"`        // °C
+	MemoryTotal   uint64               `mapstructure:"memory_total"`   // bytes
+	DiskTotal     uint64               `mapstructure:"disk_total"`     // bytes
+	DiskFillRate  uint64               `mapstructure:"disk_fill_rate"` // bytes per second, freed again near full
+	GPIOPins      int                  `mapstructure:"gpio_pins"`
+	GPIOToggle    time.Duration        `mapstructure:"gpio_toggle"`    // period of the fastest output pin
+	BurstEvery    time.Duration        `mapstructure:"burst_every"`    // time between network bursts
+	BurstDuration time.Duration        `mapstructure:"burst_duration"` // length of a network burst
+	Scenarios     []SimulationScenario `mapstructure:"scenarios"`      // default DefaultSimulationScenarios
+}
+
+// SimulationScenario injects a fault into the simulation. Times are in
+// simulated time since the simulation started.
+type SimulationScenario struct {
+	Fault    string        `mapstructure:"fault"` // see SimulationFaults
+	Start    time.Duration `mapstructure:"start"`
+	Duration time.Duration `mapstructure:"duration"`
+	Repeat   time.Duration `mapstructure:"repeat"` // time between starts, 0 to run once
+}
+
+// BundleConfig holds the settings of the diagnostic bundles made for support
+type BundleConfig struct {
+	History     time.Duration `mapstructure:"history"`       // history included
+	MaxFileSize int64         `mapstructure:"max_file_size"` // bytes of one file, longer files are truncated, 0 for no limit
+	MaxSize     int64         `mapstructure:"max_size"`      // bytes of all files, later files are left out, 0 for no limit
+}
+
+// DefaultConfig returns the configuration used when no config file is present
+func DefaultConfig() Config {
+	return Config{
+		StateDir:       "/var/lib/emmon",
+		SampleInterval: 2 * time.Second,
+		Storage: StorageConfig{
+			PersistInterval: 15 * time.Minute,
+		},
+		WriteBudget: WriteBudgetConfig{
+			TopProcesses:    5,
+			KeepDays:        7,
+			PersistInterval: 15 * time.Minute,
+		},
+		KernelLog: KernelLogConfig{
+			Enabled: true,
+			Path:    "/dev/kmsg",
+			Buffer:  500,
+			Window:  10 * time.Minute,
+		},
+		EventBuffer:      256,
+		InventoryRefresh: time.Hour,
+		TopInterrupts:    10,
+		Watchdog: WatchdogConfig{
+			Device:          "/dev/watchdog",
+			Interval:        10 * time.Second,
+			RequireWritable: true,
+			MaxTemperature:  95,
+		},
+		History: HistoryConfig{
+			Enabled:   true,
+			MaxMemory: 4 << 20,
+			Persist: HistoryPersistConfig{
+				FlushInterval: 15 * time.Minute,
+				MinResolution: time.Minute,
+				SegmentSize:   256 << 10,
+				MaxSize:       8 << 20,
+				MaxAge:        7 * 24 * time.Hour,
+			},
+		},
+		Simulation: SimulationConfig{
+			Speed:         1,
+			Cores:         4,
+			CPUBase:       35,
+			CPUAmplitude:  25,
+			CPUPeriod:     5 * time.Minute,
+			Ambient:       25,
+			MemoryTotal:   1 << 30,
+			DiskTotal:     8 << 30,
+			DiskFillRate:  1 << 20,
+			GPIOPins:      4,
+			GPIOToggle:    5 * time.Second,
+			BurstEvery:    time.Minute,
+			BurstDuration: 10 * time.Second,
+		},
+		Bundle: BundleConfig{
+			History:     6 * time.Hour,
+			MaxFileSize: 4 << 20,
+			MaxSize:     16 << 20,
+		},
+	}
+}
+
+// DefaultHealthRules returns the rules applied when none are configured
+func DefaultHealthRules() []HealthRule {
+	threshold := func(v float64) *float64 { return &v }
+
+	return []HealthRule{
+		{Metric: "cpu.usage_percent", Warning: threshold(85), Critical: threshold(95)},
+		{Metric: "memory.usage_percent", Warning: threshold(85), Critical: threshold(95)},
+		{Metric: "disk.usage_percent", Warning: threshold(85), Critical: threshold(95)},
+		{Metric: "temperature.cpu", Warning: threshold(70), Critical: threshold(85)},
+		{Metric: "writes.*.budget_percent", Warning: threshold(100)},
+		{Metric: "limits.files.percent", Warning: threshold(80), Critical: threshold(95)},
+		{Metric: "limits.pids.percent", Warning: threshold(80), Critical: threshold(95)},
+		{Metric: "limits.conntrack.percent", Warning: threshold(80), Critical: threshold(95)},
+		{Metric: "limits.sockets.orphan_percent", Warning: threshold(80), Critical: threshold(95)},
+		{Metric: "services.*.up", Critical: threshold(1), Below: true},
+	}
+}
+
+// DefaultSimulationScenarios returns the faults simulated when none are
+// configured: a 20 minute script that repeats
+func DefaultSimulationScenarios() []SimulationScenario {
+	const cycle = 20 * time.Minute
+	return []SimulationScenario{
+		{Fault: "overheat", Start: 3 * time.Minute, Duration: 4 * time.Minute, Repeat: cycle},
+		{Fault: "memory_leak", Start: 8 * time.Minute, Duration: 4 * time.Minute, Repeat: cycle},
+		{Fault: "network_down", Start: 13 * time.Minute, Duration: time.Minute, Repeat: cycle},
+		{Fault: "mmc_errors", Start: 15 * time.Minute, Duration: 30 * time.Second, Repeat: cycle},
+		{Fault: "read_only", Start: 16 * time.Minute, Duration: time.Minute, Repeat: cycle},
+		{Fault: "under_voltage", Start: 18 * time.Minute, Duration: 20 * time.Second, Repeat: cycle},
+	}
+}
+
+// DefaultHistoryTiers returns the history resolutions used when none are configured
+func DefaultHistoryTiers() []HistoryTier {
+	return []HistoryTier{
+		{Resolution: 2 * time.Second, Retention: 10 * time.Minute},
+		{Resolution: time.Minute, Retention: 24 * time.Hour},
+		{Resolution: 15 * time.Minute, Retention: 7 * 24 * time.Hour},
+	}
+}
+
+// DefaultHistoryMetrics returns the patterns of the metrics kept in the
+// history when none are configured. When the memory budget runs out, the
+// metrics matching the later patterns are left out first.
+func DefaultHistoryMetrics() []string {
+	return []string{
+		"cpu.*",
+		"memory.*",
+		"disk.*",
+		"temperature.*",
+		"derived.*",
+		"files.*",
+		"services.*.up",
+		"probes.*.up",
+		"probes.*.latency_ms",
+		"vm.*",
+		"limits.*.percent",
+		"interrupts.total",
+		"interrupts.context_switches",
+		"services.*.cpu_percent",
+		"services.*.rss",
+		"custom.*",
+		"writes.*",
+	}
+}
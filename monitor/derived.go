@@ -0,0 +1,86 @@
+package monitor
+
+import "sync"
+
+// DerivedMetric represents the value of a configured expression
+type DerivedMetric struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+// derivedMetric is a configured derived metric with its expression compiled
+type derivedMetric struct {
+	cfg  DerivedMetricConfig
+	expr *Expression
+}
+
+// derivedMetrics evaluates the derived metrics in order. The expressions
+// keep moving average and rate state, so evaluation is serialized.
+type derivedMetrics struct {
+	mu      sync.Mutex
+	metrics []*derivedMetric
+}
+
+// newDerivedMetrics compiles the configured expressions, skipping invalid ones
+func newDerivedMetrics(cfgs []DerivedMetricConfig, sm *SystemMonitor) *derivedMetrics {
+	dm := &derivedMetrics{}
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			sm.log.Errorf("Invalid derived metric: a name is required")
+			continue
+		}
+		expr, err := ParseExpression(cfg.Expr)
+		if err != nil {
+			sm.log.Errorf("Invalid derived metric %s: %v", cfg.Name, err)
+			continue
+		}
+		dm.metrics = append(dm.metrics, &derivedMetric{cfg: cfg, expr: expr})
+	}
+	return dm
+}
+
+// derivedMetricRules turns the thresholds of the derived metrics into health rules
+func derivedMetricRules(cfgs []DerivedMetricConfig) []HealthRule {
+	var rules []HealthRule
+	for _, cfg := range cfgs {
+		if cfg.Warning == nil && cfg.Critical == nil {
+			continue
+		}
+		rules = append(rules, HealthRule{
+			Metric:   "derived." + cfg.Name + ".value",
+			Warning:  cfg.Warning,
+			Critical: cfg.Critical,
+			Below:    cfg.Below,
+		})
+	}
+	return rules
+}
+
+// getDerivedMetrics evaluates the expressions over the collected stats. It runs
+// once per sample, so avg and rate work over samples and their timestamps.
+// Each result is visible to the expressions that follow it as
+// derived.<name>.value.
+func (sm *SystemMonitor) getDerivedMetrics(stats *SystemStats) map[string]DerivedMetric {
+	if len(sm.derived.metrics) == 0 {
+		return nil
+	}
+
+	sm.derived.mu.Lock()
+	defer sm.derived.mu.Unlock()
+
+	metrics := stats.Metrics()
+	results := make(map[string]DerivedMetric, len(sm.derived.metrics))
+	now := stats.Timestamp
+	for _, dm := range sm.derived.metrics {
+		result := DerivedMetric{Unit: dm.cfg.Unit}
+		if value, err := dm.expr.Eval(metrics, now); err == nil {
+			result.Value = value
+			metrics["derived."+dm.cfg.Name+".value"] = value
+		} else {
+			result.Error = err.Error()
+		}
+		results[dm.cfg.Name] = result
+	}
+	return results
+}
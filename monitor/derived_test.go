@@ -0,0 +1,66 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDerivedMetrics(t *testing.T) {
+	critical := 90.0
+	cfg := DefaultConfig()
+	cfg.Derived = []DerivedMetricConfig{
+		{Name: "mem_pressure", Expr: "memory.used / memory.total * 100", Unit: "%", Critical: &critical},
+		{Name: "mem_pressure_avg", Expr: "avg(derived.mem_pressure.value, 2)", Unit: "%"},
+		{Name: "broken", Expr: "memory.used /"},
+		{Name: "unknown", Expr: "no.such.metric"},
+	}
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	sm := NewSystemMonitor(log, cfg)
+
+	stats := &SystemStats{Memory: MemStats{Total: 1000, Used: 950}}
+	derived := sm.getDerivedMetrics(stats)
+	if got := derived["mem_pressure"]; got.Value != 95 || got.Unit != "%" || got.Error != "" {
+		t.Errorf("mem_pressure = %+v", got)
+	}
+	if got := derived["mem_pressure_avg"]; got.Value != 95 || got.Error != "" {
+		t.Errorf("mem_pressure_avg = %+v", got)
+	}
+	if _, ok := derived["broken"]; ok {
+		t.Error("an invalid expression should be skipped")
+	}
+	if derived["unknown"].Error == "" {
+		t.Error("expected an error for an unknown metric")
+	}
+
+	stats.Memory.Used = 850
+	derived = sm.getDerivedMetrics(stats)
+	if got := derived["mem_pressure_avg"].Value; got != 90 {
+		t.Errorf("mem_pressure_avg = %v, want 90", got)
+	}
+
+	stats.Derived = derived
+	if _, ok := stats.Metrics()["derived.mem_pressure.value"]; !ok {
+		t.Error("derived metrics should be exported like native metrics")
+	}
+	stats.Derived = map[string]DerivedMetric{"mem_pressure": {Value: 95}}
+	if health := evaluateHealth(stats, sm.cfg.Health.Rules); health.Level != HealthCritical {
+		t.Errorf("expected the derived metric threshold to raise a critical, got %+v", health)
+	}
+}
+
+func TestFailedDerivedMetric(t *testing.T) {
+	warning := 10.0
+	rules := derivedMetricRules([]DerivedMetricConfig{{Name: "ratio", Warning: &warning, Below: true}})
+	stats := &SystemStats{Derived: map[string]DerivedMetric{"ratio": {Error: "division by zero"}}}
+
+	if _, ok := stats.Metrics()["derived.ratio.value"]; ok {
+		t.Error("expected a failed derived metric to be left out of the metrics")
+	}
+	health := evaluateHealth(stats, rules)
+	if health.Level != HealthFailure || len(health.Reasons) != 1 {
+		t.Errorf("expected the failed derived metric to fail its rule, got %+v", health)
+	}
+}
@@ -0,0 +1,74 @@
+package monitor
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sectorSize is the unit /proc/diskstats reports sectors in, regardless of the device
+const sectorSize = 512
+
+// diskstatsEntry holds the counters of one /proc/diskstats line
+type diskstatsEntry struct {
+	Major        string
+	Minor        string
+	Name         string
+	Reads        uint64
+	ReadSectors  uint64
+	Writes       uint64
+	WriteSectors uint64
+	IOInProgress uint64
+	IOTimeMillis uint64
+}
+
+// BytesWritten returns the number of bytes written to the device since boot
+func (e diskstatsEntry) BytesWritten() uint64 {
+	return e.WriteSectors * sectorSize
+}
+
+// readDiskstats reads the counters of every device in /proc/diskstats
+func readDiskstats() (map[string]diskstatsEntry, error) {
+	file, err := os.Open("/proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseDiskstats(file)
+}
+
+// parseDiskstats parses the /proc/diskstats format
+func parseDiskstats(r io.Reader) (map[string]diskstatsEntry, error) {
+	entries := make(map[string]diskstatsEntry)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// Fields: major minor name reads reads_merged reads_sectors reads_time
+		// writes writes_merged writes_sectors writes_time in_progress io_time ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 {
+			continue
+		}
+
+		counter := func(i int) uint64 {
+			v, _ := strconv.ParseUint(fields[i], 10, 64)
+			return v
+		}
+		entries[fields[2]] = diskstatsEntry{
+			Major:        fields[0],
+			Minor:        fields[1],
+			Name:         fields[2],
+			Reads:        counter(3),
+			ReadSectors:  counter(5),
+			Writes:       counter(7),
+			WriteSectors: counter(9),
+			IOInProgress: counter(11),
+			IOTimeMillis: counter(12),
+		}
+	}
+
+	return entries, scanner.Err()
+}
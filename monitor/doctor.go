@@ -0,0 +1,585 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DoctorReport describes what the collectors can see on this device: the
+// files they read, the sensors and devices present, and file metrics
+// suggested for the sensors no built-in collector reads
+type DoctorReport struct {
+	Time          time.Time          `json:"time"`
+	Root          bool               `json:"root"` // running as root
+	Sources       []DoctorSource     `json:"sources"`
+	ThermalZones  []DoctorDevice     `json:"thermal_zones"`
+	Hwmon         []DoctorDevice     `json:"hwmon"`
+	GPIOChips     []DoctorDevice     `json:"gpio_chips"`
+	BlockDevices  []DoctorDevice     `json:"block_devices"`
+	CPUFreq       []DoctorDevice     `json:"cpufreq_policies"`
+	PowerSupplies []DoctorDevice     `json:"power_supplies"`
+	PSI           []DoctorDevice     `json:"psi"`
+	Notes         []string           `json:"notes,omitempty"`
+	Suggested     []DoctorSuggestion `json:"suggested_file_metrics"`
+}
+
+// DoctorSource is a file or directory a collector reads
+type DoctorSource struct {
+	Collector string `json:"collector"`
+	Path      string `json:"path"`
+	Status    string `json:"status"`             // ok, missing, denied, error or exists (not opened)
+	Optional  bool   `json:"optional,omitempty"` // the collector works without it
+	Error     string `json:"error,omitempty"`
+}
+
+// DoctorDevice is a sensor or device found in sysfs or procfs, with the
+// attributes read from it
+type DoctorDevice struct {
+	Path       string            `json:"path"`
+	Name       string            `json:"name,omitempty"`       // type, label or chip name
+	Attributes map[string]string `json:"attributes,omitempty"` // attribute -> value as read
+	Denied     []string          `json:"denied,omitempty"`     // attributes that exist but cannot be read
+	Note       string            `json:"note,omitempty"`
+}
+
+// DoctorSuggestion is a file metric suggested for a sensor
+type DoctorSuggestion struct {
+	Name  string  `json:"name"`
+	Path  string  `json:"path"`
+	Regex string  `json:"regex,omitempty"`
+	Scale float64 `json:"scale,omitempty"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// thermalZoneMetrics are the metrics the temperature collector reads from
+// the first thermal zones, by zone number
+var thermalZoneMetrics = []string{"temperature.cpu", "temperature.gpu", "temperature.board", "temperature.ambient"}
+
+// hwmonInputs are the scale and unit of the hwmon input types
+var hwmonInputs = map[string]struct {
+	scale float64
+	unit  string
+}{
+	"temp":     {0.001, "°C"},
+	"in":       {0.001, "V"},
+	"curr":     {0.001, "A"},
+	"power":    {0.000001, "W"},
+	"energy":   {0.000001, "J"},
+	"humidity": {0.001, "%"},
+	"fan":      {0, "RPM"},
+}
+
+// Doctor probes the data sources of every collector
+func Doctor(cfg Config) *DoctorReport {
+	return doctor("/", cfg)
+}
+
+// doctorProbe probes the files below root, which is "/" except in tests
+type doctorProbe struct {
+	root   string
+	report *DoctorReport
+}
+
+// doctor probes the data sources below root
+func doctor(root string, cfg Config) *DoctorReport {
+	// Empty lists stay lists in the JSON output
+	d := &doctorProbe{root: root, report: &DoctorReport{
+		Time:          time.Now(),
+		Root:          os.Geteuid() == 0,
+		ThermalZones:  []DoctorDevice{},
+		Hwmon:         []DoctorDevice{},
+		GPIOChips:     []DoctorDevice{},
+		BlockDevices:  []DoctorDevice{},
+		CPUFreq:       []DoctorDevice{},
+		PowerSupplies: []DoctorDevice{},
+		PSI:           []DoctorDevice{},
+		Suggested:     []DoctorSuggestion{},
+	}}
+	d.checkSources(cfg)
+	d.thermalZones()
+	d.hwmon()
+	d.gpioChips()
+	d.blockDevices()
+	d.cpufreq()
+	d.powerSupplies()
+	d.pressure()
+	return d.report
+}
+
+// path returns the location of an absolute device path below the root
+func (d *doctorProbe) path(p string) string {
+	return filepath.Join(d.root, p)
+}
+
+// glob returns the device paths matching a pattern, in natural order so
+// that thermal_zone10 follows thermal_zone9
+func (d *doctorProbe) glob(pattern string) []string {
+	matches, _ := filepath.Glob(d.path(pattern))
+	paths := make([]string, 0, len(matches))
+	for _, match := range matches {
+		rel, err := filepath.Rel(d.root, match)
+		if err == nil {
+			paths = append(paths, "/"+rel)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return naturalLess(paths[i], paths[j]) })
+	return paths
+}
+
+// resolve follows the symlinks of a device path, such as a class link to the
+// device it belongs to
+func (d *doctorProbe) resolve(p string) string {
+	real, err := filepath.EvalSymlinks(d.path(p))
+	if err != nil {
+		return p
+	}
+	rel, err := filepath.Rel(d.root, real)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return p
+	}
+	return "/" + rel
+}
+
+// device reads the attributes of a device. Missing attributes are left
+// out; attributes that cannot be read are listed as denied.
+func (d *doctorProbe) device(dir string, names ...string) DoctorDevice {
+	dev := DoctorDevice{Path: dir, Attributes: make(map[string]string)}
+	for _, name := range names {
+		data, err := ioutil.ReadFile(d.path(filepath.Join(dir, name)))
+		switch {
+		case err == nil:
+			value := strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0])
+			if len(value) > 64 {
+				value = value[:64] + "…"
+			}
+			dev.Attributes[name] = value
+		case os.IsPermission(err):
+			dev.Denied = append(dev.Denied, name)
+		}
+	}
+	return dev
+}
+
+// suggest adds a file metric suggestion
+func (d *doctorProbe) suggest(name, path string, scale float64, unit string) {
+	d.report.Suggested = append(d.report.Suggested, DoctorSuggestion{
+		Name: metricIdentifier(name), Path: path, Scale: scale, Unit: unit,
+	})
+}
+
+// note adds a hint to the report
+func (d *doctorProbe) note(format string, args ...interface{}) {
+	d.report.Notes = append(d.report.Notes, fmt.Sprintf(format, args...))
+}
+
+// checkSources checks that the files and directories the collectors read
+// exist and can be opened
+func (d *doctorProbe) checkSources(cfg Config) {
+	sources := []struct {
+		collector, path string
+		optional        bool
+	}{
+		{"cpu", "/proc/stat", false},
+		{"cpu", "/proc/loadavg", false},
+		{"cpu", "/proc/cpuinfo", false},
+		{"memory", "/proc/meminfo", false},
+		{"disk", "/proc/diskstats", false},
+		{"disk", "/proc/self/mountinfo", false},
+		{"temperature", "/sys/class/thermal", false},
+		{"gpio", "/sys/class/gpio", true},
+		{"storage", "/sys/class/mmc_host", true},
+		{"storage", "/sys/block", false},
+		{"writes", "/proc/1/io", true},
+		{"interrupts", "/proc/interrupts", false},
+		{"interrupts", "/proc/softirqs", false},
+		{"vm", "/proc/vmstat", false},
+		{"limits", "/proc/sys/fs/file-nr", false},
+		{"limits", "/proc/net/sockstat", false},
+		{"limits", "/proc/sys/net/netfilter/nf_conntrack_count", true},
+		{"netconfig", "/proc/net/route", false},
+		{"netconfig", "/proc/net/ipv6_route", true},
+		{"netconfig", "/etc/resolv.conf", true},
+		{"inventory", "/proc/device-tree/model", true},
+		{"watchdog", "/sys/class/watchdog", true},
+		{"indicator", "/sys/class/leds", true},
+	}
+	for _, s := range sources {
+		d.checkSource(s.collector, s.path, s.optional)
+	}
+	if cfg.KernelLog.Enabled {
+		d.checkSource("kernel", cfg.KernelLog.Path, false)
+	}
+	if cfg.Watchdog.Enabled {
+		d.checkSource("watchdog", cfg.Watchdog.Device, false)
+	}
+
+	if !d.report.Root {
+		d.note("Not running as root: the kernel log, the watchdog and the write counters of other users' processes may be denied")
+	}
+	if data, err := ioutil.ReadFile(d.path("/proc/cpuinfo")); err == nil && !bytes.Contains(data, []byte("cpu MHz")) {
+		d.note("/proc/cpuinfo has no \"cpu MHz\", so cpu.frequency stays 0; the cpufreq file metrics below read it instead")
+	}
+}
+
+// checkSource checks one file or directory. The watchdog device is not
+// opened, since opening it arms the watchdog.
+func (d *doctorProbe) checkSource(collector, path string, optional bool) {
+	source := DoctorSource{Collector: collector, Path: path, Optional: optional, Status: "ok"}
+	defer func() { d.report.Sources = append(d.report.Sources, source) }()
+
+	info, err := os.Stat(d.path(path))
+	if err == nil {
+		switch {
+		case info.IsDir():
+			_, err = ioutil.ReadDir(d.path(path))
+		case collector == "watchdog":
+			source.Status = "exists"
+			return
+		default:
+			var f *os.File
+			if f, err = os.Open(d.path(path)); err == nil {
+				f.Close()
+			}
+		}
+	}
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		source.Status = "missing"
+	case os.IsPermission(err):
+		source.Status = "denied"
+	default:
+		source.Status = "error"
+		source.Error = err.Error()
+	}
+}
+
+// thermalZones lists the thermal zones and which temperature each is read as
+func (d *doctorProbe) thermalZones() {
+	for _, dir := range d.glob("/sys/class/thermal/thermal_zone*") {
+		dev := d.device(dir, "type", "temp", "mode")
+		dev.Name = dev.Attributes["type"]
+		zone, _ := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "thermal_zone"))
+		if zone < len(thermalZoneMetrics) {
+			dev.Note = "read as " + thermalZoneMetrics[zone]
+		} else if _, ok := dev.Attributes["temp"]; ok {
+			d.suggest("thermal_"+dev.Name, dir+"/temp", 0.001, "°C")
+		}
+		d.report.ThermalZones = append(d.report.ThermalZones, dev)
+	}
+	if len(d.report.ThermalZones) == 0 {
+		d.note("No thermal zones: the temperature panel stays empty unless hwmon sensors are mapped with file metrics")
+	}
+}
+
+// hwmon lists the hardware monitoring chips and their inputs. Chips that
+// belong to a thermal zone are the same sensor and are not suggested twice.
+func (d *doctorProbe) hwmon() {
+	for _, dir := range d.glob("/sys/class/hwmon/hwmon*") {
+		var names []string
+		for _, input := range d.glob(dir + "/*_input") {
+			prefix := strings.TrimSuffix(filepath.Base(input), "_input")
+			names = append(names, prefix+"_input", prefix+"_label")
+		}
+		dev := d.device(dir, append([]string{"name"}, names...)...)
+		dev.Name = dev.Attributes["name"]
+
+		device := d.resolve(dir)
+		if strings.Contains(device, "/thermal_zone") {
+			dev.Note = "same sensor as " + filepath.Base(filepath.Dir(device))
+			d.report.Hwmon = append(d.report.Hwmon, dev)
+			continue
+		}
+		for _, name := range names {
+			if !strings.HasSuffix(name, "_input") {
+				continue
+			}
+			if _, ok := dev.Attributes[name]; !ok {
+				continue
+			}
+			prefix := strings.TrimSuffix(name, "_input")
+			kind := strings.TrimRight(prefix, "0123456789")
+			input, known := hwmonInputs[kind]
+			if !known {
+				continue
+			}
+			label := dev.Attributes[prefix+"_label"]
+			if label == "" {
+				label = prefix
+			}
+			d.suggest(dev.Name+"_"+label, device+"/"+name, input.scale, input.unit)
+		}
+		d.report.Hwmon = append(d.report.Hwmon, dev)
+	}
+	if len(d.report.Hwmon) > 0 {
+		d.note("hwmon numbers can change between boots; the suggested paths go through the device instead")
+	}
+}
+
+// gpioChips lists the GPIO controllers of the sysfs interface
+func (d *doctorProbe) gpioChips() {
+	for _, dir := range d.glob("/sys/class/gpio/gpiochip*") {
+		dev := d.device(dir, "label", "base", "ngpio")
+		dev.Name = dev.Attributes["label"]
+		d.report.GPIOChips = append(d.report.GPIOChips, dev)
+	}
+	if len(d.report.GPIOChips) > 0 && len(d.glob("/sys/class/gpio/gpio[0-9]*")) == 0 {
+		d.note("No GPIO pins are exported; the GPIO panel shows exported pins only, e.g. echo 17 > /sys/class/gpio/export")
+	}
+	if len(d.report.GPIOChips) == 0 && len(d.glob("/dev/gpiochip*")) > 0 {
+		d.note("GPIO character devices exist but the sysfs GPIO interface does not (CONFIG_GPIO_SYSFS); the GPIO panel stays empty")
+	}
+}
+
+// blockDevices lists the block devices and whether they report wear
+func (d *doctorProbe) blockDevices() {
+	for _, dir := range d.glob("/sys/block/*") {
+		name := filepath.Base(dir)
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		dev := d.device(dir, "size", "removable", "queue/rotational",
+			"device/type", "device/name", "device/model", "device/life_time", "device/pre_eol_info")
+		dev.Name = dev.Attributes["device/name"]
+		if dev.Name == "" {
+			dev.Name = dev.Attributes["device/model"]
+		}
+		if strings.HasPrefix(name, "mmcblk") {
+			if _, ok := dev.Attributes["device/life_time"]; ok {
+				dev.Note = "wear read by the storage collector"
+			} else {
+				dev.Note = "no wear indicators (SD cards and older eMMC do not report them)"
+			}
+		}
+		d.report.BlockDevices = append(d.report.BlockDevices, dev)
+	}
+}
+
+// cpufreq lists the CPU frequency policies, and suggests reading each one
+// when /proc/cpuinfo has no frequency or there are several
+func (d *doctorProbe) cpufreq() {
+	policies := d.glob("/sys/devices/system/cpu/cpufreq/policy*")
+	cpuinfo, _ := ioutil.ReadFile(d.path("/proc/cpuinfo"))
+	suggest := len(policies) > 1 || !bytes.Contains(cpuinfo, []byte("cpu MHz"))
+
+	for _, dir := range policies {
+		dev := d.device(dir, "related_cpus", "scaling_governor", "scaling_cur_freq",
+			"cpuinfo_min_freq", "cpuinfo_max_freq")
+		dev.Name = dev.Attributes["scaling_governor"]
+		if _, ok := dev.Attributes["scaling_cur_freq"]; ok && suggest {
+			d.suggest("cpu_freq_"+filepath.Base(dir), dir+"/scaling_cur_freq", 0.001, "MHz")
+		}
+		d.report.CPUFreq = append(d.report.CPUFreq, dev)
+	}
+}
+
+// powerSupplies lists the power supplies and suggests their readings
+func (d *doctorProbe) powerSupplies() {
+	readings := []struct {
+		attr  string
+		scale float64
+		unit  string
+	}{
+		{"online", 0, ""},
+		{"capacity", 0, "%"},
+		{"voltage_now", 0.000001, "V"},
+		{"current_now", 0.000001, "A"},
+		{"temp", 0.1, "°C"},
+	}
+	for _, dir := range d.glob("/sys/class/power_supply/*") {
+		attrs := []string{"type", "status"}
+		for _, r := range readings {
+			attrs = append(attrs, r.attr)
+		}
+		dev := d.device(dir, attrs...)
+		dev.Name = dev.Attributes["type"]
+		for _, r := range readings {
+			if _, ok := dev.Attributes[r.attr]; ok {
+				d.suggest("power_"+filepath.Base(dir)+"_"+r.attr, dir+"/"+r.attr, r.scale, r.unit)
+			}
+		}
+		d.report.PowerSupplies = append(d.report.PowerSupplies, dev)
+	}
+}
+
+// pressure lists the pressure stall information files and suggests the
+// 10 second averages
+func (d *doctorProbe) pressure() {
+	for _, resource := range []string{"cpu", "memory", "io", "irq"} {
+		path := "/proc/pressure/" + resource
+		data, err := ioutil.ReadFile(d.path(path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		dev := DoctorDevice{Path: path, Name: resource}
+		if err != nil {
+			dev.Denied = []string{resource}
+			d.report.PSI = append(d.report.PSI, dev)
+			continue
+		}
+
+		dev.Attributes = make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			fields := strings.SplitN(line, " ", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			dev.Attributes[fields[0]] = fields[1]
+			d.report.Suggested = append(d.report.Suggested, DoctorSuggestion{
+				Name:  metricIdentifier("psi_" + resource + "_" + fields[0]),
+				Path:  path,
+				Regex: fields[0] + ` avg10=([0-9.]+)`,
+				Unit:  "%",
+			})
+		}
+		d.report.PSI = append(d.report.PSI, dev)
+	}
+	if len(d.report.PSI) == 0 {
+		d.note("No pressure stall information; it needs a kernel with CONFIG_PSI, booted with psi=1 if it is off by default")
+	}
+}
+
+// metricIdentifier turns a sensor name into a metric name of lowercase
+// letters, digits and underscores
+func metricIdentifier(name string) string {
+	var b strings.Builder
+	separate := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if separate && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			separate = false
+			b.WriteRune(r)
+			continue
+		}
+		separate = true
+	}
+	return b.String()
+}
+
+// naturalLess compares strings with runs of digits compared as numbers
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			na, _ := strconv.Atoi(da)
+			nb, _ := strconv.Atoi(db)
+			if na != nb {
+				return na < nb
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+// leadingDigits returns the digits s starts with
+func leadingDigits(s string) string {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return s[:n]
+}
+
+// WriteText prints the report for people, ending with the suggested
+// configuration as YAML
+func (r *DoctorReport) WriteText(w io.Writer) error {
+	user := "not as root"
+	if r.Root {
+		user = "as root"
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "emmon doctor, %s, running %s\n", r.Time.UTC().Format(time.RFC3339), user)
+
+	fmt.Fprintln(tw, "\nData sources")
+	for _, s := range r.Sources {
+		status := s.Status
+		if s.Optional && s.Status == "missing" {
+			status += " (optional)"
+		}
+		if s.Error != "" {
+			status += ": " + s.Error
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", s.Collector, s.Path, status)
+	}
+
+	sections := []struct {
+		title   string
+		devices []DoctorDevice
+	}{
+		{"Thermal zones", r.ThermalZones},
+		{"Hardware monitoring chips", r.Hwmon},
+		{"GPIO chips", r.GPIOChips},
+		{"Block devices", r.BlockDevices},
+		{"CPU frequency policies", r.CPUFreq},
+		{"Power supplies", r.PowerSupplies},
+		{"Pressure stall information", r.PSI},
+	}
+	for _, section := range sections {
+		fmt.Fprintf(tw, "\n%s\n", section.title)
+		if len(section.devices) == 0 {
+			fmt.Fprintln(tw, "  none found")
+		}
+		for _, dev := range section.devices {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", dev.Path, dev.Name, dev.summary())
+		}
+	}
+
+	if len(r.Notes) > 0 {
+		fmt.Fprintln(tw, "\nNotes")
+		for _, note := range r.Notes {
+			fmt.Fprintf(tw, "  - %s\n", note)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.Suggested) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nSuggested configuration, to add to ~/.emmon.yaml:")
+	data, err := json.Marshal(map[string][]DoctorSuggestion{"file_metrics": r.Suggested})
+	if err != nil {
+		return err
+	}
+	return writeYAML(w, data)
+}
+
+// summary lists the attributes of a device, its denied attributes and note
+func (dev DoctorDevice) summary() string {
+	names := make([]string, 0, len(dev.Attributes))
+	for name := range dev.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, name+"="+dev.Attributes[name])
+	}
+	if len(dev.Denied) > 0 {
+		parts = append(parts, "denied: "+strings.Join(dev.Denied, ", "))
+	}
+	if dev.Note != "" {
+		parts = append(parts, "("+dev.Note+")")
+	}
+	return strings.Join(parts, "  ")
+}
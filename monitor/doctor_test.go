@@ -0,0 +1,146 @@
+package monitor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTestBoard creates the sysfs and procfs files of a small ARM board
+func writeTestBoard(t *testing.T, root string) {
+	files := map[string]string{
+		"proc/cpuinfo":                                                 "processor\t: 0\nBogoMIPS\t: 108.00\n",
+		"proc/stat":                                                    "cpu 1 2 3 4\n",
+		"sys/class/thermal/thermal_zone0/type":                         "cpu-thermal\n",
+		"sys/class/thermal/thermal_zone0/temp":                         "48312\n",
+		"sys/class/thermal/thermal_zone4/type":                         "pmic thermal\n",
+		"sys/class/thermal/thermal_zone4/temp":                         "39000\n",
+		"sys/devices/platform/ina219/hwmon/hwmon1/name":                "ina219\n",
+		"sys/devices/platform/ina219/hwmon/hwmon1/in0_input":           "5080\n",
+		"sys/devices/platform/ina219/hwmon/hwmon1/curr1_input":         "812\n",
+		"sys/devices/platform/ina219/hwmon/hwmon1/curr1_label":         "Board\n",
+		"sys/devices/virtual/thermal/thermal_zone0/hwmon0/name":        "cpu_thermal\n",
+		"sys/devices/virtual/thermal/thermal_zone0/hwmon0/temp1_input": "48312\n",
+		"sys/class/gpio/gpiochip0/label":                               "pinctrl-bcm2711\n",
+		"sys/class/gpio/gpiochip0/base":                                "0\n",
+		"sys/class/gpio/gpiochip0/ngpio":                               "58\n",
+		"sys/block/mmcblk0/size":                                       "62333952\n",
+		"sys/block/mmcblk0/device/name":                                "SD32G\n",
+		"sys/block/loop0/size":                                         "0\n",
+		"sys/devices/system/cpu/cpufreq/policy0/scaling_cur_freq":      "1500000\n",
+		"sys/devices/system/cpu/cpufreq/policy0/scaling_governor":      "ondemand\n",
+		"sys/class/power_supply/ups/type":                              "UPS\n",
+		"sys/class/power_supply/ups/capacity":                          "87\n",
+		"proc/pressure/memory":                                         "some avg10=1.50 avg60=0.20 avg300=0.05 total=1234\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+	}
+	for name, content := range files {
+		writeTestFile(t, filepath.Join(root, name), content)
+	}
+	for link, target := range map[string]string{
+		"sys/class/hwmon/hwmon0": "../../devices/virtual/thermal/thermal_zone0/hwmon0",
+		"sys/class/hwmon/hwmon1": "../../devices/platform/ina219/hwmon/hwmon1",
+	} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, link)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDoctorFindsBoardSensors(t *testing.T) {
+	root := t.TempDir()
+	writeTestBoard(t, root)
+	report := doctor(root, DefaultConfig())
+
+	if len(report.ThermalZones) != 2 || report.ThermalZones[0].Note != "read as temperature.cpu" {
+		t.Errorf("thermal zones = %+v", report.ThermalZones)
+	}
+	if len(report.Hwmon) != 2 || report.Hwmon[0].Note != "same sensor as thermal_zone0" {
+		t.Errorf("hwmon = %+v", report.Hwmon)
+	}
+	if len(report.BlockDevices) != 1 || !strings.HasPrefix(report.BlockDevices[0].Note, "no wear indicators") {
+		t.Errorf("block devices = %+v, want mmcblk0 without loop0", report.BlockDevices)
+	}
+	if len(report.GPIOChips) != 1 || report.GPIOChips[0].Name != "pinctrl-bcm2711" {
+		t.Errorf("gpio chips = %+v", report.GPIOChips)
+	}
+
+	want := []DoctorSuggestion{
+		{Name: "thermal_pmic_thermal", Path: "/sys/class/thermal/thermal_zone4/temp", Scale: 0.001, Unit: "°C"},
+		{Name: "ina219_board", Path: "/sys/devices/platform/ina219/hwmon/hwmon1/curr1_input", Scale: 0.001, Unit: "A"},
+		{Name: "ina219_in0", Path: "/sys/devices/platform/ina219/hwmon/hwmon1/in0_input", Scale: 0.001, Unit: "V"},
+		{Name: "cpu_freq_policy0", Path: "/sys/devices/system/cpu/cpufreq/policy0/scaling_cur_freq", Scale: 0.001, Unit: "MHz"},
+		{Name: "power_ups_capacity", Path: "/sys/class/power_supply/ups/capacity", Unit: "%"},
+		{Name: "psi_memory_some", Path: "/proc/pressure/memory", Regex: "some avg10=([0-9.]+)", Unit: "%"},
+		{Name: "psi_memory_full", Path: "/proc/pressure/memory", Regex: "full avg10=([0-9.]+)", Unit: "%"},
+	}
+	if !reflect.DeepEqual(report.Suggested, want) {
+		t.Errorf("suggested =\n%+v\nwant\n%+v", report.Suggested, want)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"  - No GPIO pins are exported",
+		"  - /proc/cpuinfo has no \"cpu MHz\"",
+		"file_metrics:\n  - name: thermal_pmic_thermal\n    path: /sys/class/thermal/thermal_zone4/temp\n    scale: 0.001\n",
+	} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("report does not contain %q:\n%s", line, text.String())
+		}
+	}
+}
+
+func TestDoctorSourceStatus(t *testing.T) {
+	root := t.TempDir()
+	writeTestBoard(t, root)
+	cfg := DefaultConfig()
+	cfg.Watchdog.Enabled = true
+	writeTestFile(t, filepath.Join(root, "dev/watchdog"), "")
+	report := doctor(root, cfg)
+
+	status := make(map[string]string)
+	for _, s := range report.Sources {
+		status[s.Path] = s.Status
+	}
+	for path, want := range map[string]string{
+		"/proc/stat":         "ok",
+		"/sys/class/thermal": "ok",
+		"/proc/meminfo":      "missing",
+		"/dev/kmsg":          "missing",
+		"/dev/watchdog":      "exists",
+	} {
+		if status[path] != want {
+			t.Errorf("%s is %q, want %q", path, status[path], want)
+		}
+	}
+}
+
+func TestNaturalLess(t *testing.T) {
+	got := []string{"thermal_zone10", "thermal_zone2", "policy4", "thermal_zone1", "policy0"}
+	sort.Slice(got, func(i, j int) bool { return naturalLess(got[i], got[j]) })
+	want := []string{"policy0", "policy4", "thermal_zone1", "thermal_zone2", "thermal_zone10"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted = %v, want %v", got, want)
+	}
+}
+
+func TestMetricIdentifier(t *testing.T) {
+	for name, want := range map[string]string{
+		"cpu-thermal":        "cpu_thermal",
+		"INA219 Board (5V)":  "ina219_board_5v",
+		"power_BAT0_voltage": "power_bat0_voltage",
+	} {
+		if got := metricIdentifier(name); got != want {
+			t.Errorf("metricIdentifier(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+)
+
+// Event represents something noteworthy that happened on the device, such
+// as a kernel log message matching a rule
+type Event struct {
+	Time     time.Time   `json:"time"`
+	Source   string      `json:"source"` // collector that raised the event, e.g. "kmsg"
+	Name     string      `json:"name"`
+	Severity HealthLevel `json:"severity"`
+	Message  string      `json:"message"`
+}
+
+// eventLog keeps the most recent events in a fixed-size ring
+type eventLog struct {
+	mu     sync.RWMutex
+	events []Event
+	next   int
+	full   bool
+	added  uint64 // events added so far
+}
+
+// newEventLog creates an event log holding up to size events
+func newEventLog(size int) *eventLog {
+	if size < 1 {
+		size = 1
+	}
+	return &eventLog{
+		events: make([]Event, size),
+	}
+}
+
+// add appends an event, overwriting the oldest one when the ring is full
+func (el *eventLog) add(event Event) {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	el.events[el.next] = event
+	el.added++
+	el.next = (el.next + 1) % len(el.events)
+	if el.next == 0 {
+		el.full = true
+	}
+}
+
+// list returns the events oldest first
+func (el *eventLog) list() []Event {
+	el.mu.RLock()
+	defer el.mu.RUnlock()
+
+	return el.held()
+}
+
+// since returns the events added after the first n that are still held,
+// oldest first, and the number of events added so far
+func (el *eventLog) since(n uint64) ([]Event, uint64) {
+	el.mu.RLock()
+	defer el.mu.RUnlock()
+
+	events := el.held()
+	if missed := el.added - n; missed < uint64(len(events)) {
+		events = events[len(events)-int(missed):]
+	}
+	return events, el.added
+}
+
+// held copies the events in the ring, oldest first. The caller holds mu.
+func (el *eventLog) held() []Event {
+	if !el.full {
+		return append([]Event(nil), el.events[:el.next]...)
+	}
+	return append(append([]Event(nil), el.events[el.next:]...), el.events[:el.next]...)
+}
+
+// emitEvent logs an event and keeps it in the event log
+func (sm *SystemMonitor) emitEvent(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	entry := sm.log.WithField("source", event.Source).WithField("event", event.Name)
+	switch event.Severity {
+	case HealthCritical, HealthFailure:
+		entry.Errorf("%s", event.Message)
+	case HealthWarning:
+		entry.Warnf("%s", event.Message)
+	default:
+		entry.Infof("%s", event.Message)
+	}
+
+	sm.events.add(event)
+}
+
+// RecentEvents returns the most recent events, oldest first
+func (sm *SystemMonitor) RecentEvents() []Event {
+	return sm.events.list()
+}
@@ -0,0 +1,541 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits that keep a configured expression from using unbounded resources
+const (
+	maxExprLength = 4096
+	maxExprDepth  = 64
+	maxAvgSamples = 10000
+)
+
+// Expression is a compiled arithmetic expression over metric names, e.g.
+// "memory.used / memory.total * 100". It supports + - * / % ^, comparisons
+// (< <= > >= == !=) and && || ! which yield 1 or 0, parentheses, and the
+// functions abs, min, max, sqrt, log, avg(x, n) for a moving average over n
+// evaluations and rate(x) for the per-second change since the previous one.
+// Metric names containing other characters than letters, digits, "_" and "."
+// can be quoted with backticks. Expressions cannot do anything but compute a
+// number; avg and rate keep state, so each expression must be evaluated by
+// one goroutine at a time, and every part of it is evaluated every time.
+type Expression struct {
+	source string
+	root   exprNode
+}
+
+// exprEnv is what an expression is evaluated against
+type exprEnv struct {
+	metrics map[string]float64
+	now     time.Time
+}
+
+// exprNode is a node of the expression tree
+type exprNode interface {
+	eval(env *exprEnv) (float64, error)
+}
+
+// ParseExpression compiles an expression
+func ParseExpression(source string) (*Expression, error) {
+	if len(source) > maxExprLength {
+		return nil, fmt.Errorf("expression longer than %d characters", maxExprLength)
+	}
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+	}
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against the metrics. Referencing a metric
+// that does not exist, dividing by zero or any other result that is not a
+// finite number is an error.
+func (e *Expression) Eval(metrics map[string]float64, now time.Time) (float64, error) {
+	value, err := e.root.eval(&exprEnv{metrics: metrics, now: now})
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("result is not a finite number")
+	}
+	return value, nil
+}
+
+// Token kinds of the expression language
+const (
+	tokEOF = iota
+	tokNumber
+	tokIdent
+	tokOp
+)
+
+// exprToken is a lexical token with its offset in the source
+type exprToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+// tokenizeExpr splits an expression into tokens
+func tokenizeExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9':
+			start := i
+			for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+				i++
+			}
+			if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+				i++
+				if i < len(s) && (s[i] == '+' || s[i] == '-') {
+					i++
+				}
+				for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+					i++
+				}
+			}
+			tokens = append(tokens, exprToken{tokNumber, s[start:i], start})
+		case isIdentStart(c):
+			start := i
+			for i < len(s) && (isIdentStart(s[i]) || s[i] == '.' || s[i] >= '0' && s[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, exprToken{tokIdent, s[start:i], start})
+		case c == '`':
+			end := strings.IndexByte(s[i+1:], '`')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted name at offset %d", i)
+			}
+			tokens = append(tokens, exprToken{tokIdent, s[i+1 : i+1+end], i})
+			i += end + 2
+		default:
+			op := string(c)
+			if i+1 < len(s) {
+				switch two := s[i : i+2]; two {
+				case "<=", ">=", "==", "!=", "&&", "||":
+					op = two
+				}
+			}
+			switch op {
+			case "+", "-", "*", "/", "%", "^", "(", ")", ",", "<", ">", "!", "<=", ">=", "==", "!=", "&&", "||":
+			default:
+				return nil, fmt.Errorf("unexpected %q at offset %d", op, i)
+			}
+			tokens = append(tokens, exprToken{tokOp, op, i})
+			i += len(op)
+		}
+	}
+	return append(tokens, exprToken{kind: tokEOF, pos: len(s)}), nil
+}
+
+// isIdentStart reports whether c can start a metric or function name
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// exprParser is a recursive descent parser over the tokens
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+// peek returns the next token without consuming it
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+// next consumes and returns the next token
+func (p *exprParser) next() exprToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token if it is one of the operators
+func (p *exprParser) accept(ops ...string) (string, bool) {
+	tok := p.peek()
+	if tok.kind != tokOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if tok.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+// binaryLevel parses one precedence level of left-associative operators
+func (p *exprParser) binaryLevel(depth int, operand func(int) (exprNode, error), ops ...string) (exprNode, error) {
+	left, err := operand(depth)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := operand(depth)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseOr(depth int) (exprNode, error) {
+	return p.binaryLevel(depth, p.parseAnd, "||")
+}
+
+func (p *exprParser) parseAnd(depth int) (exprNode, error) {
+	return p.binaryLevel(depth, p.parseComparison, "&&")
+}
+
+func (p *exprParser) parseComparison(depth int) (exprNode, error) {
+	return p.binaryLevel(depth, p.parseAdditive, "<", "<=", ">", ">=", "==", "!=")
+}
+
+func (p *exprParser) parseAdditive(depth int) (exprNode, error) {
+	return p.binaryLevel(depth, p.parseMultiplicative, "+", "-")
+}
+
+func (p *exprParser) parseMultiplicative(depth int) (exprNode, error) {
+	return p.binaryLevel(depth, p.parseUnary, "*", "/", "%")
+}
+
+// parseUnary parses negation and logical not. Every nested construct passes
+// through here, so this is where the nesting depth is limited.
+func (p *exprParser) parseUnary(depth int) (exprNode, error) {
+	if depth > maxExprDepth {
+		return nil, fmt.Errorf("expression nested deeper than %d levels", maxExprDepth)
+	}
+	if op, ok := p.accept("-", "!"); ok {
+		operand, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePower(depth)
+}
+
+// parsePower parses the right-associative exponent operator
+func (p *exprParser) parsePower(depth int) (exprNode, error) {
+	base, err := p.parsePrimary(depth)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("^"); ok {
+		exponent, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &binaryNode{op: "^", left: base, right: exponent}, nil
+	}
+	return base, nil
+}
+
+// parsePrimary parses numbers, metric names, function calls and parentheses
+func (p *exprParser) parsePrimary(depth int) (exprNode, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+		}
+		return numberNode(value), nil
+	case tokIdent:
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(tok, depth)
+		}
+		return metricNode(tok.text), nil
+	case tokOp:
+		if tok.text == "(" {
+			inner, err := p.parseOr(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.accept(")"); !ok {
+				return nil, fmt.Errorf("missing ) for ( at offset %d", tok.pos)
+			}
+			return inner, nil
+		}
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+// parseCall parses the arguments of a function call and checks its arity
+func (p *exprParser) parseCall(name exprToken, depth int) (exprNode, error) {
+	var args []exprNode
+	if _, ok := p.accept(")"); !ok {
+		for {
+			arg, err := p.parseOr(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if _, ok := p.accept(")"); ok {
+				break
+			}
+			if _, ok := p.accept(","); !ok {
+				return nil, fmt.Errorf("expected , or ) in call to %s at offset %d", name.text, name.pos)
+			}
+		}
+	}
+
+	switch name.text {
+	case "abs", "sqrt", "log", "rate":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%s takes 1 argument", name.text)
+		}
+	case "min", "max":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("%s takes at least 1 argument", name.text)
+		}
+	case "avg":
+		n, ok := args2Const(args)
+		if !ok || n < 1 || n > maxAvgSamples || n != math.Trunc(n) {
+			return nil, fmt.Errorf("avg takes an expression and a sample count from 1 to %d", maxAvgSamples)
+		}
+		return &avgNode{operand: args[0], samples: make([]float64, int(n))}, nil
+	default:
+		return nil, fmt.Errorf("unknown function %s at offset %d", name.text, name.pos)
+	}
+
+	if name.text == "rate" {
+		return &rateNode{operand: args[0]}, nil
+	}
+	return &callNode{name: name.text, args: args}, nil
+}
+
+// args2Const returns the second of two arguments when it is a constant
+func args2Const(args []exprNode) (float64, bool) {
+	if len(args) != 2 {
+		return 0, false
+	}
+	n, ok := args[1].(numberNode)
+	return float64(n), ok
+}
+
+// numberNode is a numeric literal
+type numberNode float64
+
+func (n numberNode) eval(env *exprEnv) (float64, error) {
+	return float64(n), nil
+}
+
+// metricNode looks up a metric by name
+type metricNode string
+
+func (n metricNode) eval(env *exprEnv) (float64, error) {
+	value, ok := env.metrics[string(n)]
+	if !ok {
+		return 0, fmt.Errorf("unknown metric %s", string(n))
+	}
+	return value, nil
+}
+
+// unaryNode negates its operand
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n *unaryNode) eval(env *exprEnv) (float64, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	if n.op == "!" {
+		return boolValue(value == 0), nil
+	}
+	return -value, nil
+}
+
+// binaryNode applies an operator to two operands
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *binaryNode) eval(env *exprEnv) (float64, error) {
+	// Both sides are evaluated every time, so avg and rate on either side
+	// see every sample
+	left, err := n.left.eval(env)
+	right, rightErr := n.right.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	// && and || do not depend on the right side when the left decides
+	switch {
+	case n.op == "&&" && left == 0:
+		return 0, nil
+	case n.op == "||" && left != 0:
+		return 1, nil
+	}
+	if rightErr != nil {
+		return 0, rightErr
+	}
+
+	switch n.op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/", "%":
+		if right == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if n.op == "%" {
+			return math.Mod(left, right), nil
+		}
+		return left / right, nil
+	case "^":
+		return math.Pow(left, right), nil
+	case "<":
+		return boolValue(left < right), nil
+	case "<=":
+		return boolValue(left <= right), nil
+	case ">":
+		return boolValue(left > right), nil
+	case ">=":
+		return boolValue(left >= right), nil
+	case "==":
+		return boolValue(left == right), nil
+	case "!=":
+		return boolValue(left != right), nil
+	case "&&", "||":
+		return boolValue(right != 0), nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", n.op)
+}
+
+// callNode calls a stateless function
+type callNode struct {
+	name string
+	args []exprNode
+}
+
+func (n *callNode) eval(env *exprEnv) (float64, error) {
+	// Every argument is evaluated, so stateful ones see every sample
+	values := make([]float64, len(n.args))
+	var firstErr error
+	for i, arg := range n.args {
+		value, err := arg.eval(env)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		values[i] = value
+	}
+	if firstErr != nil {
+		return 0, firstErr
+	}
+
+	switch n.name {
+	case "abs":
+		return math.Abs(values[0]), nil
+	case "sqrt":
+		return math.Sqrt(values[0]), nil
+	case "log":
+		return math.Log(values[0]), nil
+	case "min", "max":
+		result := values[0]
+		for _, value := range values[1:] {
+			if n.name == "min" {
+				result = math.Min(result, value)
+			} else {
+				result = math.Max(result, value)
+			}
+		}
+		return result, nil
+	}
+	return 0, fmt.Errorf("unknown function %s", n.name)
+}
+
+// avgNode averages its operand over the last evaluations
+type avgNode struct {
+	operand exprNode
+	samples []float64
+	next    int
+	count   int
+}
+
+func (n *avgNode) eval(env *exprEnv) (float64, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	n.samples[n.next] = value
+	n.next = (n.next + 1) % len(n.samples)
+	if n.count < len(n.samples) {
+		n.count++
+	}
+
+	sum := 0.0
+	for _, sample := range n.samples[:n.count] {
+		sum += sample
+	}
+	return sum / float64(n.count), nil
+}
+
+// rateNode returns the per-second change of its operand
+type rateNode struct {
+	operand exprNode
+	prev    float64
+	time    time.Time
+}
+
+func (n *rateNode) eval(env *exprEnv) (float64, error) {
+	value, err := n.operand.eval(env)
+	if err != nil {
+		return 0, err
+	}
+	prev, prevTime := n.prev, n.time
+	n.prev, n.time = value, env.now
+
+	seconds := env.now.Sub(prevTime).Seconds()
+	if prevTime.IsZero() || seconds <= 0 {
+		return 0, nil
+	}
+	return (value - prev) / seconds, nil
+}
+
+// boolValue converts a truth value to 1 or 0
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,134 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpressionEval(t *testing.T) {
+	metrics := map[string]float64{
+		"memory.used":         6,
+		"memory.total":        8,
+		"psi.memory.some10":   2.5,
+		"temperature.cpu":     61,
+		"temperature.ambient": 24,
+		"files.fan-rpm.value": 1200,
+	}
+	cases := []struct {
+		expr string
+		want float64
+	}{
+		{"memory.used / memory.total * 100 + psi.memory.some10", 77.5},
+		{"temperature.cpu - temperature.ambient", 37},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"2 ^ 3 ^ 2", 512},
+		{"-2 ^ 2", -4},
+		{"7 % 4", 3},
+		{"abs(-3) + sqrt(16) + min(5, 2, 9) + max(1, 4)", 13},
+		{"log(1)", 0},
+		{"temperature.cpu > 60 && memory.used < memory.total", 1},
+		{"temperature.cpu >= 70 || !memory.used", 0},
+		{"1 == 1 != 0", 1},
+		{"`files.fan-rpm.value` / 60", 20},
+		{"0 && 1 / 0", 0},
+	}
+	now := time.Now()
+	for _, c := range cases {
+		expr, err := ParseExpression(c.expr)
+		if err != nil {
+			t.Errorf("ParseExpression(%q): %v", c.expr, err)
+			continue
+		}
+		got, err := expr.Eval(metrics, now)
+		if err != nil || got != c.want {
+			t.Errorf("%q = %v, %v; want %v", c.expr, got, err, c.want)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	for _, source := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"foo(1)",
+		"abs(1, 2)",
+		"avg(cpu.usage, 0)",
+		"avg(cpu.usage, memory.used)",
+		"1 $ 2",
+		"`unterminated",
+		strings.Repeat("(", 100) + "1" + strings.Repeat(")", 100),
+		strings.Repeat("1+", 3000) + "1",
+	} {
+		if _, err := ParseExpression(source); err == nil {
+			t.Errorf("expected a parse error for %.40q", source)
+		}
+	}
+
+	now := time.Now()
+	for _, source := range []string{"missing.metric + 1", "1 / 0", "1 % 0", "sqrt(-1)", "log(0)"} {
+		expr, err := ParseExpression(source)
+		if err != nil {
+			t.Errorf("ParseExpression(%q): %v", source, err)
+			continue
+		}
+		if _, err := expr.Eval(map[string]float64{}, now); err == nil {
+			t.Errorf("expected an evaluation error for %q", source)
+		}
+	}
+}
+
+func TestExpressionAvgAndRate(t *testing.T) {
+	avg, err := ParseExpression("avg(cpu.usage, 3)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rate, err := ParseExpression("rate(disk.read_bytes)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	wantAvg := []float64{10, 15, 20, 30}
+	wantRate := []float64{0, 100, 100, 100}
+	for i, usage := range []float64{10, 20, 30, 40} {
+		metrics := map[string]float64{"cpu.usage": usage, "disk.read_bytes": float64(i) * 200}
+		now := start.Add(time.Duration(i) * 2 * time.Second)
+
+		if got, err := avg.Eval(metrics, now); err != nil || got != wantAvg[i] {
+			t.Errorf("sample %d: avg = %v, %v; want %v", i, got, err, wantAvg[i])
+		}
+		if got, err := rate.Eval(metrics, now); err != nil || got != wantRate[i] {
+			t.Errorf("sample %d: rate = %v, %v; want %v", i, got, err, wantRate[i])
+		}
+	}
+}
+
+func TestExpressionEvaluatesBothSides(t *testing.T) {
+	expr, err := ParseExpression("enabled && avg(x, 2) > 15")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The average keeps its window while the left side decides the result
+	start := time.Now()
+	samples := []struct{ enabled, x, want float64 }{{1, 10, 0}, {0, 20, 0}, {1, 20, 1}}
+	for i, s := range samples {
+		metrics := map[string]float64{"enabled": s.enabled, "x": s.x}
+		if got, err := expr.Eval(metrics, start.Add(time.Duration(i)*time.Second)); err != nil || got != s.want {
+			t.Errorf("sample %d: got %v, %v; want %v", i, got, err, s.want)
+		}
+	}
+
+	// A right side that fails does not matter once the left side decides
+	expr, err = ParseExpression("0 && missing > 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := expr.Eval(map[string]float64{}, start); err != nil || got != 0 {
+		t.Errorf("got %v, %v; want 0", got, err)
+	}
+}
@@ -0,0 +1,134 @@
+package monitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FileMetric represents a value read from a sysfs, procfs or other file
+type FileMetric struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+	Error string  `json:"error,omitempty"`
+}
+
+// fileMetric is a configured file metric with its regex compiled
+type fileMetric struct {
+	cfg   FileMetricConfig
+	regex *regexp.Regexp
+}
+
+// newFileMetrics compiles the configured file metrics, skipping invalid ones
+func newFileMetrics(cfgs []FileMetricConfig, sm *SystemMonitor) []*fileMetric {
+	var metrics []*fileMetric
+	for _, cfg := range cfgs {
+		if cfg.Name == "" || cfg.Path == "" {
+			sm.log.Errorf("Invalid file metric %q: name and path are required", cfg.Name)
+			continue
+		}
+		fm := &fileMetric{cfg: cfg}
+		if cfg.Regex != "" {
+			re, err := regexp.Compile(cfg.Regex)
+			if err != nil {
+				sm.log.Errorf("Invalid file metric %s: %v", cfg.Name, err)
+				continue
+			}
+			fm.regex = re
+		}
+		metrics = append(metrics, fm)
+	}
+	return metrics
+}
+
+// fileMetricRules turns the thresholds of the file metrics into health rules
+func fileMetricRules(cfgs []FileMetricConfig) []HealthRule {
+	var rules []HealthRule
+	for _, cfg := range cfgs {
+		if cfg.Warning == nil && cfg.Critical == nil {
+			continue
+		}
+		rules = append(rules, HealthRule{
+			Metric:   "files." + cfg.Name + ".value",
+			Warning:  cfg.Warning,
+			Critical: cfg.Critical,
+			Below:    cfg.Below,
+		})
+	}
+	return rules
+}
+
+// getFileMetrics reads every configured file metric. A file that cannot be
+// read or parsed only marks its own metric with the error.
+func (sm *SystemMonitor) getFileMetrics() map[string]FileMetric {
+	if len(sm.fileMetrics) == 0 {
+		return nil
+	}
+
+	metrics := make(map[string]FileMetric, len(sm.fileMetrics))
+	for _, fm := range sm.fileMetrics {
+		metric := FileMetric{Unit: fm.cfg.Unit}
+		if value, err := readFileMetric(fm.cfg.Path, fm.regex, fm.cfg.Field, fm.cfg.Scale, fm.cfg.Offset); err == nil {
+			metric.Value = value
+		} else {
+			metric.Error = err.Error()
+		}
+		metrics[fm.cfg.Name] = metric
+	}
+	return metrics
+}
+
+// readFileMetric reads a number from a file and returns value*scale+offset.
+// A scale of 0 counts as 1.
+func readFileMetric(path string, regex *regexp.Regexp, field int, scale, offset float64) (float64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value, err := parseFileValue(string(data), regex, field)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if scale == 0 {
+		scale = 1
+	}
+	return value*scale + offset, nil
+}
+
+// parseFileValue extracts a number from file content: the first capture group
+// (or the whole match) of the regex, the 1-based whitespace-separated field,
+// or else the whole trimmed content. Integers may be written in hex with 0x.
+func parseFileValue(content string, regex *regexp.Regexp, field int) (float64, error) {
+	text := strings.TrimSpace(content)
+
+	switch {
+	case regex != nil:
+		match := regex.FindStringSubmatch(content)
+		if match == nil {
+			return 0, fmt.Errorf("no match for %q", regex.String())
+		}
+		text = match[0]
+		if len(match) > 1 {
+			text = match[1]
+		}
+	case field > 0:
+		fields := strings.Fields(content)
+		if field > len(fields) {
+			return 0, fmt.Errorf("field %d not found in %d fields", field, len(fields))
+		}
+		text = fields[field-1]
+	}
+
+	text = strings.TrimSpace(text)
+	if value, err := strconv.ParseFloat(text, 64); err == nil {
+		return value, nil
+	}
+	if value, err := strconv.ParseInt(text, 0, 64); err == nil {
+		return float64(value), nil
+	}
+	return 0, fmt.Errorf("not a number: %q", text)
+}
@@ -0,0 +1,87 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseFileValue(t *testing.T) {
+	cases := []struct {
+		content string
+		regex   string
+		field   int
+		want    float64
+	}{
+		{"42000\n", "", 0, 42000},
+		{"0x1f\n", "", 0, 31},
+		{"12 34 56\n", "", 2, 34},
+		{"voltage: 3.3 V\ncurrent: 0.5 A\n", `current: ([\d.]+)`, 0, 0.5},
+		{"rssi=-71", `-\d+`, 0, -71},
+	}
+	for _, c := range cases {
+		var re *regexp.Regexp
+		if c.regex != "" {
+			re = regexp.MustCompile(c.regex)
+		}
+		got, err := parseFileValue(c.content, re, c.field)
+		if err != nil || got != c.want {
+			t.Errorf("parseFileValue(%q, %q, %d) = %v, %v; want %v", c.content, c.regex, c.field, got, err, c.want)
+		}
+	}
+
+	if _, err := parseFileValue("1 2", nil, 3); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+	if _, err := parseFileValue("n/a", nil, 0); err == nil {
+		t.Error("expected an error for a non-numeric value")
+	}
+}
+
+func TestFileMetrics(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "in_voltage0_raw"), "2048\n")
+
+	warning := 3.0
+	cfg := DefaultConfig()
+	cfg.StateDir = dir
+	cfg.FileMetrics = []FileMetricConfig{
+		{Name: "supply", Path: filepath.Join(dir, "in_voltage0_raw"), Scale: 0.001, Offset: 1, Unit: "V",
+			Warning: &warning, Below: true},
+		{Name: "missing", Path: filepath.Join(dir, "missing")},
+	}
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	sm := NewSystemMonitor(log, cfg)
+
+	metrics := sm.getFileMetrics()
+	if got := metrics["supply"]; got.Value != 3.048 || got.Unit != "V" || got.Error != "" {
+		t.Errorf("supply = %+v", got)
+	}
+	if metrics["missing"].Error == "" {
+		t.Error("expected an error for a missing file")
+	}
+
+	stats := &SystemStats{Files: map[string]FileMetric{"supply": {Value: 2.9}}}
+	health := evaluateHealth(stats, sm.cfg.Health.Rules)
+	if health.Level != HealthWarning {
+		t.Errorf("expected the file metric threshold to raise a warning, got %+v", health)
+	}
+}
+
+func TestUnreadableFileMetric(t *testing.T) {
+	critical := 3.0
+	rules := fileMetricRules([]FileMetricConfig{{Name: "supply", Critical: &critical, Below: true}})
+	stats := &SystemStats{Files: map[string]FileMetric{"supply": {Error: "no such file"}}}
+
+	if _, ok := stats.Metrics()["files.supply.value"]; ok {
+		t.Error("expected an unreadable file metric to be left out of the metrics")
+	}
+	health := evaluateHealth(stats, rules)
+	if health.Level != HealthFailure || len(health.Reasons) != 1 {
+		t.Errorf("expected the unreadable file metric to fail its rule, got %+v", health)
+	}
+}
@@ -0,0 +1,292 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// The sample encoding follows Facebook's Gorilla: timestamps are stored as
+// the difference between successive deltas, which is 0 for a regular series
+// and takes a single bit, and values as the XOR with the previous value,
+// which for slowly changing values has long runs of zero bits that are left
+// out. A series of regular samples of a steady value takes about 2 bits per
+// sample, and noisy values up to about 9 bytes.
+
+// errGorillaData reports an encoded series that ends early
+var errGorillaData = errors.New("truncated gorilla data")
+
+// bitWriter appends bits to a byte slice, most significant bit first
+type bitWriter struct {
+	buf  []byte
+	free uint8 // unused bits in the last byte
+}
+
+// writeBit appends one bit
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.buf = append(w.buf, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.free
+	}
+}
+
+// writeBits appends the n low bits of v
+func (w *bitWriter) writeBits(v uint64, n int) {
+	for n > 0 {
+		if w.free == 0 {
+			w.buf = append(w.buf, 0)
+			w.free = 8
+		}
+		take := int(w.free)
+		if take > n {
+			take = n
+		}
+		n -= take
+		w.free -= uint8(take)
+		w.buf[len(w.buf)-1] |= byte((v>>uint(n))&(1<<uint(take)-1)) << w.free
+	}
+}
+
+// bitReader reads bits written by a bitWriter
+type bitReader struct {
+	buf []byte
+	pos int // bit position
+	err error
+}
+
+// readBit reads one bit
+func (r *bitReader) readBit() bool {
+	if r.pos >= len(r.buf)*8 {
+		r.err = errGorillaData
+		return false
+	}
+	bit := r.buf[r.pos/8]&(0x80>>uint(r.pos%8)) != 0
+	r.pos++
+	return bit
+}
+
+// readBits reads n bits into the low bits of the result
+func (r *bitReader) readBits(n int) uint64 {
+	if r.pos+n > len(r.buf)*8 {
+		r.err = errGorillaData
+		return 0
+	}
+	var v uint64
+	for n > 0 {
+		used := r.pos % 8
+		take := 8 - used
+		if take > n {
+			take = n
+		}
+		b := uint64(r.buf[r.pos/8]) >> uint(8-used-take) & (1<<uint(take) - 1)
+		v = v<<uint(take) | b
+		r.pos += take
+		n -= take
+	}
+	return v
+}
+
+// timestampEncoder writes timestamps as delta-of-deltas
+type timestampEncoder struct {
+	prev  int64
+	delta int64
+	count int
+}
+
+// The delta-of-delta ranges and their prefixes: 0 is "0", then "10", "110"
+// and "1110" with a 7, 9 or 12 bit signed value, and "1111" with 64 bits
+var dodRanges = []struct {
+	bits   int
+	prefix uint64
+	plen   int
+}{{7, 0x2, 2}, {9, 0x6, 3}, {12, 0xe, 4}}
+
+// encode appends a timestamp. The first one is written in full.
+func (e *timestampEncoder) encode(w *bitWriter, t int64) {
+	defer func() { e.count++ }()
+	if e.count == 0 {
+		w.writeBits(uint64(t), 64)
+		e.prev = t
+		return
+	}
+
+	delta := t - e.prev
+	dod := delta - e.delta
+	e.prev, e.delta = t, delta
+
+	if dod == 0 {
+		w.writeBit(false)
+		return
+	}
+	for _, r := range dodRanges {
+		if dod >= -(1<<uint(r.bits-1)) && dod < 1<<uint(r.bits-1) {
+			w.writeBits(r.prefix, r.plen)
+			w.writeBits(uint64(dod), r.bits)
+			return
+		}
+	}
+	w.writeBits(0xf, 4)
+	w.writeBits(uint64(dod), 64)
+}
+
+// decode reads a timestamp
+func (e *timestampEncoder) decode(r *bitReader) int64 {
+	defer func() { e.count++ }()
+	if e.count == 0 {
+		e.prev = int64(r.readBits(64))
+		return e.prev
+	}
+
+	var dod int64
+	if r.readBit() {
+		n := 64
+		for _, dr := range dodRanges {
+			if !r.readBit() {
+				n = dr.bits
+				break
+			}
+		}
+		dod = signExtend(r.readBits(n), n)
+	}
+	e.delta += dod
+	e.prev += e.delta
+	return e.prev
+}
+
+// signExtend interprets the n low bits of v as a two's complement number
+func signExtend(v uint64, n int) int64 {
+	shift := uint(64 - n)
+	return int64(v<<shift) >> shift
+}
+
+// valueEncoder writes float64 values as the XOR with the previous one
+type valueEncoder struct {
+	prev      uint64
+	leading   int
+	trailing  int
+	hasWindow bool // a window of leading and trailing zeros was written
+	count     int
+}
+
+// encode appends a value. A value equal to the previous one takes one bit;
+// otherwise the meaningful bits of the XOR are written, reusing the previous
+// window of leading and trailing zeros when they fit in it.
+func (e *valueEncoder) encode(w *bitWriter, v float64) {
+	value := math.Float64bits(v)
+	defer func() { e.prev, e.count = value, e.count+1 }()
+	if e.count == 0 {
+		w.writeBits(value, 64)
+		return
+	}
+
+	xor := value ^ e.prev
+	if xor == 0 {
+		w.writeBit(false)
+		return
+	}
+	w.writeBit(true)
+
+	leading, trailing := bits.LeadingZeros64(xor), bits.TrailingZeros64(xor)
+	if leading > 31 {
+		leading = 31
+	}
+	if e.hasWindow && leading >= e.leading && trailing >= e.trailing {
+		w.writeBit(false)
+		w.writeBits(xor>>uint(e.trailing), 64-e.leading-e.trailing)
+		return
+	}
+
+	e.leading, e.trailing, e.hasWindow = leading, trailing, true
+	meaningful := 64 - leading - trailing
+	w.writeBit(true)
+	w.writeBits(uint64(leading), 5)
+	w.writeBits(uint64(meaningful&63), 6) // 64 is written as 0
+	w.writeBits(xor>>uint(trailing), meaningful)
+}
+
+// decode reads a value
+func (e *valueEncoder) decode(r *bitReader) float64 {
+	defer func() { e.count++ }()
+	if e.count == 0 {
+		e.prev = r.readBits(64)
+		return math.Float64frombits(e.prev)
+	}
+
+	if r.readBit() {
+		if r.readBit() {
+			e.leading = int(r.readBits(5))
+			meaningful := int(r.readBits(6))
+			if meaningful == 0 {
+				meaningful = 64
+			}
+			e.trailing = 64 - e.leading - meaningful
+			if e.trailing < 0 {
+				r.err = errGorillaData
+				return 0
+			}
+		}
+		e.prev ^= r.readBits(64-e.leading-e.trailing) << uint(e.trailing)
+	}
+	return math.Float64frombits(e.prev)
+}
+
+// GorillaEncoder compresses a series of timestamped samples. Timestamps are
+// in any fixed unit, such as seconds or milliseconds.
+type GorillaEncoder struct {
+	w      bitWriter
+	times  timestampEncoder
+	values valueEncoder
+}
+
+// NewGorillaEncoder creates an empty series
+func NewGorillaEncoder() *GorillaEncoder {
+	return &GorillaEncoder{}
+}
+
+// Append adds a sample. Timestamps should not decrease.
+func (e *GorillaEncoder) Append(t int64, v float64) {
+	e.times.encode(&e.w, t)
+	e.values.encode(&e.w, v)
+}
+
+// Count returns the number of samples appended
+func (e *GorillaEncoder) Count() int {
+	return e.times.count
+}
+
+// Bytes returns the encoded series: the sample count as a varint, followed
+// by the bits of the samples
+func (e *GorillaEncoder) Bytes() []byte {
+	var count [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(count[:], uint64(e.times.count))
+	return append(count[:n:n], e.w.buf...)
+}
+
+// DecodeGorilla decodes a series written by a GorillaEncoder
+func DecodeGorilla(data []byte) ([]int64, []float64, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || count > uint64(len(data))*8 {
+		return nil, nil, errGorillaData
+	}
+
+	r := &bitReader{buf: data[n:]}
+	var times timestampEncoder
+	var values valueEncoder
+	ts := make([]int64, 0, count)
+	vs := make([]float64, 0, count)
+	for i := uint64(0); i < count; i++ {
+		t := times.decode(r)
+		v := values.decode(r)
+		if r.err != nil {
+			return nil, nil, r.err
+		}
+		ts = append(ts, t)
+		vs = append(vs, v)
+	}
+	return ts, vs, nil
+}
@@ -0,0 +1,195 @@
+package monitor
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestGorillaRoundTrip(t *testing.T) {
+	times := []int64{
+		1700000000000, 1700000002000, 1700000004000, 1700000004001, 1700000010000,
+		1700000010000, 1700003610000, 1700003610050, 1800000000000, 1600000000000,
+	}
+	values := []float64{
+		0, 1.5, 1.5, -2.25, math.NaN(), math.Inf(1), math.Inf(-1), math.Copysign(0, -1),
+		math.MaxFloat64, math.SmallestNonzeroFloat64,
+	}
+
+	enc := NewGorillaEncoder()
+	for i := range times {
+		enc.Append(times[i], values[i])
+	}
+	if enc.Count() != len(times) {
+		t.Errorf("count = %d, want %d", enc.Count(), len(times))
+	}
+
+	gotTimes, gotValues, err := DecodeGorilla(enc.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gotTimes) != len(times) {
+		t.Fatalf("decoded %d samples, want %d", len(gotTimes), len(times))
+	}
+	for i := range times {
+		if gotTimes[i] != times[i] {
+			t.Errorf("time %d = %d, want %d", i, gotTimes[i], times[i])
+		}
+		if math.Float64bits(gotValues[i]) != math.Float64bits(values[i]) {
+			t.Errorf("value %d = %v, want %v", i, gotValues[i], values[i])
+		}
+	}
+}
+
+func TestGorillaRandomRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	enc := NewGorillaEncoder()
+	var times []int64
+	var values []float64
+	ts := int64(0)
+	for i := 0; i < 5000; i++ {
+		ts += rng.Int63n(1 << uint(rng.Intn(40)))
+		value := math.Float64frombits(rng.Uint64())
+		if rng.Intn(2) == 0 {
+			value = float64(rng.Intn(100))
+		}
+		enc.Append(ts, value)
+		times = append(times, ts)
+		values = append(values, value)
+	}
+
+	gotTimes, gotValues, err := DecodeGorilla(enc.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range times {
+		if gotTimes[i] != times[i] || math.Float64bits(gotValues[i]) != math.Float64bits(values[i]) {
+			t.Fatalf("sample %d = (%d, %v), want (%d, %v)", i, gotTimes[i], gotValues[i], times[i], values[i])
+		}
+	}
+}
+
+func TestGorillaCompressesRegularSeries(t *testing.T) {
+	enc := NewGorillaEncoder()
+	for i := 0; i < 1000; i++ {
+		enc.Append(int64(i)*2000, 42)
+	}
+	// 16 bytes for the first sample, a few for the first delta, and 2 bits
+	// for each of the others
+	if size := len(enc.Bytes()); size > 16+1000/4+8 {
+		t.Errorf("regular series of a steady value took %d bytes", size)
+	}
+}
+
+func TestDecodeGorillaRejectsTruncatedData(t *testing.T) {
+	enc := NewGorillaEncoder()
+	for i := 0; i < 10; i++ {
+		enc.Append(int64(i)*1000, float64(i)*1.1)
+	}
+	data := enc.Bytes()
+	for _, raw := range [][]byte{nil, {0x0a}, data[:len(data)/2], {0xff, 0xff, 0x01}} {
+		if _, _, err := DecodeGorilla(raw); err == nil {
+			t.Errorf("expected an error decoding %x", raw)
+		}
+	}
+}
+
+// benchmarkSeries returns a day of 2 second samples shaped like CPU usage:
+// a slowly wandering value with two decimals and some jitter in the timing
+func benchmarkSeries() ([]int64, []float64) {
+	rng := rand.New(rand.NewSource(1))
+	const n = 43200
+	times := make([]int64, n)
+	values := make([]float64, n)
+	ts := time.Unix(1700000000, 0).UnixNano() / int64(time.Millisecond)
+	value := 20.0
+	for i := range times {
+		ts += 2000 + rng.Int63n(3) - 1
+		value = math.Max(0, math.Min(100, value+rng.NormFloat64()))
+		times[i] = ts
+		values[i] = math.Round(value*100) / 100
+	}
+	return times, values
+}
+
+func BenchmarkGorillaEncode(b *testing.B) {
+	times, values := benchmarkSeries()
+	b.ResetTimer()
+
+	var size int
+	for i := 0; i < b.N; i++ {
+		enc := NewGorillaEncoder()
+		for j := range times {
+			enc.Append(times[j], values[j])
+		}
+		size = len(enc.Bytes())
+	}
+	b.ReportMetric(float64(size)/float64(len(times)), "bytes/sample")
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(times)), "ns/sample")
+}
+
+func BenchmarkGorillaDecode(b *testing.B) {
+	times, values := benchmarkSeries()
+	enc := NewGorillaEncoder()
+	for j := range times {
+		enc.Append(times[j], values[j])
+	}
+	data := enc.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, _, err := DecodeGorilla(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(times)), "ns/sample")
+}
+
+// BenchmarkJSONEncode is the baseline: the same samples as JSON points
+func BenchmarkJSONEncode(b *testing.B) {
+	times, values := benchmarkSeries()
+	samples := make([]HistorySample, len(times))
+	for j := range times {
+		samples[j] = HistorySample{Time: time.Unix(0, times[j]*int64(time.Millisecond)), Value: values[j]}
+	}
+	b.ResetTimer()
+
+	var size int
+	for i := 0; i < b.N; i++ {
+		data, err := json.Marshal(samples)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(data)
+	}
+	b.ReportMetric(float64(size)/float64(len(times)), "bytes/sample")
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(times)), "ns/sample")
+}
+
+func BenchmarkHistoryBlockEncode(b *testing.B) {
+	times, values := benchmarkSeries()
+	slots := make([]historySlot, len(times)/30)
+	for i := range slots {
+		v := values[i*30 : i*30+30]
+		slot := historySlot{bucket: uint32(times[i*30] / 60000), count: 30, min: v[0], max: v[0], last: v[29]}
+		for _, x := range v {
+			slot.min, slot.max, slot.avg = math.Min(slot.min, x), math.Max(slot.max, x), slot.avg+x/30
+		}
+		slots[i] = slot
+	}
+	tiers := []historyTierSlots{{resolution: time.Minute, series: map[string][]historySlot{"cpu.usage_percent": slots}}}
+	b.ResetTimer()
+
+	var size int
+	for i := 0; i < b.N; i++ {
+		payload, err := encodeHistoryBlock(tiers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(payload)
+	}
+	b.ReportMetric(float64(size)/float64(len(slots)), "bytes/bucket")
+}
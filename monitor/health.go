@@ -0,0 +1,147 @@
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HealthLevel is the overall health state of the device
+type HealthLevel string
+
+const (
+	HealthOK       HealthLevel = "ok"
+	HealthWarning  HealthLevel = "warning"
+	HealthCritical HealthLevel = "critical"
+	HealthFailure  HealthLevel = "failure" // one or more collectors failed
+)
+
+// HealthLevels lists the health levels from best to worst
+var HealthLevels = []HealthLevel{HealthOK, HealthWarning, HealthCritical, HealthFailure}
+
+// severity orders health levels so the worst one wins
+func (l HealthLevel) severity() int {
+	for i, level := range HealthLevels {
+		if level == l {
+			return i
+		}
+	}
+	return 0
+}
+
+// Worse reports whether l is a worse state than other
+func (l HealthLevel) Worse(other HealthLevel) bool {
+	return l.severity() > other.severity()
+}
+
+// HealthRule raises the health level when a metric crosses a threshold.
+// A "*" in the metric name matches any characters, so one rule can cover
+// every device or pin, e.g. "writes.*.budget_percent".
+type HealthRule struct {
+	Metric   string   `mapstructure:"metric" json:"metric"`
+	Warning  *float64 `mapstructure:"warning" json:"warning,omitempty"`
+	Critical *float64 `mapstructure:"critical" json:"critical,omitempty"`
+	Below    bool     `mapstructure:"below" json:"below,omitempty"` // trigger when the value drops below the threshold
+}
+
+// HealthStatus represents the overall health and the reasons behind it
+type HealthStatus struct {
+	Level   HealthLevel `json:"level"`
+	Reasons []string    `json:"reasons,omitempty"`
+}
+
+// evaluateHealth applies the rules to the stats and returns the worst result
+func evaluateHealth(stats *SystemStats, rules []HealthRule) HealthStatus {
+	health := HealthStatus{Level: HealthOK}
+	raise := func(level HealthLevel, reason string) {
+		if level.Worse(health.Level) {
+			health.Level = level
+		}
+		health.Reasons = append(health.Reasons, reason)
+	}
+
+	if len(stats.Errors) > 0 {
+		collectors := make([]string, 0, len(stats.Errors))
+		for collector := range stats.Errors {
+			collectors = append(collectors, collector)
+		}
+		sort.Strings(collectors)
+		for _, collector := range collectors {
+			raise(HealthFailure, fmt.Sprintf("%s collector failed: %s", collector, stats.Errors[collector]))
+		}
+	}
+
+	// A rule on a metric that failed to measure fails rather than judging a 0
+	unavailable := stats.unavailableMetrics()
+	failed := make(map[string]float64, len(unavailable))
+	for name := range unavailable {
+		failed[name] = 0
+	}
+
+	metrics := stats.Metrics()
+	for _, rule := range rules {
+		for _, name := range rule.matches(failed) {
+			raise(HealthFailure, fmt.Sprintf("%s is unavailable: %s", name, unavailable[name]))
+		}
+		for _, name := range rule.matches(metrics) {
+			value := metrics[name]
+			switch {
+			case rule.Critical != nil && rule.crossed(value, *rule.Critical):
+				raise(HealthCritical, fmt.Sprintf("%s is %.2f (critical %.2f)", name, value, *rule.Critical))
+			case rule.Warning != nil && rule.crossed(value, *rule.Warning):
+				raise(HealthWarning, fmt.Sprintf("%s is %.2f (warning %.2f)", name, value, *rule.Warning))
+			}
+		}
+	}
+
+	return health
+}
+
+// matches returns the names of the metrics the rule applies to, in order
+func (r HealthRule) matches(metrics map[string]float64) []string {
+	if !strings.Contains(r.Metric, "*") {
+		if _, ok := metrics[r.Metric]; ok {
+			return []string{r.Metric}
+		}
+		return nil
+	}
+
+	var names []string
+	for name := range metrics {
+		if matchMetric(r.Metric, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matchMetric reports whether name matches a pattern where "*" stands for
+// any run of characters
+func matchMetric(pattern, name string) bool {
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 {
+			return strings.HasSuffix(name, part)
+		}
+		idx := strings.Index(name, part)
+		if idx < 0 {
+			return false
+		}
+		name = name[idx+len(part):]
+	}
+	return name == ""
+}
+
+// crossed reports whether value is past the threshold in the rule's direction
+func (r HealthRule) crossed(value, threshold float64) bool {
+	if r.Below {
+		return value < threshold
+	}
+	return value >= threshold
+}
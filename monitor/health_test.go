@@ -0,0 +1,85 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMetricsFlatten(t *testing.T) {
+	stats := &SystemStats{
+		CPU:    CPUStats{UsagePercent: 42, LoadAverage: []float64{1, 2, 3}},
+		Memory: MemStats{Total: 1024},
+		GPIO: GPIOStats{Pins: map[string]GPIOState{
+			"gpio17": {Pin: "gpio17", Value: 1, Mode: "out"},
+		}},
+	}
+
+	metrics := stats.Metrics()
+	expected := map[string]float64{
+		"cpu.usage_percent":      42,
+		"cpu.load_average.2":     3,
+		"memory.total":           1024,
+		"gpio.pins.gpio17.value": 1,
+	}
+	for name, want := range expected {
+		if got, ok := metrics[name]; !ok || got != want {
+			t.Errorf("metric %s = %v (present %v), want %v", name, got, ok, want)
+		}
+	}
+	if _, ok := metrics["gpio.pins.gpio17.mode"]; ok {
+		t.Error("string fields should not become metrics")
+	}
+}
+
+func TestEvaluateHealth(t *testing.T) {
+	rules := DefaultHealthRules()
+
+	stats := &SystemStats{CPU: CPUStats{UsagePercent: 10}}
+	if health := evaluateHealth(stats, rules); health.Level != HealthOK {
+		t.Errorf("expected ok, got %s (%v)", health.Level, health.Reasons)
+	}
+
+	stats.CPU.UsagePercent = 90
+	if health := evaluateHealth(stats, rules); health.Level != HealthWarning {
+		t.Errorf("expected warning, got %s", health.Level)
+	}
+
+	stats.Temperature.CPU = 90
+	if health := evaluateHealth(stats, rules); health.Level != HealthCritical {
+		t.Errorf("expected critical, got %s", health.Level)
+	}
+
+	stats.recordError("disk", errors.New("statfs failed"))
+	if health := evaluateHealth(stats, rules); health.Level != HealthFailure {
+		t.Errorf("expected failure, got %s", health.Level)
+	}
+}
+
+func TestEvaluateHealthBelow(t *testing.T) {
+	min := 10.0
+	rules := []HealthRule{{Metric: "disk.free", Critical: &min, Below: true}}
+
+	stats := &SystemStats{Disk: DiskStats{Free: 5}}
+	if health := evaluateHealth(stats, rules); health.Level != HealthCritical {
+		t.Errorf("expected critical, got %s", health.Level)
+	}
+}
+
+func TestMatchMetric(t *testing.T) {
+	cases := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"writes.*.budget_percent", "writes.devices.mmcblk0.budget_percent", true},
+		{"writes.*.budget_percent", "writes.mounts./data.budget_percent", true},
+		{"writes.*.budget_percent", "writes.devices.mmcblk0.today", false},
+		{"gpio.pins.*.value", "gpio.pins.gpio17.value", true},
+		{"*.usage_percent", "cpu.usage_percent", true},
+		{"cpu.*", "memory.total", false},
+	}
+	for _, c := range cases {
+		if got := matchMetric(c.pattern, c.name); got != c.want {
+			t.Errorf("matchMetric(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNoHistory is returned for a metric the history does not keep
+var ErrNoHistory = errors.New("no history")
+
+// HistoryPoint is the rollup of the samples of one metric in one time bucket
+type HistoryPoint struct {
+	Time  time.Time `json:"time"` // start of the bucket
+	Min   float64   `json:"min"`
+	Max   float64   `json:"max"`
+	Avg   float64   `json:"avg"`
+	Last  float64   `json:"last"`
+	Count int       `json:"count"` // samples in the bucket
+}
+
+// HistoryStats describes how much of the memory budget the history uses
+type HistoryStats struct {
+	Series      int    `json:"series"`  // metrics with a history
+	Dropped     int    `json:"dropped"` // matching metrics left out to stay within the budget
+	MemoryBytes uint64 `json:"memory_bytes"`
+	MaxMemory   uint64 `json:"max_memory"`
+}
+
+// historySlot holds the rollup of one bucket. Buckets are numbered from the
+// Unix epoch in units of the tier resolution, which is at least a second.
+type historySlot struct {
+	bucket uint32
+	count  uint32
+	min    float64
+	max    float64
+	avg    float64
+	last   float64
+}
+
+// historySlotSize is the memory one slot takes
+const historySlotSize = uint64(unsafe.Sizeof(historySlot{}))
+
+// historySeriesOverhead approximates the memory a series takes besides its slots
+const historySeriesOverhead = 128
+
+// historyRing holds the buckets of one tier of one metric
+type historyRing []historySlot
+
+// add folds a sample into its bucket, overwriting the bucket it replaces
+func (r historyRing) add(bucket uint32, value float64) {
+	slot := &r[int(bucket%uint32(len(r)))]
+	if slot.bucket != bucket || slot.count == 0 {
+		*slot = historySlot{bucket: bucket, count: 1, min: value, max: value, avg: value, last: value}
+		return
+	}
+	slot.count++
+	slot.last = value
+	if value < slot.min {
+		slot.min = value
+	}
+	if value > slot.max {
+		slot.max = value
+	}
+	slot.avg += (value - slot.avg) / float64(slot.count)
+}
+
+// History keeps a fixed-memory ring of rollups per metric and tier, e.g. 2s
+// buckets for 10 minutes, 1m buckets for a day and 15m buckets for a week
+type History struct {
+	log       *logrus.Logger
+	tiers     []HistoryTier
+	patterns  []string
+	maxMemory uint64
+
+	mu      sync.RWMutex
+	series  map[string][]historyRing // metric -> ring per tier
+	skipped map[string]bool          // metrics not kept, so they are only matched once
+	dropped int
+	used    uint64
+	last    time.Time
+}
+
+// newHistory creates the history, skipping invalid tiers
+func newHistory(cfg HistoryConfig, sm *SystemMonitor) *History {
+	h := &History{
+		log:       sm.log,
+		patterns:  cfg.Metrics,
+		maxMemory: cfg.MaxMemory,
+		series:    make(map[string][]historyRing),
+		skipped:   make(map[string]bool),
+	}
+	if h.patterns == nil {
+		h.patterns = DefaultHistoryMetrics()
+	}
+
+	tiers := cfg.Tiers
+	if tiers == nil {
+		tiers = DefaultHistoryTiers()
+	}
+	for _, tier := range tiers {
+		if tier.Resolution < time.Second || tier.Resolution%time.Second != 0 || tier.Retention < tier.Resolution {
+			sm.log.Errorf("Invalid history tier %s/%s: the resolution must be whole seconds and within the retention",
+				tier.Resolution, tier.Retention)
+			continue
+		}
+		h.tiers = append(h.tiers, tier)
+	}
+	sort.Slice(h.tiers, func(i, j int) bool { return h.tiers[i].Resolution < h.tiers[j].Resolution })
+
+	return h
+}
+
+// seriesSize returns the memory one metric's history takes
+func (h *History) seriesSize() uint64 {
+	size := uint64(historySeriesOverhead)
+	for _, tier := range h.tiers {
+		size += uint64(tier.slots()) * historySlotSize
+	}
+	return size
+}
+
+// slots returns the number of buckets the tier keeps
+func (t HistoryTier) slots() int {
+	return int(t.Retention / t.Resolution)
+}
+
+// bucket returns the number of the bucket t falls in
+func (t HistoryTier) bucket(ts time.Time) uint32 {
+	return uint32(ts.Unix() / int64(t.Resolution/time.Second))
+}
+
+// Record adds a sample of every metric. Metrics are given a history in the
+// order of the configured patterns until the memory budget is used up.
+func (h *History) Record(ts time.Time, metrics map[string]float64) {
+	if len(h.tiers) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var unknown []string
+	for name := range metrics {
+		if _, ok := h.series[name]; !ok && !h.skipped[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		h.addSeries(unknown)
+	}
+
+	for name, value := range metrics {
+		rings, ok := h.series[name]
+		if !ok {
+			continue
+		}
+		for i, tier := range h.tiers {
+			rings[i].add(tier.bucket(ts), value)
+		}
+	}
+	if ts.After(h.last) {
+		h.last = ts
+	}
+}
+
+// addSeries creates the rings of the new metrics that match a pattern, most
+// important pattern first, and remembers the rest as skipped
+func (h *History) addSeries(names []string) {
+	sort.Strings(names)
+	size := h.seriesSize()
+
+	for _, pattern := range h.patterns {
+		for _, name := range names {
+			if _, ok := h.series[name]; ok || h.skipped[name] || !matchMetric(pattern, name) {
+				continue
+			}
+			if h.maxMemory > 0 && h.used+size > h.maxMemory {
+				if h.dropped == 0 {
+					h.log.Warnf("History memory budget of %d bytes reached, %s and later metrics have no history",
+						h.maxMemory, name)
+				}
+				h.skipped[name] = true
+				h.dropped++
+				continue
+			}
+			rings := make([]historyRing, len(h.tiers))
+			for i, tier := range h.tiers {
+				rings[i] = make(historyRing, tier.slots())
+			}
+			h.series[name] = rings
+			h.used += size
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := h.series[name]; !ok {
+			h.skipped[name] = true
+		}
+	}
+}
+
+// Query returns the rollups of a metric between from and to, oldest first.
+// With a resolution of 0 the finest tier that still covers from is used,
+// otherwise the finest tier at least as coarse as the resolution.
+func (h *History) Query(metric string, from, to time.Time, resolution time.Duration) ([]HistoryPoint, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	rings, ok := h.series[metric]
+	if !ok {
+		return nil, fmt.Errorf("%w for %s", ErrNoHistory, metric)
+	}
+	index := h.tierFor(from, resolution)
+	if index < 0 {
+		return nil, fmt.Errorf("no history tiers configured")
+	}
+
+	if to.After(h.last) {
+		to = h.last
+	}
+	tier, ring := h.tiers[index], rings[index]
+	first, last := tier.bucket(from), tier.bucket(to)
+	if first > last {
+		return nil, nil
+	}
+	if n := uint32(len(ring)); last-first >= n {
+		first = last - n + 1
+	}
+
+	var points []HistoryPoint
+	for bucket := first; bucket <= last && bucket >= first; bucket++ {
+		slot := ring[int(bucket%uint32(len(ring)))]
+		if slot.count == 0 || slot.bucket != bucket {
+			continue
+		}
+		points = append(points, HistoryPoint{
+			Time:  time.Unix(int64(bucket)*int64(tier.Resolution/time.Second), 0),
+			Min:   slot.min,
+			Max:   slot.max,
+			Avg:   slot.avg,
+			Last:  slot.last,
+			Count: int(slot.count),
+		})
+	}
+	return points, nil
+}
+
+// HistoryAggregations lists the ways the points in a step can be combined
+var HistoryAggregations = []string{"avg", "min", "max", "last", "p95"}
+
+// HistorySample is one aggregated value of a metric
+type HistorySample struct {
+	Time  time.Time `json:"time"` // start of the step
+	Value float64   `json:"value"`
+}
+
+// Range aggregates the history of a metric into steps between from and to,
+// and returns the step used. It reads the finest tier that covers from; a
+// step finer than that tier, or 0, becomes its resolution. p95 is taken over
+// the bucket averages, so it is exact only for buckets of single samples.
+func (h *History) Range(metric string, from, to time.Time, step time.Duration,
+	aggregation string) ([]HistorySample, time.Duration, error) {
+	if !validAggregation(aggregation) {
+		return nil, 0, fmt.Errorf("unknown aggregation %q, use one of %s", aggregation,
+			strings.Join(HistoryAggregations, ", "))
+	}
+
+	resolution, err := h.rangeResolution(from)
+	if err != nil {
+		return nil, 0, err
+	}
+	if step < resolution {
+		step = resolution
+	}
+
+	points, err := h.Query(metric, from, to, resolution)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var samples []HistorySample
+	for len(points) > 0 {
+		start := points[0].Time.Truncate(step)
+		n := 1
+		for n < len(points) && points[n].Time.Truncate(step).Equal(start) {
+			n++
+		}
+		samples = append(samples, HistorySample{Time: start, Value: aggregatePoints(points[:n], aggregation)})
+		points = points[n:]
+	}
+	return samples, step, nil
+}
+
+// Step returns the step Range uses for a range starting at from, so the size
+// of a result can be checked before it is computed
+func (h *History) Step(from time.Time, step time.Duration) (time.Duration, error) {
+	resolution, err := h.rangeResolution(from)
+	if err != nil {
+		return 0, err
+	}
+	if step < resolution {
+		step = resolution
+	}
+	return step, nil
+}
+
+// rangeResolution returns the resolution of the finest tier that covers from
+func (h *History) rangeResolution(from time.Time) (time.Duration, error) {
+	h.mu.RLock()
+	index := h.tierFor(from, 0)
+	h.mu.RUnlock()
+	if index < 0 {
+		return 0, fmt.Errorf("no history tiers configured")
+	}
+	return h.tiers[index].Resolution, nil
+}
+
+// validAggregation reports whether aggregation is one of HistoryAggregations
+func validAggregation(aggregation string) bool {
+	for _, a := range HistoryAggregations {
+		if a == aggregation {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregatePoints combines the points of one step, oldest first
+func aggregatePoints(points []HistoryPoint, aggregation string) float64 {
+	switch aggregation {
+	case "min":
+		value := points[0].Min
+		for _, p := range points[1:] {
+			value = math.Min(value, p.Min)
+		}
+		return value
+	case "max":
+		value := points[0].Max
+		for _, p := range points[1:] {
+			value = math.Max(value, p.Max)
+		}
+		return value
+	case "last":
+		return points[len(points)-1].Last
+	case "p95":
+		values := make([]float64, len(points))
+		for i, p := range points {
+			values[i] = p.Avg
+		}
+		sort.Float64s(values)
+		return values[int(math.Ceil(0.95*float64(len(values))))-1]
+	}
+
+	// The average of the buckets weighted by their sample counts
+	sum, count := 0.0, 0
+	for _, p := range points {
+		sum += p.Avg * float64(p.Count)
+		count += p.Count
+	}
+	return sum / float64(count)
+}
+
+// tierFor picks the tier for a query, or -1 without tiers
+func (h *History) tierFor(from time.Time, resolution time.Duration) int {
+	for i, tier := range h.tiers {
+		if resolution > 0 {
+			if tier.Resolution >= resolution {
+				return i
+			}
+			continue
+		}
+		if !h.last.Add(-tier.Retention).After(from) {
+			return i
+		}
+	}
+	return len(h.tiers) - 1
+}
+
+// historyTierSlots holds buckets of one tier, keyed by metric
+type historyTierSlots struct {
+	resolution time.Duration
+	series     map[string][]historySlot
+}
+
+// export returns the buckets after the marks, by tier resolution, of the
+// tiers at least as coarse as minResolution. The bucket still being filled
+// is included only when partial is set. It returns the new marks too, which
+// leave the current bucket to be written again once it is complete.
+func (h *History) export(marks map[time.Duration]uint32, minResolution time.Duration,
+	partial bool) ([]historyTierSlots, map[time.Duration]uint32) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	newMarks := make(map[time.Duration]uint32, len(marks))
+	for resolution, mark := range marks {
+		newMarks[resolution] = mark
+	}
+	if h.last.IsZero() {
+		return nil, newMarks
+	}
+
+	var tiers []historyTierSlots
+	for i, tier := range h.tiers {
+		if tier.Resolution < minResolution {
+			continue
+		}
+		current := tier.bucket(h.last)
+		mark, marked := marks[tier.Resolution]
+
+		slots := historyTierSlots{resolution: tier.Resolution, series: make(map[string][]historySlot)}
+		for name, rings := range h.series {
+			var kept []historySlot
+			for _, slot := range rings[i] {
+				if slot.count == 0 || (marked && slot.bucket <= mark) || (slot.bucket >= current && !partial) {
+					continue
+				}
+				kept = append(kept, slot)
+			}
+			if len(kept) > 0 {
+				sort.Slice(kept, func(a, b int) bool { return kept[a].bucket < kept[b].bucket })
+				slots.series[name] = kept
+			}
+		}
+		if len(slots.series) > 0 {
+			tiers = append(tiers, slots)
+		}
+		newMarks[tier.Resolution] = current - 1
+	}
+	return tiers, newMarks
+}
+
+// restore puts stored buckets back into the tier with the same resolution.
+// A bucket replaces an older or equal one in its slot, so the last write of
+// a bucket wins.
+func (h *History) restore(resolution time.Duration, name string, slots []historySlot) {
+	index := -1
+	for i, tier := range h.tiers {
+		if tier.Resolution == resolution {
+			index = i
+		}
+	}
+	if index < 0 || len(slots) == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.series[name]; !ok && !h.skipped[name] {
+		h.addSeries([]string{name})
+	}
+	rings, ok := h.series[name]
+	if !ok {
+		return
+	}
+
+	ring := rings[index]
+	for _, slot := range slots {
+		existing := &ring[int(slot.bucket%uint32(len(ring)))]
+		if existing.count == 0 || slot.bucket >= existing.bucket {
+			*existing = slot
+		}
+		end := time.Unix(int64(slot.bucket+1)*int64(resolution/time.Second), 0).Add(-time.Second)
+		if end.After(h.last) {
+			h.last = end
+		}
+	}
+}
+
+// Tiers returns the resolutions and retentions kept
+func (h *History) Tiers() []HistoryTier {
+	return append([]HistoryTier(nil), h.tiers...)
+}
+
+// Metrics returns the names of the metrics with a history, sorted
+func (h *History) Metrics() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	names := make([]string, 0, len(h.series))
+	for name := range h.series {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stats reports the memory the history uses
+func (h *History) Stats() HistoryStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return HistoryStats{
+		Series:      len(h.series),
+		Dropped:     h.dropped,
+		MemoryBytes: h.used,
+		MaxMemory:   h.maxMemory,
+	}
+}
+
+// History returns the metrics history
+func (sm *SystemMonitor) History() *History {
+	return sm.history
+}
@@ -0,0 +1,170 @@
+package monitor
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestHistory(cfg HistoryConfig) *History {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return newHistory(cfg, &SystemMonitor{log: log})
+}
+
+func TestHistoryRollups(t *testing.T) {
+	h := newTestHistory(HistoryConfig{
+		Tiers: []HistoryTier{
+			{Resolution: time.Minute, Retention: time.Hour},
+			{Resolution: 2 * time.Second, Retention: 10 * time.Second},
+			{Resolution: time.Millisecond, Retention: time.Second},
+		},
+		Metrics: []string{"cpu.*"},
+	})
+	if got := len(h.Tiers()); got != 2 {
+		t.Fatalf("expected the sub-second tier to be skipped, got %d tiers", got)
+	}
+
+	start := time.Unix(1700000040, 0) // on a minute boundary
+	for i := 0; i < 30; i++ {
+		h.Record(start.Add(time.Duration(i)*time.Second), map[string]float64{
+			"cpu.usage_percent": float64(i),
+			"memory.used":       1,
+		})
+	}
+
+	if got := h.Metrics(); len(got) != 1 || got[0] != "cpu.usage_percent" {
+		t.Errorf("metrics = %v, want only the ones matching the patterns", got)
+	}
+
+	// The 2s tier keeps the last 5 buckets: 20-21, ..., 28-29
+	points, err := h.Query("cpu.usage_percent", start.Add(20*time.Second), start.Add(time.Minute), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 5 {
+		t.Fatalf("got %d raw points, want 5: %+v", len(points), points)
+	}
+	first := points[0]
+	if !first.Time.Equal(start.Add(20*time.Second)) || first.Min != 20 || first.Max != 21 || first.Avg != 20.5 ||
+		first.Count != 2 {
+		t.Errorf("first raw point = %+v", first)
+	}
+
+	// Asking for older data, or a coarser resolution, uses the minute tier
+	for _, points := range [][]HistoryPoint{
+		mustQuery(t, h, start.Add(-time.Hour/2), start.Add(time.Minute), 0),
+		mustQuery(t, h, start, start.Add(time.Minute), time.Minute),
+	} {
+		if len(points) != 1 || points[0].Min != 0 || points[0].Max != 29 || points[0].Avg != 14.5 ||
+			points[0].Count != 30 {
+			t.Errorf("minute points = %+v", points)
+		}
+	}
+
+	if _, err := h.Query("memory.used", start, start.Add(time.Minute), 0); err == nil {
+		t.Error("expected an error for a metric without history")
+	}
+}
+
+func mustQuery(t *testing.T, h *History, from, to time.Time, resolution time.Duration) []HistoryPoint {
+	t.Helper()
+	points, err := h.Query("cpu.usage_percent", from, to, resolution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return points
+}
+
+func TestHistoryMemoryBudget(t *testing.T) {
+	tiers := []HistoryTier{{Resolution: time.Second, Retention: 100 * time.Second}}
+	h := newTestHistory(HistoryConfig{Tiers: tiers, Metrics: []string{"temperature.*", "cpu.*"}})
+	size := h.seriesSize()
+
+	h = newTestHistory(HistoryConfig{Tiers: tiers, Metrics: []string{"temperature.*", "cpu.*"}, MaxMemory: 3 * size})
+	h.Record(time.Now(), map[string]float64{
+		"cpu.frequency":       1,
+		"cpu.usage_percent":   2,
+		"temperature.cpu":     3,
+		"temperature.ambient": 4,
+	})
+
+	got := h.Metrics()
+	want := []string{"cpu.frequency", "temperature.ambient", "temperature.cpu"}
+	if len(got) != len(want) {
+		t.Fatalf("metrics = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("metrics = %v, want %v", got, want)
+		}
+	}
+
+	stats := h.Stats()
+	if stats.Series != 3 || stats.Dropped != 1 || stats.MemoryBytes > stats.MaxMemory {
+		t.Errorf("history stats = %+v", stats)
+	}
+}
+
+func TestDefaultHistoryFitsBudget(t *testing.T) {
+	h := newTestHistory(DefaultConfig().History)
+	if series := DefaultConfig().History.MaxMemory / h.seriesSize(); series < 40 {
+		t.Errorf("the default budget only holds %d series", series)
+	}
+}
+
+func TestHistoryRange(t *testing.T) {
+	h := newTestHistory(HistoryConfig{
+		Tiers:   []HistoryTier{{Resolution: 10 * time.Second, Retention: time.Hour}},
+		Metrics: []string{"*"},
+	})
+	start := time.Unix(1700000040, 0)
+	for i := 0; i < 120; i++ {
+		// One sample per 5 seconds at value i
+		h.Record(start.Add(time.Duration(i)*5*time.Second), map[string]float64{"temperature.cpu": float64(i)})
+	}
+	end := start.Add(10 * time.Minute)
+
+	cases := []struct {
+		aggregation string
+		want        []float64
+	}{
+		{"avg", []float64{5.5, 17.5, 29.5, 41.5, 53.5, 65.5, 77.5, 89.5, 101.5, 113.5}},
+		{"min", []float64{0, 12, 24, 36, 48, 60, 72, 84, 96, 108}},
+		{"max", []float64{11, 23, 35, 47, 59, 71, 83, 95, 107, 119}},
+		{"last", []float64{11, 23, 35, 47, 59, 71, 83, 95, 107, 119}},
+		{"p95", []float64{10.5, 22.5, 34.5, 46.5, 58.5, 70.5, 82.5, 94.5, 106.5, 118.5}},
+	}
+	for _, c := range cases {
+		samples, step, err := h.Range("temperature.cpu", start, end, time.Minute, c.aggregation)
+		if err != nil {
+			t.Fatalf("%s: %v", c.aggregation, err)
+		}
+		if step != time.Minute || len(samples) != len(c.want) {
+			t.Fatalf("%s: got %d samples with step %s: %+v", c.aggregation, len(samples), step, samples)
+		}
+		for i, want := range c.want {
+			if samples[i].Value != want || !samples[i].Time.Equal(start.Add(time.Duration(i)*time.Minute)) {
+				t.Errorf("%s sample %d = %+v, want %v", c.aggregation, i, samples[i], want)
+			}
+		}
+	}
+
+	// A step finer than the tier becomes its resolution
+	if samples, step, err := h.Range("temperature.cpu", start, end, time.Second, "avg"); err != nil ||
+		step != 10*time.Second || len(samples) != 60 {
+		t.Errorf("fine step: %d samples, step %s, %v", len(samples), step, err)
+	}
+	if step, err := h.Step(start, time.Second); err != nil || step != 10*time.Second {
+		t.Errorf("Step = %s, %v; want the tier resolution", step, err)
+	}
+	if _, _, err := h.Range("temperature.cpu", start, end, 0, "median"); err == nil {
+		t.Error("expected an error for an unknown aggregation")
+	}
+	if _, _, err := h.Range("temperature.gpu", start, end, 0, "avg"); !errors.Is(err, ErrNoHistory) {
+		t.Errorf("expected ErrNoHistory, got %v", err)
+	}
+}
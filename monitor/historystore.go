@@ -0,0 +1,474 @@
+package monitor
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The history is stored as segment files of appended blocks. Each block is
+// a header of magic, payload length and CRC-32 of the payload, followed by
+// the payload compressed with flate. The buckets in the payload use the
+// Gorilla encoding of gorilla.go. A power cut can only leave a partial
+// block at the end of the newest segment, which is cut off when loading.
+const (
+	historyBlockMagic = "EMH2"
+	historyHeaderSize = 12
+	historySegmentExt = ".seg"
+	maxHistoryBlock   = 16 << 20
+)
+
+// errBadHistoryBlock reports a block that is truncated or fails its checksum
+var errBadHistoryBlock = errors.New("bad history block")
+
+// historyStore appends the history rollups to segment files
+type historyStore struct {
+	cfg      HistoryPersistConfig
+	dir      string
+	readOnly bool // leave damaged segments alone, another process may be writing
+
+	mu    sync.Mutex
+	file  *os.File // segment being appended to
+	size  int64
+	marks map[time.Duration]uint32 // tier resolution -> last bucket written
+}
+
+// newHistoryStore creates a store in dir
+func newHistoryStore(cfg HistoryPersistConfig, dir string) *historyStore {
+	return &historyStore{
+		cfg:   cfg,
+		dir:   dir,
+		marks: make(map[time.Duration]uint32),
+	}
+}
+
+// segments returns the segment files oldest first. Their names are the
+// creation time in hex, so they sort by age.
+func (s *historyStore) segments() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), historySegmentExt) {
+			paths = append(paths, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// load reads every stored block back into the history. A bad block ends its
+// segment; in the newest segment the file is truncated there so appends
+// continue after the last valid block. It returns the number of blocks read.
+func (s *historyStore) load(h *History, sm *SystemMonitor) (int, error) {
+	paths, err := s.segments()
+	if err != nil {
+		return 0, err
+	}
+
+	blocks := 0
+	for i, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			sm.log.Warnf("Failed to read history segment %s: %v", path, err)
+			continue
+		}
+
+		offset := 0
+		for offset < len(data) {
+			payload, n, err := readHistoryBlock(data[offset:])
+			if err == nil {
+				err = s.restoreBlock(h, payload)
+			}
+			if err != nil {
+				sm.log.Warnf("History segment %s is damaged at offset %d: %v", path, offset, err)
+				break
+			}
+			offset += n
+			blocks++
+		}
+
+		if offset < len(data) && i == len(paths)-1 && !s.readOnly {
+			if err := os.Truncate(path, int64(offset)); err != nil {
+				return blocks, err
+			}
+		}
+	}
+	return blocks, nil
+}
+
+// restoreBlock decodes one block into the history and moves the marks past
+// the buckets it holds, except the newest one that may have been partial
+func (s *historyStore) restoreBlock(h *History, payload []byte) error {
+	tiers, err := decodeHistoryBlock(payload)
+	if err != nil {
+		return err
+	}
+
+	for _, tier := range tiers {
+		for name, slots := range tier.series {
+			h.restore(tier.resolution, name, slots)
+			if last := slots[len(slots)-1].bucket; last > 0 && last-1 > s.marks[tier.resolution] {
+				s.marks[tier.resolution] = last - 1
+			}
+		}
+	}
+	return nil
+}
+
+// flush appends the buckets completed since the last flush as one block,
+// then applies the retention. With partial set the buckets still being
+// filled are written as well, for a clean shutdown.
+func (s *historyStore) flush(h *History, partial bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tiers, marks := h.export(s.marks, s.cfg.MinResolution, partial)
+	if len(tiers) > 0 {
+		payload, err := encodeHistoryBlock(tiers)
+		if err != nil {
+			return err
+		}
+		if err := s.append(payload); err != nil {
+			return err
+		}
+	}
+	s.marks = marks
+
+	return s.applyRetention()
+}
+
+// append writes a block to the current segment, starting a new one when it
+// is full, and syncs it to storage
+func (s *historyStore) append(payload []byte) error {
+	if s.file != nil && s.size >= s.cfg.SegmentSize {
+		s.file.Close()
+		s.file = nil
+	}
+	if s.file == nil {
+		if err := os.MkdirAll(s.dir, 0755); err != nil {
+			return err
+		}
+		path := filepath.Join(s.dir, fmt.Sprintf("%016x%s", time.Now().UnixNano(), historySegmentExt))
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		s.file, s.size = file, 0
+	}
+
+	header := make([]byte, historyHeaderSize)
+	copy(header, historyBlockMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[8:], crc32.ChecksumIEEE(payload))
+
+	n, err := s.file.Write(append(header, payload...))
+	s.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// applyRetention deletes the segments last written before the maximum age,
+// then the oldest ones until the store fits its maximum size. The segment
+// being appended to is kept.
+func (s *historyStore) applyRetention() error {
+	paths, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	current := ""
+	if s.file != nil {
+		current = s.file.Name()
+	}
+
+	type segment struct {
+		path     string
+		size     int64
+		modified time.Time
+	}
+	var segments []segment
+	var total int64
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, segment{path, info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	cutoff := time.Now().Add(-s.cfg.MaxAge)
+	for _, seg := range segments {
+		if seg.path == current {
+			continue
+		}
+		expired := s.cfg.MaxAge > 0 && seg.modified.Before(cutoff)
+		oversize := s.cfg.MaxSize > 0 && total > s.cfg.MaxSize
+		if !expired && !oversize {
+			continue
+		}
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+		total -= seg.size
+	}
+	return nil
+}
+
+// close closes the current segment
+func (s *historyStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// readHistoryBlock checks the block at the start of data and returns its
+// decompressed payload and its size on disk
+func readHistoryBlock(data []byte) ([]byte, int, error) {
+	if len(data) < historyHeaderSize || string(data[:4]) != historyBlockMagic {
+		return nil, 0, errBadHistoryBlock
+	}
+	length := int(binary.LittleEndian.Uint32(data[4:]))
+	if length > maxHistoryBlock || len(data) < historyHeaderSize+length {
+		return nil, 0, errBadHistoryBlock
+	}
+	compressed := data[historyHeaderSize : historyHeaderSize+length]
+	if crc32.ChecksumIEEE(compressed) != binary.LittleEndian.Uint32(data[8:]) {
+		return nil, 0, errBadHistoryBlock
+	}
+
+	payload, err := ioutil.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), maxHistoryBlock))
+	if err != nil {
+		return nil, 0, err
+	}
+	return payload, historyHeaderSize + length, nil
+}
+
+// encodeHistoryBlock serializes the buckets and compresses them. Per tier it
+// writes the resolution and the series; per series the name and the buckets
+// encoded by encodeHistorySlots.
+func encodeHistoryBlock(tiers []historyTierSlots) ([]byte, error) {
+	var raw bytes.Buffer
+	putUvarint := func(v uint64) {
+		var buf [binary.MaxVarintLen64]byte
+		raw.Write(buf[:binary.PutUvarint(buf[:], v)])
+	}
+
+	putUvarint(uint64(len(tiers)))
+	for _, tier := range tiers {
+		putUvarint(uint64(tier.resolution / time.Second))
+		putUvarint(uint64(len(tier.series)))
+		names := make([]string, 0, len(tier.series))
+		for name := range tier.series {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			data := encodeHistorySlots(tier.series[name])
+			putUvarint(uint64(len(name)))
+			raw.WriteString(name)
+			putUvarint(uint64(len(data)))
+			raw.Write(data)
+		}
+	}
+
+	var compressed bytes.Buffer
+	w, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// encodeHistorySlots compresses buckets, oldest first, with the Gorilla
+// encoding: the bucket numbers as timestamps, and min, max, avg, last and
+// the count each as their own stream of values
+func encodeHistorySlots(slots []historySlot) []byte {
+	var w bitWriter
+	var buckets timestampEncoder
+	var min, max, avg, last, count valueEncoder
+	for _, slot := range slots {
+		buckets.encode(&w, int64(slot.bucket))
+		min.encode(&w, slot.min)
+		max.encode(&w, slot.max)
+		avg.encode(&w, slot.avg)
+		last.encode(&w, slot.last)
+		count.encode(&w, float64(slot.count))
+	}
+
+	var n [binary.MaxVarintLen64]byte
+	return append(n[:binary.PutUvarint(n[:], uint64(len(slots)))], w.buf...)
+}
+
+// decodeHistorySlots decodes buckets written by encodeHistorySlots
+func decodeHistorySlots(data []byte) ([]historySlot, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(len(data))*8 {
+		return nil, errBadHistoryBlock
+	}
+
+	r := &bitReader{buf: data[size:]}
+	var buckets timestampEncoder
+	var min, max, avg, last, count valueEncoder
+	slots := make([]historySlot, n)
+	for i := range slots {
+		slots[i] = historySlot{
+			bucket: uint32(buckets.decode(r)),
+			min:    min.decode(r),
+			max:    max.decode(r),
+			avg:    avg.decode(r),
+			last:   last.decode(r),
+			count:  uint32(count.decode(r)),
+		}
+		if r.err != nil {
+			return nil, errBadHistoryBlock
+		}
+	}
+	return slots, nil
+}
+
+// decodeHistoryBlock parses a payload written by encodeHistoryBlock
+func decodeHistoryBlock(payload []byte) ([]historyTierSlots, error) {
+	r := bytes.NewReader(payload)
+	length := func() (int, error) {
+		n, err := binary.ReadUvarint(r)
+		if err != nil || n > uint64(r.Len()) {
+			return 0, errBadHistoryBlock
+		}
+		return int(n), nil
+	}
+	read := func() ([]byte, error) {
+		n, err := length()
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, errBadHistoryBlock
+		}
+		return buf, nil
+	}
+
+	count, err := length()
+	if err != nil {
+		return nil, err
+	}
+	var tiers []historyTierSlots
+	for i := 0; i < count; i++ {
+		resolution, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errBadHistoryBlock
+		}
+		tier := historyTierSlots{
+			resolution: time.Duration(resolution) * time.Second,
+			series:     make(map[string][]historySlot),
+		}
+
+		series, err := length()
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < series; j++ {
+			name, err := read()
+			if err != nil {
+				return nil, err
+			}
+			data, err := read()
+			if err != nil {
+				return nil, err
+			}
+			slots, err := decodeHistorySlots(data)
+			if err != nil {
+				return nil, err
+			}
+			if len(slots) > 0 {
+				tier.series[string(name)] = slots
+			}
+		}
+		tiers = append(tiers, tier)
+	}
+	return tiers, nil
+}
+
+// historyDir returns the directory of the history store
+func (sm *SystemMonitor) historyDir() string {
+	if dir := sm.cfg.History.Persist.Dir; dir != "" {
+		return dir
+	}
+	return filepath.Join(sm.cfg.StateDir, "history")
+}
+
+// openHistoryStore loads the stored history and returns the store to flush to
+func (sm *SystemMonitor) openHistoryStore() *historyStore {
+	dir := sm.historyDir()
+	store := newHistoryStore(sm.cfg.History.Persist, dir)
+
+	blocks, err := store.load(sm.history, sm)
+	if err != nil {
+		sm.log.Warnf("Failed to load the stored history: %v", err)
+	} else if blocks > 0 {
+		sm.log.Infof("Loaded %d history blocks from %s", blocks, dir)
+	}
+	return store
+}
+
+// LoadHistory reads the stored history into memory without writing to the
+// store, for tools that run next to the monitoring daemon
+func (sm *SystemMonitor) LoadHistory() error {
+	if sm.history == nil {
+		return fmt.Errorf("history is disabled")
+	}
+	store := newHistoryStore(sm.cfg.History.Persist, sm.historyDir())
+	store.readOnly = true
+	_, err := store.load(sm.history, sm)
+	return err
+}
+
+// runHistoryStore flushes the history to storage every flush interval
+func (sm *SystemMonitor) runHistoryStore(stop <-chan struct{}) {
+	ticker := time.NewTicker(sm.historyStore.cfg.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := sm.historyStore.flush(sm.history, false); err != nil {
+				sm.log.Warnf("Failed to store the history: %v", err)
+			}
+		}
+	}
+}
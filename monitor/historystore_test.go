@@ -0,0 +1,178 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testHistoryPersistConfig() HistoryPersistConfig {
+	cfg := DefaultConfig().History.Persist
+	cfg.MinResolution = 0
+	return cfg
+}
+
+func TestHistoryStoreRoundTrip(t *testing.T) {
+	tiers := []HistoryTier{
+		{Resolution: 2 * time.Second, Retention: time.Minute},
+		{Resolution: time.Minute, Retention: time.Hour},
+	}
+	dir := t.TempDir()
+	cfg := testHistoryPersistConfig()
+	cfg.MinResolution = time.Minute
+
+	h := newTestHistory(HistoryConfig{Tiers: tiers, Metrics: []string{"*"}})
+	start := time.Unix(1700000040, 0)
+	for i := 0; i < 150; i++ {
+		h.Record(start.Add(time.Duration(i)*time.Second), map[string]float64{"cpu.usage_percent": float64(i % 60)})
+	}
+
+	store := newHistoryStore(cfg, dir)
+	if err := store.flush(h, false); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing new is completed, so the second flush writes nothing
+	if err := store.flush(h, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.flush(h, true); err != nil {
+		t.Fatal(err)
+	}
+	store.close()
+
+	reloaded := newTestHistory(HistoryConfig{Tiers: tiers, Metrics: []string{"*"}})
+	blocks, err := newHistoryStore(cfg, dir).load(reloaded, &SystemMonitor{log: h.log})
+	if err != nil || blocks != 2 {
+		t.Fatalf("load = %d blocks, %v; want 2", blocks, err)
+	}
+
+	want, _ := h.Query("cpu.usage_percent", start, start.Add(time.Hour), time.Minute)
+	got, err := reloaded.Query("cpu.usage_percent", start, start.Add(time.Hour), time.Minute)
+	if err != nil || len(got) != 3 || len(got) != len(want) {
+		t.Fatalf("reloaded points = %+v, %v; want %+v", got, err, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("point %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// The 2s tier is finer than the minimum resolution and was not stored
+	if points, _ := reloaded.Query("cpu.usage_percent", start, start.Add(time.Hour), 2*time.Second); len(points) != 0 {
+		t.Errorf("expected no raw points, got %d", len(points))
+	}
+}
+
+func TestHistoryStoreRecoversFromPartialBlock(t *testing.T) {
+	tiers := []HistoryTier{{Resolution: time.Second, Retention: time.Minute}}
+	dir := t.TempDir()
+	cfg := testHistoryPersistConfig()
+
+	h := newTestHistory(HistoryConfig{Tiers: tiers, Metrics: []string{"*"}})
+	store := newHistoryStore(cfg, dir)
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		h.Record(start.Add(time.Duration(i)*time.Second), map[string]float64{"memory.used": float64(i)})
+		if err := store.flush(h, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.close()
+
+	paths, err := store.segments()
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("segments = %v, %v", paths, err)
+	}
+	info, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := info.Size()
+
+	// A power cut in the middle of the next block
+	file, err := os.OpenFile(paths[0], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(historyBlockMagic + "\x40\x00\x00\x00garbage"))
+	file.Close()
+
+	reloaded := newTestHistory(HistoryConfig{Tiers: tiers, Metrics: []string{"*"}})
+	blocks, err := newHistoryStore(cfg, dir).load(reloaded, &SystemMonitor{log: h.log})
+	if err != nil || blocks != 2 {
+		t.Fatalf("load = %d blocks, %v; want 2", blocks, err)
+	}
+	if info, err := os.Stat(paths[0]); err != nil || info.Size() != valid {
+		t.Errorf("segment was not truncated to the last valid block: %v, %v", info.Size(), err)
+	}
+	points, err := reloaded.Query("memory.used", start, start.Add(time.Minute), 0)
+	if err != nil || len(points) != 2 {
+		t.Errorf("reloaded points = %+v, %v", points, err)
+	}
+}
+
+func TestHistoryStoreRetention(t *testing.T) {
+	tiers := []HistoryTier{{Resolution: time.Second, Retention: time.Hour}}
+	dir := t.TempDir()
+	cfg := testHistoryPersistConfig()
+	cfg.SegmentSize = 1
+	cfg.MaxSize = 3 << 10
+	cfg.MaxAge = time.Hour
+
+	// A segment last written long ago is deleted by age
+	old := filepath.Join(dir, "0000000000000001"+historySegmentExt)
+	writeTestFile(t, old, "")
+	os.Chtimes(old, time.Now().Add(-2*time.Hour), time.Now().Add(-2*time.Hour))
+
+	h := newTestHistory(HistoryConfig{Tiers: tiers, Metrics: []string{"*"}})
+	store := newHistoryStore(cfg, dir)
+	start := time.Unix(1700000000, 0)
+	metrics := make(map[string]float64)
+	for i := 0; i < 50; i++ {
+		for j := 0; j < 20; j++ {
+			metrics["custom.metric"+string(rune('a'+j))] = float64(i * j)
+		}
+		h.Record(start.Add(time.Duration(i)*time.Second), metrics)
+		if err := store.flush(h, false); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	store.close()
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Error("expected the expired segment to be deleted")
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size()
+	}
+	if total > cfg.MaxSize || len(entries) >= 49 {
+		t.Errorf("store holds %d bytes in %d segments, max %d", total, len(entries), cfg.MaxSize)
+	}
+}
+
+func TestDecodeHistoryBlockRejectsGarbage(t *testing.T) {
+	payload, err := encodeHistoryBlock([]historyTierSlots{{
+		resolution: time.Minute,
+		series:     map[string][]historySlot{"cpu.usage_percent": {{bucket: 10, count: 2, min: 1, max: 3, avg: 2}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := append([]byte(historyBlockMagic+"\x00\x00\x00\x00\x00\x00\x00\x00"), payload...)
+	if _, _, err := readHistoryBlock(block); err == nil {
+		t.Error("expected a checksum error")
+	}
+	for _, raw := range [][]byte{{0x01, 0x3c, 0xff, 0xff, 0xff, 0xff, 0x0f}, {0x01}, {0x05, 0x3c, 0x01, 0x03, 'c'}, {0x01, 0x3c, 0x01, 0x01, 'c', 0x02, 0x05, 0x00}} {
+		if _, err := decodeHistoryBlock(raw); err == nil {
+			t.Errorf("expected an error decoding %x", raw)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package monitor
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// irqTriggerPattern matches the trigger type that precedes the device names
+// in /proc/interrupts, e.g. "Level", "Edge", "2-edge" or "16-fasteoi"
+var irqTriggerPattern = regexp.MustCompile(`(?i)^(level|edge|\S+-(edge|level|fasteoi))$`)
+
+// InterruptStats represents interrupt, softirq and scheduler activity rates
+type InterruptStats struct {
+	Total           float64            `json:"total"`            // hardware interrupts per second
+	Top             []IRQRate          `json:"top"`              // busiest IRQ sources, highest rate first
+	SoftIRQs        map[string]float64 `json:"softirqs"`         // softirq -> per second
+	ContextSwitches float64            `json:"context_switches"` // per second
+	Forks           float64            `json:"forks"`            // per second
+	ProcsRunning    uint64             `json:"procs_running"`
+	ProcsBlocked    uint64             `json:"procs_blocked"`
+}
+
+// IRQRate represents the rate of one interrupt source
+type IRQRate struct {
+	IRQ     string    `json:"irq"`
+	Devices string    `json:"devices"`
+	Rate    float64   `json:"rate"`    // per second across all CPUs
+	PerCPU  []float64 `json:"per_cpu"` // per second on each CPU
+}
+
+// irqCounters holds the counters of one /proc/interrupts line
+type irqCounters struct {
+	IRQ     string
+	Devices string
+	PerCPU  []uint64
+}
+
+// procStatCounters holds the scheduler counters of /proc/stat
+type procStatCounters struct {
+	ContextSwitches uint64
+	Forks           uint64
+	ProcsRunning    uint64
+	ProcsBlocked    uint64
+}
+
+// interruptTracker keeps the previous sample to compute rates from
+type interruptTracker struct {
+	mu       sync.Mutex
+	time     time.Time
+	irqs     map[string]irqCounters
+	softirqs map[string][]uint64
+	procStat procStatCounters
+}
+
+// getInterruptStats collects interrupt rates since the previous sample
+func (sm *SystemMonitor) getInterruptStats() (*InterruptStats, error) {
+	file, err := os.Open("/proc/interrupts")
+	if err != nil {
+		return nil, err
+	}
+	irqs, err := parseInterrupts(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err = os.Open("/proc/softirqs")
+	if err != nil {
+		return nil, err
+	}
+	softirqs, err := parseSoftirqs(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err = os.Open("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	procStat, err := parseProcStat(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return sm.interrupts.update(irqs, softirqs, procStat, time.Now(), sm.cfg.TopInterrupts), nil
+}
+
+// update computes the rates against the previous sample and stores the new one
+func (it *interruptTracker) update(irqs map[string]irqCounters, softirqs map[string][]uint64,
+	procStat procStatCounters, now time.Time, top int) *InterruptStats {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	stats := &InterruptStats{
+		SoftIRQs:     make(map[string]float64),
+		ProcsRunning: procStat.ProcsRunning,
+		ProcsBlocked: procStat.ProcsBlocked,
+	}
+
+	seconds := now.Sub(it.time).Seconds()
+	if !it.time.IsZero() && seconds > 0 {
+		for name, irq := range irqs {
+			prev, ok := it.irqs[name]
+			if !ok {
+				continue
+			}
+			rate := IRQRate{IRQ: name, Devices: irq.Devices, PerCPU: make([]float64, len(irq.PerCPU))}
+			for cpu, count := range irq.PerCPU {
+				if cpu < len(prev.PerCPU) {
+					rate.PerCPU[cpu] = counterRate(prev.PerCPU[cpu], count, seconds)
+					rate.Rate += rate.PerCPU[cpu]
+				}
+			}
+			stats.Total += rate.Rate
+			if rate.Rate > 0 {
+				stats.Top = append(stats.Top, rate)
+			}
+		}
+
+		for name, counts := range softirqs {
+			prev := it.softirqs[name]
+			for cpu, count := range counts {
+				if cpu < len(prev) {
+					stats.SoftIRQs[name] += counterRate(prev[cpu], count, seconds)
+				}
+			}
+		}
+
+		stats.ContextSwitches = counterRate(it.procStat.ContextSwitches, procStat.ContextSwitches, seconds)
+		stats.Forks = counterRate(it.procStat.Forks, procStat.Forks, seconds)
+	}
+
+	sort.Slice(stats.Top, func(i, j int) bool { return stats.Top[i].Rate > stats.Top[j].Rate })
+	if len(stats.Top) > top {
+		stats.Top = stats.Top[:top]
+	}
+
+	it.time = now
+	it.irqs = irqs
+	it.softirqs = softirqs
+	it.procStat = procStat
+
+	return stats
+}
+
+// counterRate returns the per-second rate of a monotonic counter, treating
+// a counter that went backwards as reset
+func counterRate(prev, cur uint64, seconds float64) float64 {
+	if cur < prev || seconds <= 0 {
+		return 0
+	}
+	return float64(cur-prev) / seconds
+}
+
+// parseInterrupts parses /proc/interrupts. The header lists the online
+// CPUs; each line holds an IRQ number or name, one count per CPU and a
+// description ending in the device names.
+func parseInterrupts(r io.Reader) (map[string]irqCounters, error) {
+	irqs := make(map[string]irqCounters)
+
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() {
+		return irqs, scanner.Err()
+	}
+	cpus := len(strings.Fields(scanner.Text()))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:colon])
+		fields := strings.Fields(line[colon+1:])
+
+		irq := irqCounters{IRQ: name}
+		i := 0
+		for ; i < len(fields) && i < cpus; i++ {
+			count, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				break
+			}
+			irq.PerCPU = append(irq.PerCPU, count)
+		}
+		irq.Devices = irqDevices(fields[i:])
+		irqs[name] = irq
+	}
+
+	return irqs, scanner.Err()
+}
+
+// irqDevices extracts the device names from the description of an IRQ line.
+// Numbered IRQs list the chip, hardware IRQ and trigger before the devices;
+// named ones like "NMI" only have a description.
+func irqDevices(fields []string) string {
+	for i := len(fields) - 1; i >= 0; i-- {
+		if irqTriggerPattern.MatchString(fields[i]) {
+			if i+1 < len(fields) {
+				return strings.Join(fields[i+1:], " ")
+			}
+			break
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// parseSoftirqs parses /proc/softirqs into per-CPU counts per softirq
+func parseSoftirqs(r io.Reader) (map[string][]uint64, error) {
+	softirqs := make(map[string][]uint64)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // skip the CPU header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.HasSuffix(fields[0], ":") {
+			continue
+		}
+		name := strings.TrimSuffix(fields[0], ":")
+		for _, field := range fields[1:] {
+			count, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				break
+			}
+			softirqs[name] = append(softirqs[name], count)
+		}
+	}
+
+	return softirqs, scanner.Err()
+}
+
+// parseProcStat parses the scheduler counters of /proc/stat
+func parseProcStat(r io.Reader) (procStatCounters, error) {
+	var counters procStatCounters
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // the intr line can be very long
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "ctxt":
+			counters.ContextSwitches = value
+		case "processes":
+			counters.Forks = value
+		case "procs_running":
+			counters.ProcsRunning = value
+		case "procs_blocked":
+			counters.ProcsBlocked = value
+		}
+	}
+
+	return counters, scanner.Err()
+}
@@ -0,0 +1,62 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testInterrupts = `           CPU0       CPU1
+ 11:        100        200     GICv2  30 Level     arch_timer
+ 42:         10          0     GICv2  65 Level     mmc0, mmc1
+ 16:          5          5   IO-APIC  16-fasteoi   i801_smbus, ehci_hcd:usb1
+IPI0:         50         60       Rescheduling interrupts
+ERR:          0
+`
+
+func TestParseInterrupts(t *testing.T) {
+	irqs, err := parseInterrupts(strings.NewReader(testInterrupts))
+	if err != nil {
+		t.Fatalf("parseInterrupts: %v", err)
+	}
+
+	cases := map[string]string{
+		"11":   "arch_timer",
+		"42":   "mmc0, mmc1",
+		"16":   "i801_smbus, ehci_hcd:usb1",
+		"IPI0": "Rescheduling interrupts",
+	}
+	for irq, devices := range cases {
+		if got := irqs[irq].Devices; got != devices {
+			t.Errorf("IRQ %s devices = %q, want %q", irq, got, devices)
+		}
+	}
+	if got := irqs["11"].PerCPU; len(got) != 2 || got[1] != 200 {
+		t.Errorf("IRQ 11 counts = %v", got)
+	}
+	if got := irqs["ERR"].PerCPU; len(got) != 1 {
+		t.Errorf("ERR counts = %v", got)
+	}
+}
+
+func TestInterruptRates(t *testing.T) {
+	var tracker interruptTracker
+	now := time.Now()
+
+	first, _ := parseInterrupts(strings.NewReader(testInterrupts))
+	tracker.update(first, nil, procStatCounters{ContextSwitches: 1000, Forks: 10}, now, 10)
+
+	second, _ := parseInterrupts(strings.NewReader(strings.Replace(testInterrupts,
+		"10          0     GICv2  65", "1010        500     GICv2  65", 1)))
+	stats := tracker.update(second, nil, procStatCounters{ContextSwitches: 3000, Forks: 12, ProcsBlocked: 1}, now.Add(2*time.Second), 10)
+
+	if len(stats.Top) != 1 || stats.Top[0].IRQ != "42" {
+		t.Fatalf("expected IRQ 42 on top, got %+v", stats.Top)
+	}
+	if stats.Top[0].Rate != 750 || stats.Top[0].PerCPU[0] != 500 || stats.Top[0].PerCPU[1] != 250 {
+		t.Errorf("unexpected rates: %+v", stats.Top[0])
+	}
+	if stats.ContextSwitches != 1000 || stats.Forks != 1 || stats.ProcsBlocked != 1 {
+		t.Errorf("unexpected scheduler stats: %+v", stats)
+	}
+}
@@ -0,0 +1,257 @@
+package monitor
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inventory describes the device itself: what it is and what it is made of.
+// It is collected once and refreshed rarely, as it hardly ever changes.
+type Inventory struct {
+	CollectedAt   time.Time         `json:"collected_at"`
+	Hostname      string            `json:"hostname"`
+	KernelRelease string            `json:"kernel_release"`
+	OSName        string            `json:"os_name"` // PRETTY_NAME from /etc/os-release
+	OSRelease     map[string]string `json:"os_release"`
+	Model         string            `json:"model"`  // device-tree model
+	Serial        string            `json:"serial"` // device-tree serial number
+	CPUModel      string            `json:"cpu_model"`
+	CPUCores      int               `json:"cpu_cores"`
+	MemoryTotal   uint64            `json:"memory_total"`
+	BlockDevices  []BlockDevice     `json:"block_devices"`
+	Interfaces    []NetInterface    `json:"interfaces"`
+	BootTime      time.Time         `json:"boot_time"`
+	Uptime        float64           `json:"uptime"` // seconds, updated on every call
+}
+
+// BlockDevice describes a block device
+type BlockDevice struct {
+	Name      string `json:"name"`
+	Size      uint64 `json:"size"`
+	Model     string `json:"model,omitempty"`
+	Removable bool   `json:"removable"`
+}
+
+// NetInterface describes a network interface
+type NetInterface struct {
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+	MTU  int    `json:"mtu"`
+}
+
+// inventoryCache keeps the last inventory until it is due for a refresh
+type inventoryCache struct {
+	mu        sync.Mutex
+	inventory *Inventory
+}
+
+// GetInventory returns the device inventory, collecting it on the first
+// call and again once the refresh interval has passed
+func (sm *SystemMonitor) GetInventory() *Inventory {
+	if sm.replay != nil {
+		return sm.replay.replayInventory()
+	}
+
+	sm.inventory.mu.Lock()
+	defer sm.inventory.mu.Unlock()
+
+	if sm.inventory.inventory == nil || time.Since(sm.inventory.inventory.CollectedAt) > sm.cfg.InventoryRefresh {
+		sm.inventory.inventory = sm.collectInventory()
+	}
+
+	inv := *sm.inventory.inventory
+	inv.Uptime = time.Since(inv.BootTime).Seconds()
+	return &inv
+}
+
+// collectInventory reads every inventory item, leaving out what is unavailable
+func (sm *SystemMonitor) collectInventory() *Inventory {
+	inv := &Inventory{
+		CollectedAt:   time.Now(),
+		KernelRelease: readAttrString("/proc/sys/kernel", "osrelease"),
+		Model:         readDeviceTreeString("/proc/device-tree/model"),
+		Serial:        readDeviceTreeString("/proc/device-tree/serial-number"),
+		BlockDevices:  readBlockDevices(blockPath),
+		Interfaces:    readNetInterfaces("/sys/class/net"),
+		BootTime:      readBootTimeStat(),
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		inv.Hostname = hostname
+	}
+
+	if file, err := os.Open("/etc/os-release"); err == nil {
+		inv.OSRelease = parseOSRelease(file)
+		file.Close()
+		inv.OSName = inv.OSRelease["PRETTY_NAME"]
+	}
+
+	if file, err := os.Open("/proc/cpuinfo"); err == nil {
+		inv.CPUModel, inv.CPUCores = parseCPUInfo(file)
+		file.Close()
+	}
+	if inv.CPUCores == 0 {
+		inv.CPUCores = runtime.NumCPU()
+	}
+
+	if memStats, err := sm.getMemoryStats(); err == nil {
+		inv.MemoryTotal = memStats.Total
+	}
+
+	return inv
+}
+
+// readDeviceTreeString reads a NUL-terminated device-tree string property
+func readDeviceTreeString(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+}
+
+// parseOSRelease parses the KEY=value lines of /etc/os-release
+func parseOSRelease(r io.Reader) map[string]string {
+	release := make(map[string]string)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value := parts[1]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		release[parts[0]] = value
+	}
+
+	return release
+}
+
+// parseCPUInfo returns the CPU model and the number of cores in /proc/cpuinfo.
+// x86 reports "model name"; ARM kernels may only report "Hardware" or "Processor".
+func parseCPUInfo(r io.Reader) (string, int) {
+	var model, fallback string
+	cores := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		value := strings.TrimSpace(parts[1])
+
+		switch key {
+		case "processor":
+			cores++
+		case "model name":
+			if model == "" {
+				model = value
+			}
+		case "Hardware", "Processor", "cpu model":
+			if fallback == "" {
+				fallback = value
+			}
+		}
+	}
+
+	if model == "" {
+		model = fallback
+	}
+	return model, cores
+}
+
+// readBlockDevices lists the block devices with their sizes
+func readBlockDevices(blockPath string) []BlockDevice {
+	entries, err := ioutil.ReadDir(blockPath)
+	if err != nil {
+		return nil
+	}
+
+	var devices []BlockDevice
+	for _, entry := range entries {
+		name := entry.Name()
+		if skipWriteDevice(name) {
+			continue
+		}
+		dir := filepath.Join(blockPath, name)
+
+		sectors, _ := strconv.ParseUint(readAttrString(dir, "size"), 10, 64)
+		devices = append(devices, BlockDevice{
+			Name:      name,
+			Size:      sectors * sectorSize,
+			Model:     readAttrString(filepath.Join(dir, "device"), "model"),
+			Removable: readAttrString(dir, "removable") == "1",
+		})
+	}
+
+	return devices
+}
+
+// readNetInterfaces lists the network interfaces with their MAC addresses
+func readNetInterfaces(netPath string) []NetInterface {
+	entries, err := ioutil.ReadDir(netPath)
+	if err != nil {
+		return nil
+	}
+
+	var interfaces []NetInterface
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == "lo" {
+			continue
+		}
+		dir := filepath.Join(netPath, name)
+
+		mtu, _ := strconv.Atoi(readAttrString(dir, "mtu"))
+		interfaces = append(interfaces, NetInterface{
+			Name: name,
+			MAC:  readAttrString(dir, "address"),
+			MTU:  mtu,
+		})
+	}
+
+	sort.Slice(interfaces, func(i, j int) bool { return interfaces[i].Name < interfaces[j].Name })
+	return interfaces
+}
+
+// readBootTimeStat reads the boot time from the btime line of /proc/stat,
+// falling back to /proc/uptime
+func readBootTimeStat() time.Time {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return readBootTime()
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			if btime, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				return time.Unix(btime, 0)
+			}
+		}
+	}
+
+	return readBootTime()
+}
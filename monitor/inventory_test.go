@@ -0,0 +1,51 @@
+package monitor
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	input := `# comment
+NAME="Poky (Yocto Project Reference Distro)"
+VERSION_ID=4.0.4
+PRETTY_NAME="Poky (Yocto Project Reference Distro) 4.0.4 (kirkstone)"
+`
+	release := parseOSRelease(strings.NewReader(input))
+	if release["VERSION_ID"] != "4.0.4" {
+		t.Errorf("VERSION_ID = %q", release["VERSION_ID"])
+	}
+	if release["PRETTY_NAME"] != "Poky (Yocto Project Reference Distro) 4.0.4 (kirkstone)" {
+		t.Errorf("PRETTY_NAME = %q", release["PRETTY_NAME"])
+	}
+}
+
+func TestParseCPUInfoARM(t *testing.T) {
+	input := `processor	: 0
+BogoMIPS	: 108.00
+CPU part	: 0xd08
+
+processor	: 1
+BogoMIPS	: 108.00
+
+Hardware	: BCM2835
+Model		: Raspberry Pi 4 Model B Rev 1.4
+`
+	model, cores := parseCPUInfo(strings.NewReader(input))
+	if model != "BCM2835" || cores != 2 {
+		t.Errorf("got %q with %d cores", model, cores)
+	}
+}
+
+func TestReadBlockDevices(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "mmcblk0", "size"), "15269888\n")
+	writeTestFile(t, filepath.Join(root, "mmcblk0", "removable"), "0\n")
+	writeTestFile(t, filepath.Join(root, "loop0", "size"), "0\n")
+
+	devices := readBlockDevices(root)
+	if len(devices) != 1 || devices[0].Name != "mmcblk0" || devices[0].Size != 15269888*512 {
+		t.Errorf("unexpected devices: %+v", devices)
+	}
+}
@@ -0,0 +1,396 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// kmsgPriorities names the syslog priorities used by the kernel log
+var kmsgPriorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// kmsgFacilities names the syslog facilities
+var kmsgFacilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp"}
+
+// KernelLogStats represents the kernel log rule counters
+type KernelLogStats struct {
+	Messages uint64            `json:"messages"` // messages read since emmon started
+	Errors   uint64            `json:"errors"`   // messages at priority err or worse
+	Counters map[string]uint64 `json:"counters"` // rule name -> matches since emmon started
+	Recent   map[string]uint64 `json:"recent"`   // rule name -> matches within the recent window
+	Error    string            `json:"error,omitempty"`
+}
+
+// KernelMessage represents one kernel log record
+type KernelMessage struct {
+	Time     time.Time `json:"time"`
+	Uptime   float64   `json:"uptime"` // seconds since boot
+	Seq      uint64    `json:"seq"`
+	Priority int       `json:"priority"`
+	Level    string    `json:"level"`
+	Facility string    `json:"facility"`
+	Message  string    `json:"message"`
+	Rule     string    `json:"rule,omitempty"` // name of the first matching rule
+}
+
+// KernelLogRule raises a named event when a kernel message matches the pattern
+type KernelLogRule struct {
+	Name     string      `mapstructure:"name"`
+	Pattern  string      `mapstructure:"pattern"`
+	Severity HealthLevel `mapstructure:"severity"`
+}
+
+// DefaultKernelLogRules returns the rules applied when none are configured
+func DefaultKernelLogRules() []KernelLogRule {
+	return []KernelLogRule{
+		{Name: "io_error", Pattern: `I/O error|blk_update_request|Buffer I/O error`, Severity: HealthCritical},
+		{Name: "under_voltage", Pattern: `(?i)under-?voltage`, Severity: HealthWarning},
+		{Name: "oom_kill", Pattern: `Out of memory|oom-kill|Killed process`, Severity: HealthCritical},
+		{Name: "usb_disconnect", Pattern: `USB disconnect`, Severity: HealthWarning},
+		{Name: "thermal_shutdown", Pattern: `(?i)critical temperature|thermal.*shut ?down`, Severity: HealthCritical},
+		{Name: "mmc_error", Pattern: `mmc\d+: .*(error|timeout)`, Severity: HealthWarning},
+	}
+}
+
+// compiledKernelRule is a kernel log rule with its pattern compiled
+type compiledKernelRule struct {
+	KernelLogRule
+	re *regexp.Regexp
+}
+
+// kernelLog follows the kernel log and keeps the recent messages and counters
+type kernelLog struct {
+	cfg   KernelLogConfig
+	rules []compiledKernelRule
+
+	mu       sync.RWMutex
+	messages []KernelMessage // ring of recent messages
+	next     int
+	full     bool
+	added    uint64 // messages stored so far
+	stats    KernelLogStats
+	matches  map[string][]time.Time // rule name -> match times within the window
+	bootTime time.Time
+}
+
+// newKernelLog creates a kernel log follower, skipping rules that do not compile
+func newKernelLog(cfg KernelLogConfig, sm *SystemMonitor) *kernelLog {
+	size := cfg.Buffer
+	if size < 1 {
+		size = 1
+	}
+
+	kl := &kernelLog{
+		cfg:      cfg,
+		messages: make([]KernelMessage, size),
+		matches:  make(map[string][]time.Time),
+		stats: KernelLogStats{
+			Counters: make(map[string]uint64),
+		},
+	}
+
+	for _, rule := range cfg.Rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			sm.log.Errorf("Invalid kernel log rule %s: %v", rule.Name, err)
+			continue
+		}
+		kl.rules = append(kl.rules, compiledKernelRule{KernelLogRule: rule, re: re})
+		kl.stats.Counters[rule.Name] = 0
+	}
+
+	return kl
+}
+
+// followKernelLog reads the kernel log until stop is closed. /dev/kmsg
+// blocks until a new record arrives; regular files are polled like tail -f.
+// The records already in the /dev/kmsg ring, which is read again on every
+// start and reload, fill the recent messages but are not counted.
+func (sm *SystemMonitor) followKernelLog(stop <-chan struct{}) {
+	kl := sm.kmsg
+	kl.bootTime = readBootTime()
+
+	file, err := os.Open(kl.cfg.Path)
+	if err != nil {
+		sm.log.Warnf("Kernel log not available: %v", err)
+		kl.setError(err)
+		return
+	}
+	var started time.Duration
+	if info, err := file.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		started = monotonicUptime()
+	}
+	go func() {
+		<-stop
+		file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	partial := ""
+	for {
+		line, err := reader.ReadString('\n')
+		partial += line
+		if strings.HasSuffix(partial, "\n") {
+			if msg, ok := parseKmsgRecord(strings.TrimRight(partial, "\n"), kl.bootTime); ok {
+				if time.Duration(msg.Uptime*float64(time.Second)) < started {
+					kl.addBacklog(msg)
+				} else if event, matched := kl.add(msg); matched {
+					sm.emitEvent(event)
+				}
+			}
+			partial = ""
+		}
+
+		switch {
+		case err == nil:
+		case err == io.EOF:
+			// Regular file: wait for more lines like tail -f
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Second):
+			}
+		case errors.Is(err, syscall.EPIPE):
+			// /dev/kmsg returns EPIPE when records were overwritten before
+			// we read them; the next read continues with the oldest one left
+		default:
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sm.log.Warnf("Failed to read kernel log: %v", err)
+			kl.setError(err)
+			return
+		}
+	}
+}
+
+// add stores a message, applies the rules and returns the raised event
+func (kl *kernelLog) add(msg KernelMessage) (Event, bool) {
+	rule := kl.match(&msg)
+
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	kl.store(msg)
+	kl.stats.Messages++
+	if msg.Priority <= 3 {
+		kl.stats.Errors++
+	}
+	if rule == nil {
+		return Event{}, false
+	}
+
+	kl.stats.Counters[rule.Name]++
+	kl.matches[rule.Name] = append(kl.pruneMatches(rule.Name, time.Now()), msg.Time)
+
+	return Event{
+		Time:     msg.Time,
+		Source:   "kmsg",
+		Name:     rule.Name,
+		Severity: rule.Severity,
+		Message:  msg.Message,
+	}, true
+}
+
+// addBacklog stores a message logged before emmon started. It is shown with
+// the recent messages, but not counted and raises no event.
+func (kl *kernelLog) addBacklog(msg KernelMessage) {
+	kl.match(&msg)
+
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	kl.store(msg)
+}
+
+// match returns the first rule matching the message and notes it in msg
+func (kl *kernelLog) match(msg *KernelMessage) *compiledKernelRule {
+	for i := range kl.rules {
+		if kl.rules[i].re.MatchString(msg.Message) {
+			msg.Rule = kl.rules[i].Name
+			return &kl.rules[i]
+		}
+	}
+	return nil
+}
+
+// store adds a message to the ring of recent messages
+func (kl *kernelLog) store(msg KernelMessage) {
+	kl.messages[kl.next] = msg
+	kl.next = (kl.next + 1) % len(kl.messages)
+	if kl.next == 0 {
+		kl.full = true
+	}
+	kl.added++
+}
+
+// pruneMatches drops the match times of a rule that fell out of the window
+func (kl *kernelLog) pruneMatches(name string, now time.Time) []time.Time {
+	times := kl.matches[name]
+	cutoff := now.Add(-kl.cfg.Window)
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// setError records why the kernel log cannot be read
+func (kl *kernelLog) setError(err error) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	kl.stats.Error = err.Error()
+}
+
+// snapshot returns a copy of the counters
+func (kl *kernelLog) snapshot() KernelLogStats {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+
+	stats := kl.stats
+	stats.Counters = make(map[string]uint64, len(kl.stats.Counters))
+	stats.Recent = make(map[string]uint64, len(kl.stats.Counters))
+	now := time.Now()
+	for name, count := range kl.stats.Counters {
+		stats.Counters[name] = count
+		kl.matches[name] = kl.pruneMatches(name, now)
+		stats.Recent[name] = uint64(len(kl.matches[name]))
+	}
+	return stats
+}
+
+// recent returns the buffered messages, oldest first
+func (kl *kernelLog) recent() []KernelMessage {
+	kl.mu.RLock()
+	defer kl.mu.RUnlock()
+
+	return kl.held()
+}
+
+// since returns the messages stored after the first n that are still held,
+// and the number stored so far
+func (kl *kernelLog) since(n uint64) ([]KernelMessage, uint64) {
+	kl.mu.RLock()
+	defer kl.mu.RUnlock()
+
+	messages := kl.held()
+	if missed := kl.added - n; missed < uint64(len(messages)) {
+		messages = messages[len(messages)-int(missed):]
+	}
+	return messages, kl.added
+}
+
+// held copies the messages in the ring, oldest first. The caller holds mu.
+func (kl *kernelLog) held() []KernelMessage {
+	if !kl.full {
+		return append([]KernelMessage(nil), kl.messages[:kl.next]...)
+	}
+	return append(append([]KernelMessage(nil), kl.messages[kl.next:]...), kl.messages[:kl.next]...)
+}
+
+// KernelMessages returns the recent kernel log messages, oldest first
+func (sm *SystemMonitor) KernelMessages() []KernelMessage {
+	if sm.kmsg == nil {
+		return nil
+	}
+	return sm.kmsg.recent()
+}
+
+// getKernelLogStats returns the kernel log counters
+func (sm *SystemMonitor) getKernelLogStats() (*KernelLogStats, error) {
+	if sm.kmsg == nil {
+		return &KernelLogStats{}, nil
+	}
+	stats := sm.kmsg.snapshot()
+	return &stats, nil
+}
+
+// parseKmsgRecord parses a /dev/kmsg record of the form
+// "priority,sequence,timestamp_us,flags[,...];message". Continuation lines
+// (starting with a space) carry device properties and are skipped. Lines
+// in other formats are kept as info messages so plain log files work too.
+func parseKmsgRecord(line string, bootTime time.Time) (KernelMessage, bool) {
+	if line == "" || strings.HasPrefix(line, " ") {
+		return KernelMessage{}, false
+	}
+
+	sep := strings.Index(line, ";")
+	if sep < 0 {
+		return KernelMessage{Time: time.Now(), Priority: 6, Level: "info", Facility: "kern", Message: line}, true
+	}
+
+	header := strings.Split(line[:sep], ",")
+	if len(header) < 3 {
+		return KernelMessage{Time: time.Now(), Priority: 6, Level: "info", Facility: "kern", Message: line}, true
+	}
+
+	prefix, err1 := strconv.Atoi(header[0])
+	seq, err2 := strconv.ParseUint(header[1], 10, 64)
+	usec, err3 := strconv.ParseUint(header[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return KernelMessage{Time: time.Now(), Priority: 6, Level: "info", Facility: "kern", Message: line}, true
+	}
+
+	msg := KernelMessage{
+		Uptime:   float64(usec) / 1e6,
+		Seq:      seq,
+		Priority: prefix & 7,
+		Level:    kmsgPriorities[prefix&7],
+		Facility: fmt.Sprintf("%d", prefix>>3),
+		Message:  unescapeKmsg(line[sep+1:]),
+	}
+	if facility := prefix >> 3; facility < len(kmsgFacilities) {
+		msg.Facility = kmsgFacilities[facility]
+	}
+	msg.Time = bootTime.Add(time.Duration(usec) * time.Microsecond)
+
+	return msg, true
+}
+
+// unescapeKmsg decodes the \xNN escapes /dev/kmsg uses for non-printable bytes
+func unescapeKmsg(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if c, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// readBootTime derives the wall clock time of boot from /proc/uptime
+func readBootTime() time.Time {
+	data, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return time.Now()
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return time.Now()
+	}
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return time.Now()
+	}
+	return time.Now().Add(-time.Duration(uptime * float64(time.Second)))
+}
@@ -0,0 +1,19 @@
+//go:build linux
+
+package monitor
+
+import (
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// monotonicUptime returns the time since boot on the clock /dev/kmsg stamps
+// its records with, which unlike /proc/uptime stops during suspend
+func monotonicUptime() time.Duration {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return time.Duration(ts.Nano())
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package monitor
+
+import "time"
+
+// monotonicUptime is only needed for /dev/kmsg, which only Linux has
+func monotonicUptime() time.Duration {
+	return 0
+}
@@ -0,0 +1,98 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseKmsgRecord(t *testing.T) {
+	boot := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	msg, ok := parseKmsgRecord(`3,1024,5000000,-;mmc0: error -110 whilst initialising\x20card`, boot)
+	if !ok {
+		t.Fatal("record not parsed")
+	}
+	if msg.Priority != 3 || msg.Level != "err" || msg.Facility != "kern" || msg.Seq != 1024 {
+		t.Errorf("unexpected header: %+v", msg)
+	}
+	if !msg.Time.Equal(boot.Add(5*time.Second)) || msg.Uptime != 5 {
+		t.Errorf("unexpected time: %v (%v)", msg.Time, msg.Uptime)
+	}
+	if msg.Message != "mmc0: error -110 whilst initialising card" {
+		t.Errorf("unexpected message: %q", msg.Message)
+	}
+
+	// Facility user (1) at priority info (6)
+	if msg, _ := parseKmsgRecord("14,1,1,-;hello", boot); msg.Facility != "user" || msg.Level != "info" {
+		t.Errorf("unexpected facility/level: %s/%s", msg.Facility, msg.Level)
+	}
+
+	if _, ok := parseKmsgRecord(" SUBSYSTEM=usb", boot); ok {
+		t.Error("continuation lines should be skipped")
+	}
+}
+
+func TestFollowKernelLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kmsg")
+	records := "6,1,1000,-;usb 1-1: new high-speed USB device\n" +
+		"4,2,2000,-;usb 1-1: USB disconnect, device number 2\n" +
+		" SUBSYSTEM=usb\n" +
+		"0,3,3000,-;Out of memory: Killed process 123 (app)\n"
+	if err := ioutil.WriteFile(path, []byte(records), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.StateDir = t.TempDir()
+	cfg.KernelLog.Path = path
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	sm := NewSystemMonitor(log, cfg)
+	sm.Start()
+	defer sm.Stop()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sm.KernelMessages()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := len(sm.KernelMessages()); n != 3 {
+		t.Fatalf("expected 3 messages, got %d", n)
+	}
+	stats, _ := sm.getKernelLogStats()
+	if stats.Counters["usb_disconnect"] != 1 || stats.Counters["oom_kill"] != 1 {
+		t.Errorf("unexpected counters: %v", stats.Counters)
+	}
+	if stats.Errors != 1 {
+		t.Errorf("expected 1 error-level message, got %d", stats.Errors)
+	}
+	if events := sm.RecentEvents(); len(events) != 2 || events[1].Name != "oom_kill" {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestKernelLogBacklog(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	sm := NewSystemMonitor(log, DefaultConfig())
+	boot := time.Now().Add(-time.Hour)
+
+	// An OOM kill from before emmon started is shown but not counted again
+	old, _ := parseKmsgRecord("0,3,3000,-;Out of memory: Killed process 123 (app)", boot)
+	sm.kmsg.addBacklog(old)
+	recent, _ := parseKmsgRecord("4,4,9000,-;usb 1-1: USB disconnect, device number 2", boot)
+	sm.kmsg.add(recent)
+
+	messages := sm.KernelMessages()
+	if len(messages) != 2 || messages[0].Rule != "oom_kill" {
+		t.Fatalf("unexpected messages: %+v", messages)
+	}
+	stats, _ := sm.getKernelLogStats()
+	if stats.Messages != 1 || stats.Errors != 0 || stats.Counters["oom_kill"] != 0 || stats.Counters["usb_disconnect"] != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,227 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpStateNames names the connection states in /proc/net/tcp by their hex code
+var tcpStateNames = map[string]string{
+	"01": "established",
+	"02": "syn_sent",
+	"03": "syn_recv",
+	"04": "fin_wait1",
+	"05": "fin_wait2",
+	"06": "time_wait",
+	"07": "close",
+	"08": "close_wait",
+	"09": "last_ack",
+	"0A": "listen",
+	"0B": "closing",
+	"0C": "new_syn_recv",
+}
+
+// LimitsStats represents the usage of kernel tables that can run out
+type LimitsStats struct {
+	Files     LimitUsage        `json:"files"`               // open file handles against fs.file-max
+	PIDs      LimitUsage        `json:"pids"`                // tasks against kernel.pid_max
+	Conntrack *LimitUsage       `json:"conntrack,omitempty"` // tracked connections, nil without nf_conntrack
+	Entropy   LimitUsage        `json:"entropy"`             // entropy_avail against the pool size
+	Sockets   SocketStats       `json:"sockets"`
+	TCPStates map[string]uint64 `json:"tcp_states"` // state -> IPv4 and IPv6 connections
+}
+
+// LimitUsage represents how much of a kernel limit is in use
+type LimitUsage struct {
+	Used    uint64  `json:"used"`
+	Max     uint64  `json:"max"`
+	Percent float64 `json:"percent"`
+}
+
+// SocketStats represents the socket counters of /proc/net/sockstat
+type SocketStats struct {
+	Used            uint64  `json:"used"`
+	TCPInUse        uint64  `json:"tcp_inuse"`
+	TCPOrphan       uint64  `json:"tcp_orphan"`
+	TCPTimeWait     uint64  `json:"tcp_tw"`
+	TCPAlloc        uint64  `json:"tcp_alloc"`
+	TCPMemory       uint64  `json:"tcp_memory"` // bytes
+	UDPInUse        uint64  `json:"udp_inuse"`
+	UDPMemory       uint64  `json:"udp_memory"`     // bytes
+	OrphanPercent   float64 `json:"orphan_percent"` // against net.ipv4.tcp_max_orphans
+	TimeWaitPercent float64 `json:"tw_percent"`     // against net.ipv4.tcp_max_tw_buckets
+	MemoryPercent   float64 `json:"memory_percent"` // against the tcp_mem maximum
+}
+
+// getLimitsStats collects the usage of the kernel file, socket, conntrack,
+// pid and entropy tables. Only the file handle table is required; the others
+// are left empty when the kernel does not expose them.
+func (sm *SystemMonitor) getLimitsStats() (*LimitsStats, error) {
+	stats := &LimitsStats{TCPStates: make(map[string]uint64)}
+
+	data, err := ioutil.ReadFile("/proc/sys/fs/file-nr")
+	if err != nil {
+		return nil, err
+	}
+	if stats.Files, err = parseFileNr(string(data)); err != nil {
+		return nil, err
+	}
+
+	if data, err := ioutil.ReadFile("/proc/loadavg"); err == nil {
+		stats.PIDs.Used = parseLoadavgTasks(string(data))
+	}
+	stats.PIDs.Max = readProcUint("/proc/sys/kernel/pid_max")
+	stats.PIDs.Percent = limitPercent(stats.PIDs.Used, stats.PIDs.Max)
+
+	if count, err := ioutil.ReadFile("/proc/sys/net/netfilter/nf_conntrack_count"); err == nil {
+		used, _ := strconv.ParseUint(strings.TrimSpace(string(count)), 10, 64)
+		max := readProcUint("/proc/sys/net/netfilter/nf_conntrack_max")
+		stats.Conntrack = &LimitUsage{Used: used, Max: max, Percent: limitPercent(used, max)}
+	}
+
+	stats.Entropy.Used = readProcUint("/proc/sys/kernel/random/entropy_avail")
+	stats.Entropy.Max = readProcUint("/proc/sys/kernel/random/poolsize")
+	stats.Entropy.Percent = limitPercent(stats.Entropy.Used, stats.Entropy.Max)
+
+	if file, err := os.Open("/proc/net/sockstat"); err == nil {
+		stats.Sockets = parseSockstat(file, uint64(os.Getpagesize()))
+		file.Close()
+	}
+	stats.Sockets.OrphanPercent = limitPercent(stats.Sockets.TCPOrphan, readProcUint("/proc/sys/net/ipv4/tcp_max_orphans"))
+	stats.Sockets.TimeWaitPercent = limitPercent(stats.Sockets.TCPTimeWait, readProcUint("/proc/sys/net/ipv4/tcp_max_tw_buckets"))
+	if data, err := ioutil.ReadFile("/proc/sys/net/ipv4/tcp_mem"); err == nil {
+		// tcp_mem is "min pressure max" in pages
+		if fields := strings.Fields(string(data)); len(fields) == 3 {
+			max, _ := strconv.ParseUint(fields[2], 10, 64)
+			stats.Sockets.MemoryPercent = limitPercent(stats.Sockets.TCPMemory, max*uint64(os.Getpagesize()))
+		}
+	}
+
+	for _, path := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		err = countTCPStates(file, stats.TCPStates)
+		file.Close()
+		if err != nil {
+			sm.log.Debugf("Failed to read %s: %v", path, err)
+		}
+	}
+
+	return stats, nil
+}
+
+// readProcUint reads a single unsigned value from a /proc/sys file, or 0
+func readProcUint(path string) uint64 {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	value, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return value
+}
+
+// limitPercent returns used as a percentage of max, or 0 without a limit
+func limitPercent(used, max uint64) float64 {
+	if max == 0 {
+		return 0
+	}
+	return float64(used) / float64(max) * 100
+}
+
+// parseFileNr parses /proc/sys/fs/file-nr: allocated, unused (always 0
+// since 2.6) and maximum file handles
+func parseFileNr(data string) (LimitUsage, error) {
+	fields := strings.Fields(data)
+	if len(fields) != 3 {
+		return LimitUsage{}, fmt.Errorf("unexpected file-nr format: %q", data)
+	}
+
+	values := make([]uint64, 3)
+	for i, field := range fields {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return LimitUsage{}, fmt.Errorf("unexpected file-nr format: %q", data)
+		}
+		values[i] = value
+	}
+
+	used := values[0] - values[1]
+	return LimitUsage{Used: used, Max: values[2], Percent: limitPercent(used, values[2])}, nil
+}
+
+// parseLoadavgTasks returns the number of tasks from the "running/total"
+// field of /proc/loadavg
+func parseLoadavgTasks(data string) uint64 {
+	fields := strings.Fields(data)
+	if len(fields) < 4 {
+		return 0
+	}
+	parts := strings.SplitN(fields[3], "/", 2)
+	if len(parts) != 2 {
+		return 0
+	}
+	tasks, _ := strconv.ParseUint(parts[1], 10, 64)
+	return tasks
+}
+
+// parseSockstat parses /proc/net/sockstat, whose lines hold a protocol
+// followed by name/value pairs, e.g. "TCP: inuse 8 orphan 0 tw 0 alloc 8 mem 0".
+// Memory is reported in pages.
+func parseSockstat(r io.Reader, pageSize uint64) SocketStats {
+	var stats SocketStats
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		values := make(map[string]uint64)
+		for i := 1; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseUint(fields[i+1], 10, 64)
+			if err == nil {
+				values[fields[i]] = value
+			}
+		}
+
+		switch fields[0] {
+		case "sockets:":
+			stats.Used = values["used"]
+		case "TCP:":
+			stats.TCPInUse = values["inuse"]
+			stats.TCPOrphan = values["orphan"]
+			stats.TCPTimeWait = values["tw"]
+			stats.TCPAlloc = values["alloc"]
+			stats.TCPMemory = values["mem"] * pageSize
+		case "UDP:":
+			stats.UDPInUse = values["inuse"]
+			stats.UDPMemory = values["mem"] * pageSize
+		}
+	}
+
+	return stats
+}
+
+// countTCPStates adds the connections of a /proc/net/tcp or tcp6 table to
+// the per-state counts
+func countTCPStates(r io.Reader, states map[string]uint64) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // skip the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		if name, ok := tcpStateNames[fields[3]]; ok {
+			states[name]++
+		}
+	}
+	return scanner.Err()
+}
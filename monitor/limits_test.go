@@ -0,0 +1,55 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseFileNr(t *testing.T) {
+	usage, err := parseFileNr("1024\t0\t2048\n")
+	if err != nil {
+		t.Fatalf("parseFileNr: %v", err)
+	}
+	if usage.Used != 1024 || usage.Max != 2048 || usage.Percent != 50 {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+
+	if _, err := parseFileNr("garbage"); err == nil {
+		t.Error("expected an error for malformed input")
+	}
+}
+
+func TestParseLoadavgTasks(t *testing.T) {
+	if got := parseLoadavgTasks("0.10 0.20 0.30 2/345 6789\n"); got != 345 {
+		t.Errorf("parseLoadavgTasks = %d, want 345", got)
+	}
+}
+
+func TestParseSockstat(t *testing.T) {
+	input := `sockets: used 24
+TCP: inuse 8 orphan 1 tw 3 alloc 9 mem 2
+UDP: inuse 4 mem 1
+RAW: inuse 0
+`
+	stats := parseSockstat(strings.NewReader(input), 4096)
+	want := SocketStats{Used: 24, TCPInUse: 8, TCPOrphan: 1, TCPTimeWait: 3, TCPAlloc: 9,
+		TCPMemory: 8192, UDPInUse: 4, UDPMemory: 4096}
+	if stats != want {
+		t.Errorf("parseSockstat = %+v, want %+v", stats, want)
+	}
+}
+
+func TestCountTCPStates(t *testing.T) {
+	input := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:BC8F 00000000:0000 0A 00000000:00000000 00:00000000 00000000 65534        0 914 1
+   1: 0100007F:0016 0100007F:C350 01 00000000:00000000 00:00000000 00000000     0        0 662 1
+   2: 0100007F:0016 0100007F:C351 06 00000000:00000000 00:00000000 00000000     0        0 0 1
+`
+	states := make(map[string]uint64)
+	if err := countTCPStates(strings.NewReader(input), states); err != nil {
+		t.Fatalf("countTCPStates: %v", err)
+	}
+	if states["listen"] != 1 || states["established"] != 1 || states["time_wait"] != 1 {
+		t.Errorf("unexpected states: %v", states)
+	}
+}
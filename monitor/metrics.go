@@ -0,0 +1,159 @@
+package monitor
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Metrics flattens the stats into a map of dotted metric names to values,
+// e.g. "cpu.usage_percent" or "gpio.pins.gpio17.value". Names follow the
+// JSON field names so they match what the web API reports. Strings, times
+// and slices of structs are not numeric and are left out, and so are values
+// that were not measured, such as a probe before its first check, or a file
+// metric, derived metric or flash wear attribute that failed.
+func (s *SystemStats) Metrics() map[string]float64 {
+	metrics := make(map[string]float64)
+	walkMetrics(nil, reflect.ValueOf(*s), func(path []metricPart, value float64) {
+		metrics[joinMetricPath(path)] = value
+	})
+
+	for name, probe := range s.Probes {
+		if probe.Checks == 0 {
+			deleteMetrics(metrics, metricName("probes", name))
+		}
+	}
+	for name := range s.unavailableMetrics() {
+		delete(metrics, name)
+	}
+	return metrics
+}
+
+// unavailableMetrics returns the metrics that failed to measure, mapped to
+// their errors. Their zero values would pass or trip rules falsely.
+func (s *SystemStats) unavailableMetrics() map[string]string {
+	unavailable := make(map[string]string)
+	for name, metric := range s.Files {
+		if metric.Error != "" {
+			unavailable[metricName(metricName("files", name), "value")] = metric.Error
+		}
+	}
+	for name, device := range s.Storage.Devices {
+		if device.Error != "" {
+			prefix := metricName("storage.devices", name)
+			for _, field := range []string{"life_time_a", "life_time_b", "pre_eol", "wear_percent"} {
+				unavailable[metricName(prefix, field)] = device.Error
+			}
+		}
+	}
+	for name, metric := range s.Derived {
+		if metric.Error != "" {
+			unavailable[metricName(metricName("derived", name), "value")] = metric.Error
+		}
+	}
+	return unavailable
+}
+
+// deleteMetrics removes a metric and the metrics below it
+func deleteMetrics(metrics map[string]float64, prefix string) {
+	for name := range metrics {
+		if name == prefix || strings.HasPrefix(name, prefix+".") {
+			delete(metrics, name)
+		}
+	}
+}
+
+// metricPart is one segment of a metric name: a field name, or the key or
+// index of an instance in a map or list
+type metricPart struct {
+	name     string
+	instance bool
+}
+
+// walkMetrics calls visit with the path of every numeric leaf of v. The path
+// is reused between calls.
+func walkMetrics(path []metricPart, v reflect.Value, visit func([]metricPart, float64)) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walkMetrics(path, v.Elem(), visit)
+		}
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			walkMetrics(append(path, metricPart{name: name}), v.Field(i), visit)
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		for _, key := range v.MapKeys() {
+			walkMetrics(append(path, metricPart{name: key.String(), instance: true}), v.MapIndex(key), visit)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			if !isNumericKind(elem.Kind()) {
+				return
+			}
+			walkMetrics(append(path, metricPart{name: strconv.Itoa(i), instance: true}), elem, visit)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		visit(path, float64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		visit(path, float64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		visit(path, v.Float())
+	case reflect.Bool:
+		if v.Bool() {
+			visit(path, 1)
+		} else {
+			visit(path, 0)
+		}
+	}
+}
+
+// joinMetricPath returns the dotted name of a metric path
+func joinMetricPath(path []metricPart) string {
+	names := make([]string, len(path))
+	for i, part := range path {
+		names[i] = part.name
+	}
+	return strings.Join(names, ".")
+}
+
+// metricName joins a metric prefix and a field name
+func metricName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// isNumericKind reports whether values of kind k become metrics
+func isNumericKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,336 @@
+package monitor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Route flags from the kernel's route.h and ipv6_route.h
+const (
+	rtfUp      = 0x0001
+	rtfGateway = 0x0002
+	rtfLocal   = 0x80000000
+)
+
+// NetConfigStats represents the network configuration: addresses, routes and DNS
+type NetConfigStats struct {
+	Interfaces       []InterfaceAddrs `json:"interfaces"`
+	Routes           []Route          `json:"routes"`
+	DefaultRoute     bool             `json:"default_route"` // an IPv4 or IPv6 default route exists
+	DefaultGateway   string           `json:"default_gateway"`
+	DefaultInterface string           `json:"default_interface"`
+	DNSServers       []string         `json:"dns_servers"`
+	SearchDomains    []string         `json:"search_domains"`
+}
+
+// InterfaceAddrs represents the addresses of one network interface
+type InterfaceAddrs struct {
+	Name      string   `json:"name"`
+	Up        bool     `json:"up"`
+	Addresses []string `json:"addresses"` // CIDR notation
+}
+
+// Route represents one routing table entry
+type Route struct {
+	Family      string `json:"family"`      // "inet" or "inet6"
+	Destination string `json:"destination"` // CIDR notation
+	Gateway     string `json:"gateway,omitempty"`
+	Interface   string `json:"interface"`
+	Metric      uint32 `json:"metric"`
+}
+
+// netConfigTracker keeps the previous configuration to report changes
+type netConfigTracker struct {
+	mu   sync.Mutex
+	prev *NetConfigStats
+}
+
+// getNetConfigStats collects the network configuration and raises events
+// for whatever changed since the previous sample
+func (sm *SystemMonitor) getNetConfigStats() (*NetConfigStats, error) {
+	stats := &NetConfigStats{}
+
+	interfaces, err := readInterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	stats.Interfaces = interfaces
+
+	// A malformed route line only costs that route
+	if file, err := os.Open("/proc/net/route"); err == nil {
+		routes, err := parseRoutes(file)
+		file.Close()
+		if err != nil {
+			sm.log.Warnf("Skipped IPv4 routes: %v", err)
+		}
+		stats.Routes = append(stats.Routes, routes...)
+	}
+	if file, err := os.Open("/proc/net/ipv6_route"); err == nil {
+		routes, err := parseIPv6Routes(file)
+		file.Close()
+		if err != nil {
+			sm.log.Warnf("Skipped IPv6 routes: %v", err)
+		}
+		stats.Routes = append(stats.Routes, routes...)
+	}
+	stats.setDefaultRoute()
+
+	if file, err := os.Open("/etc/resolv.conf"); err == nil {
+		stats.DNSServers, stats.SearchDomains = parseResolvConf(file)
+		file.Close()
+	}
+
+	sm.netconfig.mu.Lock()
+	prev := sm.netconfig.prev
+	sm.netconfig.prev = stats
+	sm.netconfig.mu.Unlock()
+
+	if prev != nil {
+		for _, event := range diffNetConfig(prev, stats) {
+			sm.emitEvent(event)
+		}
+	}
+
+	return stats, nil
+}
+
+// setDefaultRoute picks the default route with the lowest metric, preferring IPv4
+func (nc *NetConfigStats) setDefaultRoute() {
+	var best *Route
+	for i := range nc.Routes {
+		route := &nc.Routes[i]
+		if route.Destination != "0.0.0.0/0" && route.Destination != "::/0" {
+			continue
+		}
+		if best == nil || (route.Family == "inet" && best.Family == "inet6") ||
+			(route.Family == best.Family && route.Metric < best.Metric) {
+			best = route
+		}
+	}
+
+	if best != nil {
+		nc.DefaultRoute = true
+		nc.DefaultGateway = best.Gateway
+		nc.DefaultInterface = best.Interface
+	}
+}
+
+// readInterfaceAddrs lists the addresses of every interface but loopback
+func readInterfaceAddrs() ([]InterfaceAddrs, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []InterfaceAddrs
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		entry := InterfaceAddrs{Name: iface.Name, Up: iface.Flags&net.FlagUp != 0, Addresses: []string{}}
+		if addrs, err := iface.Addrs(); err == nil {
+			for _, addr := range addrs {
+				entry.Addresses = append(entry.Addresses, addr.String())
+			}
+		}
+		result = append(result, entry)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+// parseRoutes parses /proc/net/route. Addresses and masks are hex in host
+// byte order. Malformed lines are skipped, and returned as errors along with
+// the routes.
+func parseRoutes(r io.Reader) ([]Route, error) {
+	var routes []Route
+	var errs []error
+
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // skip the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 {
+			continue
+		}
+		dest, err1 := parseHexIPv4(fields[1])
+		gateway, err2 := parseHexIPv4(fields[2])
+		flags, err3 := strconv.ParseUint(fields[3], 16, 32)
+		metric, err4 := strconv.ParseUint(fields[6], 10, 32)
+		mask, err5 := parseHexIPv4(fields[7])
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+			errs = append(errs, fmt.Errorf("unexpected route line: %q", scanner.Text()))
+			continue
+		}
+		if flags&rtfUp == 0 {
+			continue
+		}
+
+		ones, _ := net.IPMask(mask).Size()
+		route := Route{
+			Family:      "inet",
+			Destination: fmt.Sprintf("%s/%d", dest, ones),
+			Interface:   fields[0],
+			Metric:      uint32(metric),
+		}
+		if flags&rtfGateway != 0 {
+			route.Gateway = gateway.String()
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, errors.Join(append(errs, scanner.Err())...)
+}
+
+// parseHexIPv4 decodes an IPv4 address written as hex in host byte order
+func parseHexIPv4(s string) (net.IP, error) {
+	value, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return nil, err
+	}
+	ip := make(net.IP, 4)
+	binary.NativeEndian.PutUint32(ip, uint32(value))
+	return ip, nil
+}
+
+// parseIPv6Routes parses /proc/net/ipv6_route, skipping loopback and local
+// address routes. Malformed lines are skipped, and returned as errors along
+// with the routes.
+func parseIPv6Routes(r io.Reader) ([]Route, error) {
+	var routes []Route
+	var errs []error
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		dest, err1 := hex.DecodeString(fields[0])
+		prefix, err2 := strconv.ParseUint(fields[1], 16, 8)
+		nextHop, err3 := hex.DecodeString(fields[4])
+		metric, err4 := strconv.ParseUint(fields[5], 16, 32)
+		flags, err5 := strconv.ParseUint(fields[8], 16, 32)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil ||
+			len(dest) != net.IPv6len || len(nextHop) != net.IPv6len {
+			errs = append(errs, fmt.Errorf("unexpected ipv6_route line: %q", scanner.Text()))
+			continue
+		}
+		iface := fields[9]
+		if iface == "lo" || flags&rtfUp == 0 || flags&rtfLocal != 0 {
+			continue
+		}
+
+		route := Route{
+			Family:      "inet6",
+			Destination: fmt.Sprintf("%s/%d", net.IP(dest), prefix),
+			Interface:   iface,
+			Metric:      uint32(metric),
+		}
+		if flags&rtfGateway != 0 {
+			route.Gateway = net.IP(nextHop).String()
+		}
+		routes = append(routes, route)
+	}
+
+	return routes, errors.Join(append(errs, scanner.Err())...)
+}
+
+// parseResolvConf returns the name servers and search domains of resolv.conf
+func parseResolvConf(r io.Reader) ([]string, []string) {
+	var servers, search []string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || strings.HasPrefix(fields[0], ";") {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			servers = append(servers, fields[1])
+		case "search", "domain":
+			search = append(search, fields[1:]...)
+		}
+	}
+
+	return servers, search
+}
+
+// diffNetConfig describes what changed between two samples as events. Losing
+// the default route is a warning since the device is then likely unreachable.
+func diffNetConfig(prev, cur *NetConfigStats) []Event {
+	var events []Event
+	event := func(name string, severity HealthLevel, format string, args ...interface{}) {
+		events = append(events, Event{
+			Source:   "netconfig",
+			Name:     name,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	switch {
+	case prev.DefaultRoute && !cur.DefaultRoute:
+		event("default_route_lost", HealthWarning, "Default route via %s on %s disappeared",
+			prev.DefaultGateway, prev.DefaultInterface)
+	case !prev.DefaultRoute && cur.DefaultRoute:
+		event("default_route_added", HealthOK, "Default route via %s on %s appeared",
+			cur.DefaultGateway, cur.DefaultInterface)
+	case prev.DefaultGateway != cur.DefaultGateway || prev.DefaultInterface != cur.DefaultInterface:
+		event("default_route_changed", HealthOK, "Default route changed from %s on %s to %s on %s",
+			prev.DefaultGateway, prev.DefaultInterface, cur.DefaultGateway, cur.DefaultInterface)
+	}
+
+	prevAddrs := interfaceAddrSet(prev.Interfaces)
+	curAddrs := interfaceAddrSet(cur.Interfaces)
+	for _, key := range sortedKeys(curAddrs) {
+		if !prevAddrs[key] {
+			event("address_added", HealthOK, "Address %s added", key)
+		}
+	}
+	for _, key := range sortedKeys(prevAddrs) {
+		if !curAddrs[key] {
+			event("address_removed", HealthOK, "Address %s removed", key)
+		}
+	}
+
+	if strings.Join(prev.DNSServers, " ") != strings.Join(cur.DNSServers, " ") {
+		event("dns_changed", HealthOK, "DNS servers changed from [%s] to [%s]",
+			strings.Join(prev.DNSServers, " "), strings.Join(cur.DNSServers, " "))
+	}
+
+	return events
+}
+
+// interfaceAddrSet returns the "address on interface" pairs of the interfaces
+func interfaceAddrSet(interfaces []InterfaceAddrs) map[string]bool {
+	set := make(map[string]bool)
+	for _, iface := range interfaces {
+		for _, addr := range iface.Addresses {
+			set[addr+" on "+iface.Name] = true
+		}
+	}
+	return set
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,105 @@
+package monitor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// hexIPv4 writes an address the way /proc/net/route does, in host byte order
+func hexIPv4(addr string) string {
+	return fmt.Sprintf("%08X", binary.NativeEndian.Uint32(net.ParseIP(addr).To4()))
+}
+
+func TestParseRoutes(t *testing.T) {
+	input := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		fmt.Sprintf("eth0\t%s\t%s\t0003\t0\t0\t100\t%s\t0\t0\t0\n", hexIPv4("0.0.0.0"), hexIPv4("192.0.2.1"), hexIPv4("0.0.0.0")) +
+		"eth0\tzzzzzzzz\t00000000\t0001\t0\t0\t0\t00000000\t0\t0\t0\n" +
+		fmt.Sprintf("eth0\t%s\t00000000\t0001\t0\t0\t0\t%s\t0\t0\t0\n", hexIPv4("192.0.2.0"), hexIPv4("255.255.255.0")) +
+		fmt.Sprintf("wlan0\t%s\t00000000\t0000\t0\t0\t0\t%s\t0\t0\t0\n", hexIPv4("192.168.0.0"), hexIPv4("255.255.255.0"))
+
+	// The malformed line is reported and skipped, the other routes are kept
+	routes, err := parseRoutes(strings.NewReader(input))
+	if err == nil || !strings.Contains(err.Error(), "zzzzzzzz") {
+		t.Errorf("expected an error for the malformed line, got %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("expected 2 routes that are up, got %+v", routes)
+	}
+
+	want := Route{Family: "inet", Destination: "0.0.0.0/0", Gateway: "192.0.2.1", Interface: "eth0", Metric: 100}
+	if routes[0] != want {
+		t.Errorf("default route = %+v, want %+v", routes[0], want)
+	}
+	if routes[1].Destination != "192.0.2.0/24" || routes[1].Gateway != "" {
+		t.Errorf("unexpected link route: %+v", routes[1])
+	}
+}
+
+func TestParseIPv6Routes(t *testing.T) {
+	input := `fd000000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fd000000000000000000000000000001 00000400 00000001 00000000 00000003     eth0
+00000000000000000000000000000001 80 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000002 00000000 80200001       lo
+fd000000000000000000000000000002 80 00000000000000000000000000000000 00 00000000000000000000000000000000 00000000 00000002 00000000 80200001     eth0
+`
+	routes, err := parseIPv6Routes(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseIPv6Routes: %v", err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("expected local and loopback routes to be skipped, got %+v", routes)
+	}
+	if routes[0].Destination != "fd00::/64" || routes[0].Metric != 256 {
+		t.Errorf("unexpected prefix route: %+v", routes[0])
+	}
+	if routes[1].Destination != "::/0" || routes[1].Gateway != "fd00::1" {
+		t.Errorf("unexpected default route: %+v", routes[1])
+	}
+}
+
+func TestParseResolvConf(t *testing.T) {
+	input := `# generated
+nameserver 192.0.2.53
+nameserver 2001:db8::53
+search example.com lab.example.com
+options edns0
+`
+	servers, search := parseResolvConf(strings.NewReader(input))
+	if strings.Join(servers, " ") != "192.0.2.53 2001:db8::53" {
+		t.Errorf("servers = %v", servers)
+	}
+	if strings.Join(search, " ") != "example.com lab.example.com" {
+		t.Errorf("search = %v", search)
+	}
+}
+
+func TestDiffNetConfig(t *testing.T) {
+	prev := &NetConfigStats{
+		Routes:     []Route{{Family: "inet", Destination: "0.0.0.0/0", Gateway: "192.0.2.1", Interface: "eth0"}},
+		Interfaces: []InterfaceAddrs{{Name: "eth0", Up: true, Addresses: []string{"192.0.2.10/24"}}},
+		DNSServers: []string{"192.0.2.53"},
+	}
+	prev.setDefaultRoute()
+	cur := &NetConfigStats{
+		Interfaces: []InterfaceAddrs{{Name: "eth0", Up: true, Addresses: []string{}}},
+		DNSServers: []string{"192.0.2.53"},
+	}
+	cur.setDefaultRoute()
+
+	events := diffNetConfig(prev, cur)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", events)
+	}
+	if events[0].Name != "default_route_lost" || events[0].Severity != HealthWarning {
+		t.Errorf("unexpected first event: %+v", events[0])
+	}
+	if events[1].Name != "address_removed" {
+		t.Errorf("unexpected second event: %+v", events[1])
+	}
+
+	if events := diffNetConfig(prev, prev); len(events) != 0 {
+		t.Errorf("expected no events for an unchanged config, got %+v", events)
+	}
+}
@@ -0,0 +1,440 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPluginOutput limits how much of a plugin's output is parsed
+const maxPluginOutput = 1 << 20
+
+// PluginStatus represents the state of the last run of an exec plugin
+type PluginStatus struct {
+	OK         bool      `json:"ok"`
+	Metrics    int       `json:"metrics"` // number of metrics reported
+	DurationMs float64   `json:"duration_ms"`
+	LastRun    time.Time `json:"last_run"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// plugin runs one configured command and keeps the metrics it reported
+type plugin struct {
+	cfg PluginConfig
+
+	mu      sync.Mutex
+	status  PluginStatus
+	metrics map[string]float64
+}
+
+// newPlugins creates the configured plugins, skipping invalid ones
+func newPlugins(cfgs []PluginConfig, sm *SystemMonitor) []*plugin {
+	var plugins []*plugin
+	for _, cfg := range cfgs {
+		switch cfg.Format {
+		case "prometheus", "json", "influx":
+		default:
+			sm.log.Errorf("Invalid plugin %s: unknown format %q", cfg.Name, cfg.Format)
+			continue
+		}
+		if cfg.Name == "" || cfg.Command == "" {
+			sm.log.Errorf("Invalid plugin %q: name and command are required", cfg.Name)
+			continue
+		}
+		if cfg.Interval <= 0 {
+			cfg.Interval = time.Minute
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = 10 * time.Second
+		}
+		plugins = append(plugins, &plugin{cfg: cfg})
+	}
+	return plugins
+}
+
+// runPlugin runs the command every interval until stop is closed
+func (sm *SystemMonitor) runPlugin(p *plugin, stop <-chan struct{}) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		start := time.Now()
+		metrics, err := p.run()
+
+		p.mu.Lock()
+		if err != nil && (p.status.OK || p.status.LastRun.IsZero()) {
+			sm.log.Warnf("Plugin %s failed: %v", p.cfg.Name, err)
+		}
+		p.metrics = metrics
+		p.status = PluginStatus{
+			OK:         err == nil,
+			Metrics:    len(metrics),
+			DurationMs: float64(time.Since(start)) / float64(time.Millisecond),
+			LastRun:    start,
+		}
+		if err != nil {
+			p.status.Error = err.Error()
+		}
+		p.mu.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// run executes the command and parses its output. Metrics parsed before a
+// malformed line are kept along with the error.
+func (p *plugin) run() (map[string]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.cfg.Command, p.cfg.Args...)
+	cmd.Stdout = &limitedBuffer{buf: &stdout, limit: maxPluginOutput}
+	cmd.Stderr = &limitedBuffer{buf: &stderr, limit: 4096}
+	// Children that inherited the output pipes must not keep us waiting
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %v", p.cfg.Timeout)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	return parsePluginOutput(p.cfg.Format, &stdout)
+}
+
+// limitedBuffer keeps the first limit bytes written and discards the rest,
+// so a runaway plugin cannot exhaust memory
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+// Write stores what fits within the limit and reports everything as written
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	if room := lb.limit - lb.buf.Len(); room > 0 {
+		if len(p) > room {
+			lb.buf.Write(p[:room])
+		} else {
+			lb.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// snapshot returns a copy of the plugin status and metrics
+func (p *plugin) snapshot() (PluginStatus, map[string]float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	metrics := make(map[string]float64, len(p.metrics))
+	for name, value := range p.metrics {
+		metrics[name] = value
+	}
+	return p.status, metrics
+}
+
+// getPluginStats returns the plugin metrics and states by plugin name
+func (sm *SystemMonitor) getPluginStats() (map[string]map[string]float64, map[string]PluginStatus) {
+	if len(sm.plugins) == 0 {
+		return nil, nil
+	}
+
+	custom := make(map[string]map[string]float64, len(sm.plugins))
+	states := make(map[string]PluginStatus, len(sm.plugins))
+	for _, p := range sm.plugins {
+		states[p.cfg.Name], custom[p.cfg.Name] = p.snapshot()
+	}
+	return custom, states
+}
+
+// parsePluginOutput parses plugin output in the given format. NaN and
+// infinite values cannot be encoded as JSON and are dropped.
+func parsePluginOutput(format string, r io.Reader) (map[string]float64, error) {
+	var metrics map[string]float64
+	var err error
+	switch format {
+	case "prometheus":
+		metrics, err = parsePrometheusText(r)
+	case "json":
+		metrics, err = parseJSONMetrics(r)
+	case "influx":
+		metrics, err = parseInfluxLines(r)
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	for name, value := range metrics {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			delete(metrics, name)
+		}
+	}
+	return metrics, err
+}
+
+// parsePrometheusText parses the Prometheus text exposition format. Label
+// values are appended to the metric name in label name order, so
+// queue_depth{queue="orders"} becomes "queue_depth.orders".
+func parsePrometheusText(r io.Reader) (map[string]float64, error) {
+	metrics := make(map[string]float64)
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name := line
+		var labels map[string]string
+		rest := ""
+		if brace := strings.Index(line, "{"); brace >= 0 {
+			end := strings.LastIndex(line, "}")
+			if end < brace {
+				return metrics, fmt.Errorf("line %d: unterminated labels", lineNo)
+			}
+			var err error
+			if labels, err = parsePrometheusLabels(line[brace+1 : end]); err != nil {
+				return metrics, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			name = line[:brace]
+			rest = line[end+1:]
+		} else if fields := strings.Fields(line); len(fields) >= 2 {
+			name = fields[0]
+			rest = strings.Join(fields[1:], " ")
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return metrics, fmt.Errorf("line %d: missing value", lineNo)
+		}
+		value, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return metrics, fmt.Errorf("line %d: invalid value %q", lineNo, fields[0])
+		}
+
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		parts := []string{strings.TrimSpace(name)}
+		for _, key := range keys {
+			parts = append(parts, labels[key])
+		}
+		metrics[customMetricName(parts...)] = value
+	}
+
+	return metrics, scanner.Err()
+}
+
+// parsePrometheusLabels parses name="value" pairs, where values may contain
+// escaped quotes, backslashes and newlines
+func parsePrometheusLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if s == "" {
+			return labels, nil
+		}
+		eq := strings.Index(s, "=")
+		if eq < 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return nil, fmt.Errorf("invalid labels %q", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+
+		var value strings.Builder
+		i := eq + 2
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				i++
+				if s[i] == 'n' {
+					value.WriteByte('\n')
+					continue
+				}
+			}
+			value.WriteByte(s[i])
+		}
+		if i >= len(s) {
+			return nil, fmt.Errorf("unterminated label value in %q", s)
+		}
+		labels[name] = value.String()
+		s = s[i+1:]
+	}
+}
+
+// parseJSONMetrics parses a JSON object, using the dotted path of every
+// numeric or boolean value as its name
+func parseJSONMetrics(r io.Reader) (map[string]float64, error) {
+	var data interface{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, err
+	}
+	if _, ok := data.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("expected a JSON object")
+	}
+
+	metrics := make(map[string]float64)
+	flattenJSONMetrics("", data, metrics)
+	return metrics, nil
+}
+
+// flattenJSONMetrics walks decoded JSON and stores every numeric leaf
+func flattenJSONMetrics(prefix string, v interface{}, out map[string]float64) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			flattenJSONMetrics(metricName(prefix, customMetricName(key)), value, out)
+		}
+	case []interface{}:
+		for i, value := range v {
+			flattenJSONMetrics(metricName(prefix, strconv.Itoa(i)), value, out)
+		}
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			out[prefix] = f
+		}
+	case bool:
+		if v {
+			out[prefix] = 1
+		} else {
+			out[prefix] = 0
+		}
+	}
+}
+
+// parseInfluxLines parses InfluxDB line protocol. Each field becomes a
+// metric named after the measurement, the tag values in tag order and the
+// field, so "modem,sim=1 rssi=-71i" becomes "modem.1.rssi". String fields
+// are skipped.
+func parseInfluxLines(r io.Reader) (map[string]float64, error) {
+	metrics := make(map[string]float64)
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sections := splitInfluxEscaped(line, ' ')
+		if len(sections) < 2 {
+			return metrics, fmt.Errorf("line %d: missing fields", lineNo)
+		}
+
+		key := splitInfluxEscaped(sections[0], ',')
+		parts := []string{unescapeInflux(key[0])}
+		for _, tag := range key[1:] {
+			kv := splitInfluxEscaped(tag, '=')
+			if len(kv) != 2 {
+				return metrics, fmt.Errorf("line %d: invalid tag %q", lineNo, tag)
+			}
+			parts = append(parts, unescapeInflux(kv[1]))
+		}
+
+		for _, field := range splitInfluxEscaped(sections[1], ',') {
+			kv := splitInfluxEscaped(field, '=')
+			if len(kv) != 2 {
+				return metrics, fmt.Errorf("line %d: invalid field %q", lineNo, field)
+			}
+			value, ok := parseInfluxValue(kv[1])
+			if !ok {
+				continue
+			}
+			metrics[customMetricName(append(parts, unescapeInflux(kv[0]))...)] = value
+		}
+	}
+
+	return metrics, scanner.Err()
+}
+
+// parseInfluxValue parses a numeric or boolean field value. Integers carry
+// an "i" or "u" suffix; strings are quoted and have no numeric value.
+func parseInfluxValue(s string) (float64, bool) {
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true
+	}
+	if strings.HasPrefix(s, `"`) {
+		return 0, false
+	}
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "i"), "u")
+	value, err := strconv.ParseFloat(s, 64)
+	return value, err == nil
+}
+
+// splitInfluxEscaped splits s at sep, except where sep is escaped with a
+// backslash or inside a double-quoted string
+func splitInfluxEscaped(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescapeInflux removes the backslashes escaping commas, spaces and equal signs
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// customMetricName joins name parts with dots, replacing the characters
+// that would make the metric awkward to reference in rules
+func customMetricName(parts ...string) string {
+	cleaned := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.Map(func(r rune) rune {
+			switch r {
+			case ' ', '\t', '*', '/':
+				return '_'
+			}
+			return r
+		}, part)
+		if part != "" {
+			cleaned = append(cleaned, part)
+		}
+	}
+	return strings.Join(cleaned, ".")
+}
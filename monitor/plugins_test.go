@@ -0,0 +1,95 @@
+package monitor
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePrometheusText(t *testing.T) {
+	input := `# HELP queue_depth Messages waiting
+# TYPE queue_depth gauge
+queue_depth{queue="orders",host="a"} 5
+queue_depth{queue="say \"hi\""} 2
+modem_rssi -71 1690000000000
+temperature NaN
+`
+	metrics, err := parsePluginOutput("prometheus", strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parsePrometheusText: %v", err)
+	}
+	want := map[string]float64{
+		"queue_depth.a.orders": 5,
+		`queue_depth.say_"hi"`: 2,
+		"modem_rssi":           -71,
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Errorf("metrics = %v, want %v", metrics, want)
+	}
+
+	metrics, err = parsePrometheusText(strings.NewReader("ok 1\nbroken{x=1} 2\n"))
+	if err == nil || metrics["ok"] != 1 {
+		t.Errorf("expected the first metric and an error, got %v, %v", metrics, err)
+	}
+}
+
+func TestParseJSONMetrics(t *testing.T) {
+	input := `{"modem": {"rssi": -71, "registered": true, "operator": "acme"}, "queues": [3, 4]}`
+	metrics, err := parseJSONMetrics(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseJSONMetrics: %v", err)
+	}
+	want := map[string]float64{
+		"modem.rssi":       -71,
+		"modem.registered": 1,
+		"queues.0":         3,
+		"queues.1":         4,
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Errorf("metrics = %v, want %v", metrics, want)
+	}
+
+	if _, err := parseJSONMetrics(strings.NewReader(`[1, 2]`)); err == nil {
+		t.Error("expected an error for a JSON array")
+	}
+}
+
+func TestParseInfluxLines(t *testing.T) {
+	input := `modem,sim=1 rssi=-71i,registered=t,operator="acme, inc" 1690000000000000000
+plc\ status,line=a\,b running=true,cycle=12.5
+`
+	metrics, err := parseInfluxLines(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseInfluxLines: %v", err)
+	}
+	want := map[string]float64{
+		"modem.1.rssi":           -71,
+		"modem.1.registered":     1,
+		"plc_status.a,b.running": 1,
+		"plc_status.a,b.cycle":   12.5,
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Errorf("metrics = %v, want %v", metrics, want)
+	}
+}
+
+func TestPluginRun(t *testing.T) {
+	p := &plugin{cfg: PluginConfig{Name: "test", Command: "sh", Args: []string{"-c", `echo '{"depth": 3}'`},
+		Format: "json", Timeout: 5 * time.Second}}
+	metrics, err := p.run()
+	if err != nil || metrics["depth"] != 3 {
+		t.Errorf("run = %v, %v", metrics, err)
+	}
+
+	p.cfg.Args = []string{"-c", "echo oops >&2; exit 3"}
+	if _, err := p.run(); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("expected the failure to carry stderr, got %v", err)
+	}
+
+	p.cfg.Args = []string{"-c", "sleep 5"}
+	p.cfg.Timeout = 50 * time.Millisecond
+	if _, err := p.run(); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
@@ -0,0 +1,302 @@
+package monitor
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// maxProbeBody limits how much of an HTTP response is matched against the regex
+const maxProbeBody = 1 << 20
+
+// ProbeResult represents the outcome of the recent checks of one probe. Until
+// the first check has finished, Checks is 0 and the probe is neither up nor
+// down; it is left out of the metrics.
+type ProbeResult struct {
+	Type         string    `json:"type"`
+	Target       string    `json:"target"`
+	Up           bool      `json:"up"`            // the last check succeeded
+	LatencyMs    float64   `json:"latency_ms"`    // of the last check
+	SuccessRatio float64   `json:"success_ratio"` // of the checks within the window, 0 to 1
+	Checks       uint64    `json:"checks"`
+	Failures     uint64    `json:"failures"`
+	LastCheck    time.Time `json:"last_check"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// probe runs one configured check and keeps its recent outcomes
+type probe struct {
+	cfg    ProbeConfig
+	expect *regexp.Regexp
+
+	mu      sync.Mutex
+	result  ProbeResult
+	history []bool // ring of recent outcomes
+	next    int
+	full    bool
+}
+
+// newProbes creates the configured probes, skipping invalid ones
+func newProbes(cfgs []ProbeConfig, sm *SystemMonitor) []*probe {
+	var probes []*probe
+	for _, cfg := range cfgs {
+		switch cfg.Type {
+		case "tcp", "http", "dns", "icmp":
+		default:
+			sm.log.Errorf("Invalid probe %s: unknown type %q", cfg.Name, cfg.Type)
+			continue
+		}
+		if cfg.Name == "" || cfg.Target == "" {
+			sm.log.Errorf("Invalid probe %q: name and target are required", cfg.Name)
+			continue
+		}
+		if cfg.Interval <= 0 {
+			cfg.Interval = 30 * time.Second
+		}
+		if cfg.Timeout <= 0 {
+			cfg.Timeout = 5 * time.Second
+		}
+		if cfg.Window < 1 {
+			cfg.Window = 20
+		}
+
+		p := &probe{
+			cfg:     cfg,
+			history: make([]bool, cfg.Window),
+			result:  ProbeResult{Type: cfg.Type, Target: cfg.Target},
+		}
+		if cfg.Expect != "" {
+			re, err := regexp.Compile(cfg.Expect)
+			if err != nil {
+				sm.log.Errorf("Invalid probe %s: %v", cfg.Name, err)
+				continue
+			}
+			p.expect = re
+		}
+		probes = append(probes, p)
+	}
+	return probes
+}
+
+// runProbe checks the target every interval until stop is closed
+func (sm *SystemMonitor) runProbe(p *probe, stop <-chan struct{}) {
+	ticker := time.NewTicker(p.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), p.cfg.Timeout)
+		start := time.Now()
+		err := p.check(ctx)
+		cancel()
+
+		if event, changed := p.record(err, time.Since(start), start); changed {
+			sm.emitEvent(event)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check runs the probe once
+func (p *probe) check(ctx context.Context) error {
+	switch p.cfg.Type {
+	case "tcp":
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", p.cfg.Target)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "http":
+		return p.checkHTTP(ctx)
+	case "dns":
+		return p.checkDNS(ctx)
+	case "icmp":
+		return pingICMP(ctx, p.cfg.Target)
+	}
+	return fmt.Errorf("unknown probe type %q", p.cfg.Type)
+}
+
+// checkHTTP requests the target URL and checks the status and body
+func (p *probe) checkHTTP(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if p.cfg.ExpectStatus != 0 && resp.StatusCode != p.cfg.ExpectStatus {
+		return fmt.Errorf("status %d, expected %d", resp.StatusCode, p.cfg.ExpectStatus)
+	}
+	if p.cfg.ExpectStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode >= 400) {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	if p.expect != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxProbeBody))
+		if err != nil {
+			return err
+		}
+		if !p.expect.Match(body) {
+			return fmt.Errorf("body does not match %q", p.cfg.Expect)
+		}
+	}
+	return nil
+}
+
+// checkDNS resolves the target, through the configured server if any
+func (p *probe) checkDNS(ctx context.Context) error {
+	resolver := net.DefaultResolver
+	if p.cfg.Server != "" {
+		server := p.cfg.Server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	addrs, err := resolver.LookupHost(ctx, p.cfg.Target)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no addresses for %s", p.cfg.Target)
+	}
+
+	if p.expect != nil {
+		for _, addr := range addrs {
+			if p.expect.MatchString(addr) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no address of %v matches %q", addrs, p.cfg.Expect)
+	}
+	return nil
+}
+
+// record stores the outcome of a check and returns an event when the probe
+// went up or down. A probe that fails its first check counts as going down.
+func (p *probe) record(err error, latency time.Duration, at time.Time) (Event, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	wasUp := p.result.Up
+	first := p.result.Checks == 0
+
+	p.result.Checks++
+	p.result.LastCheck = at
+	p.result.LatencyMs = float64(latency) / float64(time.Millisecond)
+	p.result.Up = err == nil
+	p.result.LastError = ""
+	if err != nil {
+		p.result.Failures++
+		p.result.LastError = err.Error()
+	}
+
+	p.history[p.next] = err == nil
+	p.next = (p.next + 1) % len(p.history)
+	if p.next == 0 {
+		p.full = true
+	}
+	checks := p.next
+	if p.full {
+		checks = len(p.history)
+	}
+	successes := 0
+	for _, ok := range p.history[:checks] {
+		if ok {
+			successes++
+		}
+	}
+	p.result.SuccessRatio = float64(successes) / float64(checks)
+
+	switch {
+	case err != nil && (first || wasUp):
+		return Event{
+			Source:   "probe",
+			Name:     p.cfg.Name,
+			Severity: HealthWarning,
+			Message:  fmt.Sprintf("Probe %s (%s %s) is down: %v", p.cfg.Name, p.cfg.Type, p.cfg.Target, err),
+		}, true
+	case err == nil && !first && !wasUp:
+		return Event{
+			Source:   "probe",
+			Name:     p.cfg.Name,
+			Severity: HealthOK,
+			Message:  fmt.Sprintf("Probe %s (%s %s) is up again", p.cfg.Name, p.cfg.Type, p.cfg.Target),
+		}, true
+	}
+	return Event{}, false
+}
+
+// snapshot returns a copy of the probe result
+func (p *probe) snapshot() ProbeResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.result
+}
+
+// getProbeStats returns the results of the configured probes by name
+func (sm *SystemMonitor) getProbeStats() map[string]ProbeResult {
+	if len(sm.probes) == 0 {
+		return nil
+	}
+	results := make(map[string]ProbeResult, len(sm.probes))
+	for _, p := range sm.probes {
+		results[p.cfg.Name] = p.snapshot()
+	}
+	return results
+}
+
+// icmpEchoRequest builds an ICMP echo request. The checksum is filled in
+// although ping sockets compute it themselves.
+func icmpEchoRequest(id, seq uint16, payload []byte) []byte {
+	msg := make([]byte, 8+len(payload))
+	msg[0] = 8 // echo request
+	binary.BigEndian.PutUint16(msg[4:], id)
+	binary.BigEndian.PutUint16(msg[6:], seq)
+	copy(msg[8:], payload)
+	binary.BigEndian.PutUint16(msg[2:], icmpChecksum(msg))
+	return msg
+}
+
+// isICMPEchoReply reports whether msg is the echo reply to sequence seq
+func isICMPEchoReply(msg []byte, seq uint16) bool {
+	return len(msg) >= 8 && msg[0] == 0 && msg[1] == 0 && binary.BigEndian.Uint16(msg[6:]) == seq
+}
+
+// icmpChecksum computes the Internet checksum of RFC 1071
+func icmpChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
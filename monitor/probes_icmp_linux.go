@@ -0,0 +1,68 @@
+//go:build linux
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+// icmpSeq numbers the echo requests of all ICMP probes
+var icmpSeq uint32
+
+// pingICMP sends one echo request to host over an unprivileged ICMP socket
+// and waits for the reply. The kernel only allows these sockets for groups
+// within net.ipv4.ping_group_range. Only IPv4 is supported.
+func pingICMP(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	var ip net.IP
+	for _, addr := range addrs {
+		if ip4 := addr.IP.To4(); ip4 != nil {
+			ip = ip4
+			break
+		}
+	}
+	if ip == nil {
+		return fmt.Errorf("no IPv4 address for %s", host)
+	}
+
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_ICMP)
+	if err != nil {
+		return fmt.Errorf("unprivileged ICMP not allowed (check net.ipv4.ping_group_range): %w", err)
+	}
+	file := os.NewFile(uintptr(fd), "icmp")
+	conn, err := net.FilePacketConn(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	seq := uint16(atomic.AddUint32(&icmpSeq, 1))
+	// The kernel replaces the identifier with the socket's own
+	if _, err := conn.WriteTo(icmpEchoRequest(0, seq, []byte("emmon")), &net.UDPAddr{IP: ip}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		if isICMPEchoReply(buf[:n], seq) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package monitor
+
+import (
+	"context"
+	"errors"
+)
+
+// pingICMP is only implemented with Linux ping sockets
+func pingICMP(ctx context.Context, host string) error {
+	return errors.New("ICMP probes are only supported on Linux")
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestProbeRecord(t *testing.T) {
+	p := &probe{cfg: ProbeConfig{Name: "gw", Type: "tcp", Target: "192.0.2.1:22"}, history: make([]bool, 4)}
+	now := time.Now()
+
+	if _, changed := p.record(nil, 10*time.Millisecond, now); changed {
+		t.Error("a first successful check should not raise an event")
+	}
+	event, changed := p.record(errors.New("connection refused"), 0, now)
+	if !changed || event.Severity != HealthWarning {
+		t.Errorf("expected a down event, got %+v", event)
+	}
+	if _, changed := p.record(errors.New("connection refused"), 0, now); changed {
+		t.Error("a probe that stays down should not raise another event")
+	}
+	event, changed = p.record(nil, 5*time.Millisecond, now)
+	if !changed || event.Severity != HealthOK {
+		t.Errorf("expected an up event, got %+v", event)
+	}
+	p.record(nil, 5*time.Millisecond, now)
+
+	// The window holds the last four checks: fail, fail, ok, ok
+	result := p.snapshot()
+	if result.SuccessRatio != 0.5 || result.Checks != 5 || result.Failures != 2 || !result.Up {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.LatencyMs != 5 {
+		t.Errorf("latency = %v, want 5", result.LatencyMs)
+	}
+}
+
+func TestProbeChecks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"ready"}`))
+	}))
+	defer server.Close()
+
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	sm := NewSystemMonitor(log, DefaultConfig())
+	probes := newProbes([]ProbeConfig{
+		{Name: "http_ok", Type: "http", Target: server.URL, Expect: `"ready"`},
+		{Name: "http_status", Type: "http", Target: server.URL, ExpectStatus: 204},
+		{Name: "http_body", Type: "http", Target: server.URL, Expect: `"failed"`},
+		{Name: "tcp_ok", Type: "tcp", Target: server.Listener.Addr().String()},
+		{Name: "invalid", Type: "smtp", Target: "localhost:25"},
+	}, sm)
+	if len(probes) != 4 {
+		t.Fatalf("expected the invalid probe to be skipped, got %d probes", len(probes))
+	}
+
+	want := map[string]bool{"http_ok": true, "http_status": false, "http_body": false, "tcp_ok": true}
+	for _, p := range probes {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := p.check(ctx)
+		cancel()
+		if (err == nil) != want[p.cfg.Name] {
+			t.Errorf("probe %s: err = %v", p.cfg.Name, err)
+		}
+	}
+
+	// A closed port fails the TCP probe
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	closed := &probe{cfg: ProbeConfig{Type: "tcp", Target: addr}}
+	if err := closed.check(context.Background()); err == nil {
+		t.Error("expected the TCP probe to fail on a closed port")
+	}
+}
+
+func TestICMPEcho(t *testing.T) {
+	msg := icmpEchoRequest(1, 7, []byte("emmon"))
+	if icmpChecksum(msg) != 0 {
+		t.Error("checksum of a complete message should verify to 0")
+	}
+
+	reply := append([]byte(nil), msg...)
+	reply[0] = 0
+	if !isICMPEchoReply(reply, 7) || isICMPEchoReply(reply, 8) || isICMPEchoReply(msg, 7) {
+		t.Error("isICMPEchoReply did not match only the reply to sequence 7")
+	}
+}
+
+func TestPendingProbeMetrics(t *testing.T) {
+	p := &probe{cfg: ProbeConfig{Name: "gw", Type: "tcp", Target: "192.0.2.1:22"}, history: make([]bool, 4)}
+	p.result = ProbeResult{Type: "tcp", Target: "192.0.2.1:22"}
+	critical := 0.5
+	rules := []HealthRule{{Metric: "probes.*.success_ratio", Critical: &critical, Below: true}}
+
+	// Before the first check the probe is neither up nor down
+	stats := &SystemStats{Probes: map[string]ProbeResult{"gw": p.snapshot()}}
+	if _, ok := stats.Metrics()["probes.gw.up"]; ok {
+		t.Error("expected no metrics for a probe before its first check")
+	}
+	if health := evaluateHealth(stats, rules); health.Level != HealthOK {
+		t.Errorf("health = %+v, want ok before the first check", health)
+	}
+
+	p.record(errors.New("connection refused"), 0, time.Now())
+	stats = &SystemStats{Probes: map[string]ProbeResult{"gw": p.snapshot()}}
+	if up, ok := stats.Metrics()["probes.gw.up"]; !ok || up != 0 {
+		t.Errorf("probes.gw.up = %v, %v, want 0 after a failed check", up, ok)
+	}
+	if health := evaluateHealth(stats, rules); health.Level != HealthCritical {
+		t.Errorf("health = %+v, want critical after a failed check", health)
+	}
+}
@@ -0,0 +1,345 @@
+package monitor
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A recording is a gzip stream of JSON lines: a header, then the samples,
+// events and kernel messages in the order they were seen. The stream is
+// flushed after every sample, so a recording cut short by a crash or power
+// loss can still be replayed up to its last complete line.
+const (
+	recordingFormat  = "emmon-recording"
+	recordingVersion = 1
+)
+
+// recordingHeader is the first line of a recording
+type recordingHeader struct {
+	Format    string        `json:"format"`
+	Version   int           `json:"version"`
+	Started   time.Time     `json:"started"`
+	Interval  time.Duration `json:"interval"`
+	Inventory *Inventory    `json:"inventory,omitempty"`
+}
+
+// recordingEntry is one line after the header. Exactly one field is set.
+type recordingEntry struct {
+	Stats  *SystemStats   `json:"stats,omitempty"`
+	Event  *Event         `json:"event,omitempty"`
+	Kernel *KernelMessage `json:"kernel,omitempty"`
+}
+
+// Recorder writes the stats, events and kernel messages of a monitor to a
+// recording that can be replayed with SystemMonitor.Replay
+type Recorder struct {
+	sm      *SystemMonitor
+	file    *os.File
+	gz      *gzip.Writer
+	enc     *json.Encoder
+	events  uint64 // events recorded so far
+	kmsgs   uint64 // kernel messages recorded so far
+	samples int
+}
+
+// NewRecorder creates the recording file and writes its header, including
+// the device inventory
+func NewRecorder(path string, sm *SystemMonitor, interval time.Duration) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewWriterLevel(file, gzip.BestCompression)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r := &Recorder{sm: sm, file: file, gz: gz, enc: json.NewEncoder(gz)}
+
+	header := recordingHeader{
+		Format:    recordingFormat,
+		Version:   recordingVersion,
+		Started:   time.Now(),
+		Interval:  interval,
+		Inventory: sm.GetInventory(),
+	}
+	if err := r.enc.Encode(header); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Record writes the events and kernel messages seen since the last sample,
+// then the sample, and flushes them to the file
+func (r *Recorder) Record(stats *SystemStats) error {
+	var events []Event
+	events, r.events = r.sm.events.since(r.events)
+	for i := range events {
+		if err := r.enc.Encode(recordingEntry{Event: &events[i]}); err != nil {
+			return err
+		}
+	}
+
+	if r.sm.kmsg != nil {
+		var messages []KernelMessage
+		messages, r.kmsgs = r.sm.kmsg.since(r.kmsgs)
+		for i := range messages {
+			if err := r.enc.Encode(recordingEntry{Kernel: &messages[i]}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := r.enc.Encode(recordingEntry{Stats: stats}); err != nil {
+		return err
+	}
+	r.samples++
+	return r.gz.Flush()
+}
+
+// Samples returns the number of samples recorded
+func (r *Recorder) Samples() int {
+	return r.samples
+}
+
+// Close ends the gzip stream and closes the file
+func (r *Recorder) Close() error {
+	err := r.gz.Close()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// recordingReader reads a recording line by line
+type recordingReader struct {
+	file   *os.File
+	dec    *json.Decoder
+	header recordingHeader
+}
+
+// openRecording opens a recording and reads its header
+func openRecording(path string) (*recordingReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a recording: %v", path, err)
+	}
+	r := &recordingReader{file: file, dec: json.NewDecoder(gz)}
+	if err := r.dec.Decode(&r.header); err != nil || r.header.Format != recordingFormat {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a recording", path)
+	}
+	if r.header.Version > recordingVersion {
+		file.Close()
+		return nil, fmt.Errorf("%s is a version %d recording, this emmon reads up to version %d",
+			path, r.header.Version, recordingVersion)
+	}
+	return r, nil
+}
+
+// next reads the next entry. It returns io.EOF at the end of the recording,
+// and io.ErrUnexpectedEOF if the recording ends in a partial line.
+func (r *recordingReader) next() (*recordingEntry, error) {
+	var entry recordingEntry
+	if err := r.dec.Decode(&entry); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	return &entry, nil
+}
+
+// close closes the recording file
+func (r *recordingReader) close() {
+	r.file.Close()
+}
+
+// replayer plays a recording back in place of the collectors
+type replayer struct {
+	path   string
+	speed  float64
+	loop   bool
+	header recordingHeader
+
+	reader *recordingReader
+	first  time.Time     // timestamp of the first sample
+	last   time.Time     // timestamp of the last sample read, before shifting
+	shift  time.Duration // added to the timestamps of the current pass
+
+	mu    sync.RWMutex
+	stats *SystemStats
+}
+
+// Replay makes the monitor play back a recording instead of collecting
+// stats, at speed times the recorded pace, from the start again when loop
+// is set. It is called before Start, which then starts no collectors, no
+// probes and no watchdog.
+func (sm *SystemMonitor) Replay(path string, speed float64, loop bool) error {
+	if speed <= 0 {
+		return fmt.Errorf("invalid replay speed %g", speed)
+	}
+	reader, err := openRecording(path)
+	if err != nil {
+		return err
+	}
+
+	// Show the first sample right away
+	rp := &replayer{path: path, speed: speed, loop: loop, header: reader.header, reader: reader}
+	var entries []*recordingEntry
+	for {
+		entry, err := reader.next()
+		if err != nil {
+			reader.close()
+			return fmt.Errorf("%s has no samples", path)
+		}
+		entries = append(entries, entry)
+		if entry.Stats != nil {
+			rp.first, rp.last = entry.Stats.Timestamp, entry.Stats.Timestamp
+			break
+		}
+	}
+
+	sm.replay = rp
+	if sm.kmsg == nil {
+		sm.kmsg = newKernelLog(KernelLogConfig{Buffer: sm.cfg.KernelLog.Buffer}, sm)
+	}
+	for _, entry := range entries {
+		sm.applyReplayEntry(entry)
+	}
+
+	sm.log.Infof("Replaying %s recorded %s on %s at %gx speed", path,
+		rp.header.Started.Format(time.RFC3339), recordingHost(rp.header), speed)
+	return nil
+}
+
+// recordingHost names the device a recording was made on
+func recordingHost(header recordingHeader) string {
+	if header.Inventory != nil && header.Inventory.Hostname != "" {
+		return header.Inventory.Hostname
+	}
+	return "an unknown device"
+}
+
+// runReplay plays the rest of the recording, waiting before each entry
+// until its time has come at the replay speed
+func (sm *SystemMonitor) runReplay(stop <-chan struct{}) {
+	rp := sm.replay
+	start := time.Now()
+
+	for {
+		for {
+			entry, err := rp.reader.next()
+			if err == io.ErrUnexpectedEOF {
+				sm.log.Warnf("Recording %s ends early, it was not closed cleanly", rp.path)
+			}
+			if err != nil {
+				break
+			}
+
+			if t := entryTime(entry); t.After(rp.last) {
+				rp.last = t
+			}
+			due := start.Add(time.Duration(float64(rp.last.Add(rp.shift).Sub(rp.first)) / rp.speed))
+			select {
+			case <-stop:
+				rp.reader.close()
+				return
+			case <-time.After(time.Until(due)):
+			}
+			sm.applyReplayEntry(entry)
+		}
+		rp.reader.close()
+
+		if !rp.loop {
+			sm.log.Infof("Replay of %s finished", rp.path)
+			return
+		}
+		reader, err := openRecording(rp.path)
+		if err != nil {
+			sm.log.Errorf("Failed to restart replay: %v", err)
+			return
+		}
+		// Continue the timestamps where the last pass ended, so the history
+		// and the charts keep moving forward
+		rp.reader = reader
+		rp.shift += rp.last.Sub(rp.first) + rp.header.Interval
+		rp.last = rp.first
+	}
+}
+
+// entryTime returns the recorded time of an entry
+func entryTime(entry *recordingEntry) time.Time {
+	switch {
+	case entry.Stats != nil:
+		return entry.Stats.Timestamp
+	case entry.Event != nil:
+		return entry.Event.Time
+	case entry.Kernel != nil:
+		return entry.Kernel.Time
+	}
+	return time.Time{}
+}
+
+// applyReplayEntry makes an entry of the recording current: a sample is
+// returned by GetSystemStats and LatestStats and kept in the history, and
+// events and kernel messages are added to their logs
+func (sm *SystemMonitor) applyReplayEntry(entry *recordingEntry) {
+	rp := sm.replay
+	switch {
+	case entry.Stats != nil:
+		stats := entry.Stats
+		stats.Timestamp = stats.Timestamp.Add(rp.shift)
+		rp.mu.Lock()
+		rp.stats = stats
+		rp.mu.Unlock()
+		if sm.history != nil {
+			sm.history.Record(stats.Timestamp, stats.Metrics())
+		}
+		sm.publish(stats)
+	case entry.Event != nil:
+		event := *entry.Event
+		event.Time = event.Time.Add(rp.shift)
+		sm.events.add(event)
+	case entry.Kernel != nil:
+		msg := *entry.Kernel
+		msg.Time = msg.Time.Add(rp.shift)
+		sm.kmsg.add(msg)
+	}
+}
+
+// replayStats returns a copy of the current sample of the replay
+func (rp *replayer) replayStats() (*SystemStats, error) {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+
+	if rp.stats == nil {
+		return nil, errors.New("no replayed sample yet")
+	}
+	stats := *rp.stats
+	return &stats, nil
+}
+
+// replayInventory returns the inventory recorded with the replay
+func (rp *replayer) replayInventory() *Inventory {
+	if rp.header.Inventory == nil {
+		return &Inventory{}
+	}
+	inv := *rp.header.Inventory
+	return &inv
+}
@@ -0,0 +1,192 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestReplayMonitor() *SystemMonitor {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	return NewSystemMonitor(log, DefaultConfig())
+}
+
+// writeTestRecording records three samples a second apart, with an event
+// before the last one, and returns the time of the first sample
+func writeTestRecording(t *testing.T, path string, close bool) time.Time {
+	sm := newTestReplayMonitor()
+	rec, err := NewRecorder(path, sm, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		ts := start.Add(time.Duration(i) * time.Second)
+		if i == 2 {
+			sm.events.add(Event{Time: ts, Source: "test", Name: "spike", Severity: HealthWarning})
+		}
+		if err := rec.Record(&SystemStats{Timestamp: ts, CPU: CPUStats{UsagePercent: float64(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rec.Samples() != 3 {
+		t.Errorf("samples = %d, want 3", rec.Samples())
+	}
+	if close {
+		if err := rec.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return start
+}
+
+// waitForStats polls the replayed stats until ok accepts them
+func waitForStats(t *testing.T, sm *SystemMonitor, ok func(*SystemStats) bool) *SystemStats {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := sm.GetSystemStats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok(stats) {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replay did not reach the expected sample, last at %s", stats.Timestamp)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReplayRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rec.gz")
+	start := writeTestRecording(t, path, true)
+
+	sm := newTestReplayMonitor()
+	if err := sm.Replay(path, 1000, false); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := sm.GetSystemStats()
+	if err != nil || !stats.Timestamp.Equal(start) {
+		t.Fatalf("expected the first sample before Start, got %v, %v", stats, err)
+	}
+
+	sm.Start()
+	defer sm.Stop()
+	stats = waitForStats(t, sm, func(s *SystemStats) bool { return s.CPU.UsagePercent == 2 })
+	if !stats.Timestamp.Equal(start.Add(2 * time.Second)) {
+		t.Errorf("last sample at %s, want the recorded time", stats.Timestamp)
+	}
+	if events := sm.RecentEvents(); len(events) != 1 || events[0].Name != "spike" {
+		t.Errorf("events = %+v, want the recorded event", events)
+	}
+	if sm.GetInventory() == nil {
+		t.Error("expected the recorded inventory")
+	}
+}
+
+func TestReplayLoopContinuesTimestamps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rec.gz")
+	start := writeTestRecording(t, path, true)
+
+	sm := newTestReplayMonitor()
+	if err := sm.Replay(path, 1000, true); err != nil {
+		t.Fatal(err)
+	}
+	sm.Start()
+	defer sm.Stop()
+
+	// Each pass starts an interval after the end of the previous one
+	stats := waitForStats(t, sm, func(s *SystemStats) bool { return s.Timestamp.After(start.Add(2 * time.Second)) })
+	offset := int(stats.Timestamp.Sub(start) / time.Second)
+	if float64(offset%3) != stats.CPU.UsagePercent {
+		t.Errorf("sample %v at %s after the start, want passes of 3 samples a second apart",
+			stats.CPU.UsagePercent, stats.Timestamp.Sub(start))
+	}
+}
+
+func TestReplayUnclosedRecording(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rec.gz")
+	writeTestRecording(t, path, false)
+
+	sm := newTestReplayMonitor()
+	if err := sm.Replay(path, 1000, false); err != nil {
+		t.Fatal(err)
+	}
+	sm.Start()
+	defer sm.Stop()
+	waitForStats(t, sm, func(s *SystemStats) bool { return s.CPU.UsagePercent == 2 })
+}
+
+func TestReplayRejectsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"empty":   "",
+		"text":    "not a recording\n",
+		"missing": "",
+	} {
+		path := filepath.Join(dir, name)
+		if name != "missing" {
+			writeTestFile(t, path, content)
+		}
+		if err := newTestReplayMonitor().Replay(path, 1, false); err == nil {
+			t.Errorf("expected an error replaying the %s file", name)
+		}
+	}
+
+	path := filepath.Join(dir, "rec.gz")
+	writeTestRecording(t, path, true)
+	if err := newTestReplayMonitor().Replay(path, 0, false); err == nil {
+		t.Error("expected an error for a zero speed")
+	}
+}
+
+func TestRecordKernelMessagesWithoutSeq(t *testing.T) {
+	sm := newTestReplayMonitor()
+	sm.kmsg = newKernelLog(KernelLogConfig{Buffer: 2}, sm)
+	path := filepath.Join(t.TempDir(), "test.emrec")
+	rec, err := NewRecorder(path, sm, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages from a plain log file have no sequence numbers, and the ring
+	// holds two, so the third sample misses one of the messages before it
+	batches := [][]string{{"first"}, {"second"}, {"third", "fourth", "fifth"}}
+	for i, batch := range batches {
+		for _, text := range batch {
+			sm.kmsg.add(KernelMessage{Message: text})
+		}
+		if err := rec.Record(&SystemStats{Timestamp: time.Unix(int64(i), 0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openRecording(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.close()
+	var got []string
+	for {
+		entry, err := r.next()
+		if err != nil {
+			break
+		}
+		if entry.Kernel != nil {
+			got = append(got, entry.Kernel.Message)
+		}
+	}
+	if strings.Join(got, " ") != "first second fourth fifth" {
+		t.Errorf("recorded kernel messages = %q", got)
+	}
+}
@@ -0,0 +1,117 @@
+package monitor
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// staleSamples is the number of missed samples after which the sampling loop
+// counts as stalled
+const staleSamples = 3
+
+// sampler holds the latest sample of the sampling loop and the subscribers
+// waiting for the next one
+type sampler struct {
+	mu        sync.RWMutex
+	latest    *SystemStats
+	published time.Time // when the latest sample was made current
+	subs      map[chan *SystemStats]struct{}
+}
+
+// sample collects the stats and makes them the latest sample. Every
+// collection advances the rates, averages and write accounting, so only the
+// sampling loop calls it; everyone else reads LatestStats or subscribes.
+func (sm *SystemMonitor) sample() (*SystemStats, error) {
+	stats, err := sm.GetSystemStats()
+	if err != nil {
+		return nil, err
+	}
+	if sm.history != nil {
+		sm.history.Record(stats.Timestamp, stats.Metrics())
+	}
+	sm.publish(stats)
+	return stats, nil
+}
+
+// runSampler takes a sample every sample interval until stop is closed
+func (sm *SystemMonitor) runSampler(stop <-chan struct{}) {
+	ticker := time.NewTicker(sm.cfg.SampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := sm.sample(); err != nil {
+				sm.log.Errorf("Failed to get system stats: %v", err)
+			}
+		}
+	}
+}
+
+// publish makes stats the latest sample and sends it to the subscribers. A
+// subscriber that has not taken the previous sample gets the new one instead.
+func (sm *SystemMonitor) publish(stats *SystemStats) {
+	s := &sm.sampler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latest = stats
+	s.published = time.Now()
+	for ch := range s.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- stats
+	}
+}
+
+// LatestStats returns the latest sample of the sampling loop, or of the
+// replay. The sample is shared, so callers must not modify it.
+func (sm *SystemMonitor) LatestStats() (*SystemStats, error) {
+	s := &sm.sampler
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.latest == nil {
+		return nil, errors.New("no sample yet")
+	}
+	return s.latest, nil
+}
+
+// Stalled reports whether the sampling loop has not made a sample for a few
+// sample intervals, e.g. because a collector hangs
+func (sm *SystemMonitor) Stalled() bool {
+	s := &sm.sampler
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.latest == nil || time.Since(s.published) > staleSamples*sm.cfg.SampleInterval
+}
+
+// Subscribe returns a channel that receives every new sample, and a function
+// that ends the subscription. A subscriber that falls behind misses samples
+// rather than holding up the sampling loop.
+func (sm *SystemMonitor) Subscribe() (<-chan *SystemStats, func()) {
+	s := &sm.sampler
+	ch := make(chan *SystemStats, 1)
+
+	s.mu.Lock()
+	if s.subs == nil {
+		s.subs = make(map[chan *SystemStats]struct{})
+	}
+	s.subs[ch] = struct{}{}
+	s.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subs, ch)
+			s.mu.Unlock()
+		})
+	}
+}
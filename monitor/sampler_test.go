@@ -0,0 +1,84 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestSampler() *SystemMonitor {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	cfg := DefaultConfig()
+	cfg.SampleInterval = 10 * time.Millisecond
+	cfg.History.Persist.Enabled = false
+	cfg.Simulation.Enabled = true
+	cfg.Simulation.Seed = 1
+	return NewSystemMonitor(log, cfg)
+}
+
+func TestOnlySamplesAreRecorded(t *testing.T) {
+	sm := newTestSampler()
+	if _, err := sm.GetSystemStats(); err != nil {
+		t.Fatal(err)
+	}
+	if metrics := sm.history.Metrics(); len(metrics) != 0 {
+		t.Fatalf("collecting recorded %d metrics, want none", len(metrics))
+	}
+	if _, err := sm.LatestStats(); err == nil {
+		t.Error("expected no latest sample before the first one")
+	}
+
+	stats, err := sm.sample()
+	if err != nil {
+		t.Fatal(err)
+	}
+	points, err := sm.history.Query("cpu.usage_percent", stats.Timestamp.Add(-time.Minute), stats.Timestamp, 0)
+	if err != nil || len(points) == 0 || points[len(points)-1].Count != 1 {
+		t.Errorf("history = %+v, %v, want the sample", points, err)
+	}
+	if latest, err := sm.LatestStats(); err != nil || latest != stats {
+		t.Errorf("latest = %v, %v, want the sample", latest, err)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	sm := newTestSampler()
+	samples, cancel := sm.Subscribe()
+
+	sm.Start()
+	defer sm.Stop()
+	first := <-samples
+	if next := <-samples; !next.Timestamp.After(first.Timestamp) {
+		t.Errorf("next sample at %s, want after %s", next.Timestamp, first.Timestamp)
+	}
+	if sm.Stalled() {
+		t.Error("expected a running sampling loop not to be stalled")
+	}
+
+	cancel()
+	cancel()
+	sm.sampler.mu.RLock()
+	defer sm.sampler.mu.RUnlock()
+	if len(sm.sampler.subs) != 0 {
+		t.Error("expected no subscribers after cancel")
+	}
+}
+
+func TestStalled(t *testing.T) {
+	sm := newTestSampler()
+	if !sm.Stalled() {
+		t.Error("expected a monitor without samples to be stalled")
+	}
+	sm.sample()
+	if sm.Stalled() {
+		t.Error("expected a fresh sample not to be stalled")
+	}
+	sm.sampler.published = time.Now().Add(-staleSamples*sm.cfg.SampleInterval - time.Millisecond)
+	if !sm.Stalled() {
+		t.Error("expected an old sample to be stalled")
+	}
+}
@@ -0,0 +1,277 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockTicks is USER_HZ, the unit of the times in /proc/<pid>/stat, which
+// the kernel fixes at 100 for userspace on every architecture
+const clockTicks = 100
+
+// ServiceStatus represents the state of one watched service
+type ServiceStatus struct {
+	Up         bool    `json:"up"`
+	PID        int     `json:"pid"`
+	Processes  int     `json:"processes"` // matching processes, the oldest is reported
+	Restarts   uint64  `json:"restarts"`  // pid changes since emmon started
+	Uptime     float64 `json:"uptime"`    // seconds since the process started
+	CPUPercent float64 `json:"cpu_percent"`
+	RSS        uint64  `json:"rss"` // bytes
+}
+
+// procInfo holds what we read about one process
+type procInfo struct {
+	PID       int
+	Comm      string
+	Cmdline   string
+	StartTime uint64 // clock ticks after boot
+	CPUTicks  uint64 // user and system time
+	RSSPages  uint64
+}
+
+// serviceWatch matches one configured service and remembers its last state
+type serviceWatch struct {
+	cfg     ServiceConfig
+	process *regexp.Regexp
+	cmdline *regexp.Regexp
+
+	status   ServiceStatus
+	lastPID  int
+	cpuTicks uint64
+	sampled  time.Time
+	checked  bool
+}
+
+// serviceTracker keeps the watched services
+type serviceTracker struct {
+	mu       sync.Mutex
+	services []*serviceWatch
+}
+
+// newServiceTracker creates the watches of the configured services, skipping
+// invalid ones
+func newServiceTracker(cfgs []ServiceConfig, sm *SystemMonitor) *serviceTracker {
+	st := &serviceTracker{}
+	for _, cfg := range cfgs {
+		if cfg.Name == "" || (cfg.Process == "" && cfg.Cmdline == "" && cfg.Pidfile == "") {
+			sm.log.Errorf("Invalid service %q: a name and a process, cmdline or pidfile are required", cfg.Name)
+			continue
+		}
+
+		watch := &serviceWatch{cfg: cfg}
+		var err error
+		if cfg.Process != "" {
+			if watch.process, err = regexp.Compile(cfg.Process); err != nil {
+				sm.log.Errorf("Invalid service %s: %v", cfg.Name, err)
+				continue
+			}
+		}
+		if cfg.Cmdline != "" {
+			if watch.cmdline, err = regexp.Compile(cfg.Cmdline); err != nil {
+				sm.log.Errorf("Invalid service %s: %v", cfg.Name, err)
+				continue
+			}
+		}
+		st.services = append(st.services, watch)
+	}
+	return st
+}
+
+// getServiceStats checks the watched services and raises events when one
+// goes down, comes back or restarts
+func (sm *SystemMonitor) getServiceStats() (map[string]ServiceStatus, error) {
+	if len(sm.services.services) == 0 {
+		return nil, nil
+	}
+
+	procs, err := readProcesses("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	bootTime := readBootTimeStat()
+
+	sm.services.mu.Lock()
+	defer sm.services.mu.Unlock()
+
+	results := make(map[string]ServiceStatus, len(sm.services.services))
+	for _, watch := range sm.services.services {
+		matches := watch.match(procs)
+		if event, changed := watch.update(matches, now, bootTime); changed {
+			sm.emitEvent(event)
+		}
+		results[watch.cfg.Name] = watch.status
+	}
+	return results, nil
+}
+
+// match returns the processes of the service, from the pidfile when one is
+// configured and otherwise by name and command line
+func (w *serviceWatch) match(procs []procInfo) []procInfo {
+	if w.cfg.Pidfile != "" {
+		data, err := ioutil.ReadFile(w.cfg.Pidfile)
+		if err != nil {
+			return nil
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil
+		}
+		for _, proc := range procs {
+			if proc.PID == pid {
+				return []procInfo{proc}
+			}
+		}
+		return nil
+	}
+
+	var matches []procInfo
+	for _, proc := range procs {
+		if w.process != nil && !w.process.MatchString(proc.Comm) {
+			continue
+		}
+		if w.cmdline != nil && !w.cmdline.MatchString(proc.Cmdline) {
+			continue
+		}
+		matches = append(matches, proc)
+	}
+	return matches
+}
+
+// update derives the service status from its matching processes. The oldest
+// process is taken as the service's main one, so workers it forks do not
+// count as restarts.
+func (w *serviceWatch) update(matches []procInfo, now, bootTime time.Time) (Event, bool) {
+	wasUp := w.status.Up
+	first := !w.checked
+	w.checked = true
+
+	if len(matches) == 0 {
+		w.status = ServiceStatus{Restarts: w.status.Restarts}
+		w.sampled = time.Time{}
+		if wasUp || first {
+			return w.event(HealthCritical, "Service %s is not running", w.cfg.Name), true
+		}
+		return Event{}, false
+	}
+
+	main := matches[0]
+	for _, proc := range matches[1:] {
+		if proc.StartTime < main.StartTime {
+			main = proc
+		}
+	}
+
+	restarted := w.lastPID != 0 && main.PID != w.lastPID
+	if restarted {
+		w.status.Restarts++
+	}
+
+	w.status.Up = true
+	w.status.PID = main.PID
+	w.status.Processes = len(matches)
+	w.status.RSS = main.RSSPages * uint64(os.Getpagesize())
+	started := bootTime.Add(time.Duration(main.StartTime) * time.Second / clockTicks)
+	w.status.Uptime = now.Sub(started).Seconds()
+
+	w.status.CPUPercent = 0
+	if !restarted && !w.sampled.IsZero() && main.CPUTicks >= w.cpuTicks {
+		if seconds := now.Sub(w.sampled).Seconds(); seconds > 0 {
+			w.status.CPUPercent = float64(main.CPUTicks-w.cpuTicks) / clockTicks / seconds * 100
+		}
+	}
+	w.cpuTicks = main.CPUTicks
+	w.sampled = now
+	w.lastPID = main.PID
+
+	switch {
+	case restarted && wasUp:
+		return w.event(HealthWarning, "Service %s restarted (pid %d)", w.cfg.Name, main.PID), true
+	case !wasUp && !first:
+		return w.event(HealthOK, "Service %s is running again (pid %d)", w.cfg.Name, main.PID), true
+	}
+	return Event{}, false
+}
+
+// event creates a service event
+func (w *serviceWatch) event(severity HealthLevel, format string, args ...interface{}) Event {
+	return Event{
+		Source:   "service",
+		Name:     w.cfg.Name,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	}
+}
+
+// readProcesses reads the name, command line and counters of every process.
+// Processes that exit while being read are skipped.
+func readProcesses(procPath string) ([]procInfo, error) {
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var procs []procInfo
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(procPath, entry.Name())
+
+		stat, err := ioutil.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			continue
+		}
+		proc, err := parseProcPIDStat(string(stat))
+		if err != nil {
+			continue
+		}
+		proc.PID = pid
+
+		if cmdline, err := ioutil.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+			proc.Cmdline = strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+		}
+		procs = append(procs, proc)
+	}
+
+	return procs, nil
+}
+
+// parseProcPIDStat parses /proc/<pid>/stat. The command name is in
+// parentheses and may itself contain spaces and parentheses, so the fields
+// are split after the last ")".
+func parseProcPIDStat(data string) (procInfo, error) {
+	start := strings.Index(data, "(")
+	end := strings.LastIndex(data, ")")
+	if start < 0 || end < start {
+		return procInfo{}, fmt.Errorf("unexpected stat format: %q", data)
+	}
+
+	// Fields after the command start with the state, field 3 in proc(5)
+	fields := strings.Fields(data[end+1:])
+	if len(fields) < 22 {
+		return procInfo{}, fmt.Errorf("unexpected stat format: %q", data)
+	}
+	field := func(n int) uint64 {
+		value, _ := strconv.ParseUint(fields[n-3], 10, 64)
+		return value
+	}
+
+	return procInfo{
+		Comm:      data[start+1 : end],
+		CPUTicks:  field(14) + field(15), // utime + stime
+		StartTime: field(22),
+		RSSPages:  field(24),
+	}, nil
+}
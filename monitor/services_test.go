@@ -0,0 +1,88 @@
+package monitor
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParseProcPIDStat(t *testing.T) {
+	stat := "1234 (my (odd) app) S 1 1234 1234 0 -1 4194560 500 0 0 0 150 50 0 0 20 0 3 0 98765 123456789 2048 " +
+		"18446744073709551615 1 1 0 0 0 0 0 4096 0 0 0 0 17 1 0 0 0 0 0\n"
+	proc, err := parseProcPIDStat(stat)
+	if err != nil {
+		t.Fatalf("parseProcPIDStat: %v", err)
+	}
+	if proc.Comm != "my (odd) app" || proc.CPUTicks != 200 || proc.StartTime != 98765 || proc.RSSPages != 2048 {
+		t.Errorf("unexpected process: %+v", proc)
+	}
+
+	if _, err := parseProcPIDStat("garbage"); err == nil {
+		t.Error("expected an error for malformed input")
+	}
+}
+
+func TestServiceMatch(t *testing.T) {
+	procs := []procInfo{
+		{PID: 10, Comm: "python3", Cmdline: "python3 /opt/app/main.py"},
+		{PID: 11, Comm: "python3", Cmdline: "python3 /opt/other/run.py"},
+		{PID: 12, Comm: "mosquitto", Cmdline: "/usr/sbin/mosquitto -c /etc/mosquitto.conf"},
+	}
+
+	byName := &serviceWatch{process: regexp.MustCompile(`^mosquitto$`)}
+	if matches := byName.match(procs); len(matches) != 1 || matches[0].PID != 12 {
+		t.Errorf("process match = %+v", matches)
+	}
+
+	byCmdline := &serviceWatch{process: regexp.MustCompile(`^python`), cmdline: regexp.MustCompile(`/opt/app/`)}
+	if matches := byCmdline.match(procs); len(matches) != 1 || matches[0].PID != 10 {
+		t.Errorf("cmdline match = %+v", matches)
+	}
+
+	pidfile := filepath.Join(t.TempDir(), "app.pid")
+	writeTestFile(t, pidfile, "11\n")
+	byPidfile := &serviceWatch{cfg: ServiceConfig{Pidfile: pidfile}}
+	if matches := byPidfile.match(procs); len(matches) != 1 || matches[0].PID != 11 {
+		t.Errorf("pidfile match = %+v", matches)
+	}
+}
+
+func TestServiceUpdate(t *testing.T) {
+	w := &serviceWatch{cfg: ServiceConfig{Name: "app"}}
+	boot := time.Now().Add(-time.Hour)
+	now := boot.Add(30 * time.Minute)
+
+	// Worker processes forked later do not replace the main one
+	procs := []procInfo{
+		{PID: 20, StartTime: 200 * clockTicks, CPUTicks: 50},
+		{PID: 10, StartTime: 100 * clockTicks, CPUTicks: 100, RSSPages: 10},
+	}
+	if _, changed := w.update(procs, now, boot); changed {
+		t.Error("a service that is up on the first check should not raise an event")
+	}
+	if w.status.PID != 10 || w.status.Processes != 2 || w.status.Uptime != 1700 {
+		t.Errorf("unexpected status: %+v", w.status)
+	}
+
+	procs[1].CPUTicks = 150
+	w.update(procs, now.Add(time.Second), boot)
+	if w.status.CPUPercent != 50 {
+		t.Errorf("cpu = %v, want 50", w.status.CPUPercent)
+	}
+
+	event, changed := w.update(nil, now.Add(2*time.Second), boot)
+	if !changed || event.Severity != HealthCritical || w.status.Up {
+		t.Errorf("expected a down event, got %+v", event)
+	}
+
+	event, changed = w.update([]procInfo{{PID: 30, StartTime: 1700 * clockTicks}}, now.Add(3*time.Second), boot)
+	if !changed || event.Severity != HealthOK || w.status.Restarts != 1 {
+		t.Errorf("expected an up event after a restart, got %+v (status %+v)", event, w.status)
+	}
+
+	event, changed = w.update([]procInfo{{PID: 31, StartTime: 1710 * clockTicks}}, now.Add(4*time.Second), boot)
+	if !changed || event.Severity != HealthWarning || w.status.Restarts != 2 {
+		t.Errorf("expected a restart event, got %+v (status %+v)", event, w.status)
+	}
+}
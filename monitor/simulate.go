@@ -0,0 +1,508 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// simulatedMessage is a kernel message a fault logs once it has run for
+// the given fraction of its duration
+type simulatedMessage struct {
+	at       float64
+	priority int
+	text     string
+}
+
+// SimulationFaults lists the faults a scenario can inject
+var SimulationFaults = []string{
+	"overheat", "memory_leak", "cpu_stall", "disk_full", "read_only",
+	"network_down", "mmc_errors", "under_voltage", "flash_wear",
+}
+
+// simulatedMessages are the kernel messages of the faults, worded like the
+// drivers do so the kernel log rules match them
+var simulatedMessages = map[string][]simulatedMessage{
+	"overheat": {
+		{0.5, 4, "cpu cpu0: thermal throttling, frequency capped"},
+		{0.9, 2, "thermal thermal_zone0: critical temperature reached, shutting down"},
+	},
+	"memory_leak": {
+		{0.95, 3, "Out of memory: Killed process 4242 (leaky-app) total-vm:812340kB"},
+	},
+	"read_only": {
+		{0, 3, "EXT4-fs error (device mmcblk0p2): ext4_journal_check_start:83: Detected aborted journal"},
+		{0, 2, "EXT4-fs (mmcblk0p2): Remounting filesystem read-only"},
+	},
+	"network_down": {
+		{0, 6, "fec 30be0000.ethernet eth0: Link is Down"},
+	},
+	"mmc_errors": {
+		{0, 3, "mmc0: cache flush error -110"},
+		{0.5, 3, "blk_update_request: I/O error, dev mmcblk0, sector 2048000 op 0x1:(WRITE) flags 0x0"},
+	},
+	"under_voltage": {
+		{0, 2, "hwmon hwmon1: Undervoltage detected!"},
+	},
+}
+
+// simulator generates the stats of a made-up device: CPU load waves, a
+// temperature that follows the load, GPIO pins toggling, a disk filling up
+// and network bursts, plus the faults of the scenarios
+type simulator struct {
+	cfg       SimulationConfig
+	scenarios []SimulationScenario
+
+	mu       sync.Mutex
+	rng      *rand.Rand
+	started  time.Time
+	elapsed  float64 // simulated seconds at the last sample
+	sampled  bool
+	load     [3]float64
+	temp     float64
+	diskUsed float64
+	written  float64 // bytes written to flash
+	ioRead   float64
+	ioWrite  float64
+	oomKills uint64
+	seq      uint64
+	logged   map[[2]int]int // scenario and message -> occurrences logged
+}
+
+// newSimulator fills in the defaults and skips unknown faults
+func newSimulator(cfg SimulationConfig, sm *SystemMonitor) *simulator {
+	if cfg.Speed <= 0 {
+		cfg.Speed = 1
+	}
+	if cfg.Cores < 1 {
+		cfg.Cores = 1
+	}
+	if cfg.CPUPeriod <= 0 {
+		cfg.CPUPeriod = 5 * time.Minute
+	}
+	if cfg.GPIOToggle <= 0 {
+		cfg.GPIOToggle = 5 * time.Second
+	}
+	if cfg.Scenarios == nil {
+		cfg.Scenarios = DefaultSimulationScenarios()
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = time.Now().UnixNano()
+	}
+
+	s := &simulator{
+		cfg:      cfg,
+		rng:      rand.New(rand.NewSource(cfg.Seed)),
+		started:  time.Now(),
+		diskUsed: 0.45 * float64(cfg.DiskTotal),
+		logged:   make(map[[2]int]int),
+	}
+	for _, scenario := range cfg.Scenarios {
+		if !isSimulationFault(scenario.Fault) {
+			sm.log.Errorf("Unknown simulated fault %q", scenario.Fault)
+			continue
+		}
+		s.scenarios = append(s.scenarios, scenario)
+	}
+	sm.log.Infof("Simulating a device with %d scenarios at %gx speed", len(s.scenarios), cfg.Speed)
+	return s
+}
+
+// isSimulationFault reports whether a fault can be simulated
+func isSimulationFault(fault string) bool {
+	for _, f := range SimulationFaults {
+		if f == fault {
+			return true
+		}
+	}
+	return false
+}
+
+// occurrence returns how far a scenario is into its current run at
+// simulated time t, and whether a run is active
+func (sc SimulationScenario) occurrence(t float64) (float64, bool) {
+	since := t - sc.Start.Seconds()
+	if since < 0 || sc.Duration <= 0 {
+		return 0, false
+	}
+	if sc.Repeat > 0 {
+		since = math.Mod(since, sc.Repeat.Seconds())
+	}
+	if since > sc.Duration.Seconds() {
+		return 0, false
+	}
+	return since / sc.Duration.Seconds(), true
+}
+
+// lastRun returns the latest run of a scenario that is at least offset
+// seconds in at simulated time t, or -1 if none is
+func (sc SimulationScenario) lastRun(t, offset float64) int {
+	since := t - sc.Start.Seconds() - offset
+	if since < 0 || sc.Duration <= 0 {
+		return -1
+	}
+	if sc.Repeat <= 0 {
+		return 0
+	}
+	return int(since / sc.Repeat.Seconds())
+}
+
+// fill generates the device stats of the current moment
+func (s *simulator) fill(sm *SystemMonitor, stats *SystemStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg := s.cfg
+	t := time.Since(s.started).Seconds() * cfg.Speed
+	dt := t - s.elapsed
+	s.elapsed = t
+
+	// The progress of the active faults, and their kernel messages. A
+	// message whose time passed between two samples is still logged.
+	faults := make(map[string]float64)
+	for i, sc := range s.scenarios {
+		if progress, active := sc.occurrence(t); active {
+			faults[sc.Fault] = progress
+		}
+		for j, msg := range simulatedMessages[sc.Fault] {
+			key := [2]int{i, j}
+			run := sc.lastRun(t, msg.at*sc.Duration.Seconds())
+			if run >= 0 && s.logged[key] <= run {
+				s.logged[key] = run + 1
+				s.logKernel(sm, stats.Timestamp, t, msg.priority, msg.text)
+				if sc.Fault == "memory_leak" {
+					s.oomKills++
+				}
+			}
+		}
+	}
+	fault := func(name string) (float64, bool) {
+		progress, ok := faults[name]
+		return progress, ok
+	}
+
+	burst := 0.0
+	if cfg.BurstEvery > 0 && math.Mod(t, cfg.BurstEvery.Seconds()) < cfg.BurstDuration.Seconds() {
+		burst = 1
+	}
+	_, linkDown := fault("network_down")
+	if linkDown {
+		burst = 0
+	}
+
+	// CPU: a slow wave with a faster ripple, noise and the bursts
+	period := cfg.CPUPeriod.Seconds()
+	cpuUsage := cfg.CPUBase + cfg.CPUAmplitude*math.Sin(2*math.Pi*t/period) +
+		cfg.CPUAmplitude/3*math.Sin(2*math.Pi*t/(period/7.3)) + 3*s.rng.NormFloat64() + 15*burst
+	if _, ok := fault("cpu_stall"); ok {
+		cpuUsage = 100
+	}
+	cpuUsage = math.Max(1, math.Min(100, cpuUsage))
+
+	runnable := float64(cfg.Cores) * cpuUsage / 100
+	blocked := 0.0
+	if _, ok := fault("mmc_errors"); ok {
+		blocked = 3
+	}
+	for i, tau := range []float64{60, 300, 900} {
+		if !s.sampled {
+			s.load[i] = runnable + blocked
+		} else {
+			s.load[i] += (runnable + blocked - s.load[i]) * (1 - math.Exp(-dt/tau))
+		}
+	}
+
+	// Temperatures follow the load with a thermal lag
+	ambient := cfg.Ambient + 2*math.Sin(2*math.Pi*t/86400)
+	target := ambient + 40*cpuUsage/100
+	if progress, ok := fault("overheat"); ok {
+		target += 40 + 20*progress
+	}
+	if !s.sampled {
+		s.temp = target
+	} else {
+		s.temp += (target - s.temp) * (1 - math.Exp(-dt/45))
+	}
+	temp := s.temp + 0.3*s.rng.NormFloat64()
+
+	frequency := 600 + 1200*cpuUsage/100
+	if _, ok := fault("under_voltage"); ok || temp > 85 {
+		frequency = math.Min(frequency, 1000)
+	}
+	frequency = math.Round(frequency/100) * 100
+
+	stats.CPU = CPUStats{
+		UsagePercent: cpuUsage,
+		LoadAverage:  []float64{round2(s.load[0]), round2(s.load[1]), round2(s.load[2])},
+		Temperature:  temp,
+		Frequency:    frequency,
+	}
+	stats.Temperature = TempStats{
+		CPU:     temp,
+		GPU:     temp - 4 + 0.3*s.rng.NormFloat64(),
+		Board:   ambient + (s.temp-ambient)*0.6,
+		Ambient: ambient,
+	}
+
+	// Memory: a gentle wave, or a leak that grows until the OOM killer
+	memFraction := 0.30 + 0.05*math.Sin(2*math.Pi*t/(3*period))
+	leak := 0.0
+	if progress, ok := fault("memory_leak"); ok && progress < 0.95 {
+		leak = progress / 0.95
+		memFraction += 0.68 * leak
+	}
+	memTotal := float64(cfg.MemoryTotal)
+	memUsed := memFraction * memTotal
+	stats.Memory = MemStats{
+		Total:        cfg.MemoryTotal,
+		Used:         uint64(memUsed),
+		Free:         uint64((memTotal - memUsed) * 0.4),
+		Available:    uint64(memTotal - memUsed),
+		UsagePercent: memFraction * 100,
+	}
+
+	// Disk: fills at the fill rate until log rotation frees it again
+	s.diskUsed += float64(cfg.DiskFillRate) * dt
+	diskTotal := float64(cfg.DiskTotal)
+	if s.diskUsed > 0.97*diskTotal {
+		s.diskUsed = 0.45 * diskTotal
+	}
+	diskUsed := s.diskUsed
+	if _, ok := fault("disk_full"); ok {
+		diskUsed = 0.995 * diskTotal
+	}
+	writeRate := float64(cfg.DiskFillRate) + 200e3*burst
+	s.written += writeRate * dt
+	s.ioWrite += writeRate / 4096 * dt
+	s.ioRead += (20 + cpuUsage) * dt
+	_, readOnly := fault("read_only")
+	stats.Disk = DiskStats{
+		Total:        cfg.DiskTotal,
+		Used:         uint64(diskUsed),
+		Free:         uint64(diskTotal - diskUsed),
+		UsagePercent: diskUsed / diskTotal * 100,
+		IORead:       uint64(s.ioRead),
+		IOWrite:      uint64(s.ioWrite),
+		ReadOnly:     readOnly,
+	}
+
+	stats.GPIO = s.gpio(t)
+	stats.Interrupts = s.interrupts(sm, cpuUsage, burst, linkDown, blocked)
+	stats.VM = s.vm(cpuUsage, leak, dt)
+	stats.Limits = s.limits(cpuUsage, burst)
+	stats.NetConfig = simulatedNetConfig(linkDown)
+	stats.Storage, stats.Writes = s.storage(sm, writeRate*math.Mod(t, 3600))
+	if _, ok := fault("flash_wear"); ok {
+		device := stats.Storage.Devices["mmcblk0"]
+		device.LifeTimeA, device.LifeTimeB, device.PreEOL = 10, 9, 3
+		device.PreEOLState, device.WearPercent = preEOLState(3), 95
+		stats.Storage.Devices["mmcblk0"] = device
+	}
+	if kernelStats, err := sm.getKernelLogStats(); err == nil {
+		stats.Kernel = *kernelStats
+	}
+	stats.Watchdog = WatchdogStats{Devices: map[string]WatchdogDevice{
+		"watchdog0": {Identity: "Simulated Watchdog", Timeout: 60},
+	}}
+
+	s.sampled = true
+}
+
+// logKernel adds a kernel message, raising the event of the rule it matches
+func (s *simulator) logKernel(sm *SystemMonitor, now time.Time, uptime float64, priority int, text string) {
+	if sm.kmsg == nil {
+		return
+	}
+	s.seq++
+	msg := KernelMessage{
+		Time:     now,
+		Uptime:   uptime,
+		Seq:      s.seq,
+		Priority: priority,
+		Level:    kmsgPriorities[priority],
+		Facility: "kern",
+		Message:  text,
+	}
+	if event, matched := sm.kmsg.add(msg); matched {
+		sm.emitEvent(event)
+	}
+}
+
+// gpio toggles the even pins as outputs at different rates, and presses
+// the odd pins as active-low buttons now and then
+func (s *simulator) gpio(t float64) GPIOStats {
+	stats := GPIOStats{Pins: make(map[string]GPIOState, s.cfg.GPIOPins)}
+	toggle := s.cfg.GPIOToggle.Seconds()
+	for i := 0; i < s.cfg.GPIOPins; i++ {
+		name := fmt.Sprintf("gpio%d", 17+i)
+		state := GPIOState{Pin: name, Mode: "out"}
+		if i%2 == 0 {
+			state.Value = int(t/(toggle*float64(i/2+1))) % 2
+		} else {
+			state.Mode = "in"
+			state.Value = 1
+			if math.Mod(t+13*float64(i), 37) < 2 {
+				state.Value = 0
+			}
+		}
+		stats.Pins[name] = state
+	}
+	return stats
+}
+
+// interrupts derives the interrupt and softirq rates from the load, the
+// network bursts and the storage activity
+func (s *simulator) interrupts(sm *SystemMonitor, cpuUsage, burst float64, linkDown bool, blocked float64) InterruptStats {
+	cores := float64(s.cfg.Cores)
+	netRate := 300 + 9000*burst
+	if linkDown {
+		netRate = 0
+	}
+	mmcRate := 50 + float64(s.cfg.DiskFillRate)/65536
+	timerRate := 250 * cores
+
+	top := []IRQRate{
+		{IRQ: "58", Devices: "eth0", Rate: netRate * 0.6},
+		{IRQ: "56", Devices: "mmc0", Rate: mmcRate},
+		{IRQ: "11", Devices: "arch_timer", Rate: timerRate},
+		{IRQ: "60", Devices: "ttymxc1", Rate: 5},
+	}
+	var total float64
+	for i := range top {
+		top[i].Rate = round2(top[i].Rate * (1 + 0.05*s.rng.NormFloat64()))
+		top[i].Rate = math.Max(0, top[i].Rate)
+		top[i].PerCPU = make([]float64, s.cfg.Cores)
+		for cpu := range top[i].PerCPU {
+			top[i].PerCPU[cpu] = round2(top[i].Rate / cores)
+		}
+		total += top[i].Rate
+	}
+	sort.Slice(top, func(i, j int) bool { return top[i].Rate > top[j].Rate })
+	if limit := sm.cfg.TopInterrupts; limit > 0 && len(top) > limit {
+		top = top[:limit]
+	}
+
+	return InterruptStats{
+		Total: total,
+		Top:   top,
+		SoftIRQs: map[string]float64{
+			"TIMER":  timerRate,
+			"NET_RX": netRate * 0.8,
+			"NET_TX": netRate * 0.3,
+			"BLOCK":  mmcRate,
+			"SCHED":  round2(cpuUsage * 10),
+			"RCU":    round2(100 + cpuUsage*5),
+		},
+		ContextSwitches: round2(1500 + cpuUsage*60),
+		Forks:           round2(5 + cpuUsage/10),
+		ProcsRunning:    uint64(1 + cpuUsage*cores/100),
+		ProcsBlocked:    uint64(blocked),
+	}
+}
+
+// vm raises the reclaim, swap and OOM rates as a memory leak grows
+func (s *simulator) vm(cpuUsage, leak, dt float64) VMStats {
+	stats := VMStats{
+		PageFaults:    round2(400 + cpuUsage*20),
+		MajorFaults:   round2(math.Abs(2 + s.rng.NormFloat64())),
+		OOMKillsTotal: s.oomKills,
+	}
+	if leak > 0.7 {
+		pressure := (leak - 0.7) / 0.3
+		stats.PageScan = round2(5000 * pressure)
+		stats.PageSteal = round2(4000 * pressure)
+		stats.SwapIn = round2(200 * pressure)
+		stats.SwapOut = round2(400 * pressure)
+		stats.MajorFaults += round2(300 * pressure)
+		stats.AllocStalls = round2(20 * pressure)
+	}
+	return stats
+}
+
+// limits derives the kernel table usage from the load and the bursts
+func (s *simulator) limits(cpuUsage, burst float64) LimitsStats {
+	usage := func(used, max uint64) LimitUsage {
+		return LimitUsage{Used: used, Max: max, Percent: float64(used) / float64(max) * 100}
+	}
+	established := uint64(4 + 40*burst)
+	return LimitsStats{
+		Files:   usage(uint64(1500+cpuUsage*5), 100000),
+		PIDs:    usage(uint64(180+cpuUsage/2), 32768),
+		Entropy: usage(256, 256),
+		Sockets: SocketStats{
+			Used:      40 + established,
+			TCPInUse:  6 + established,
+			TCPAlloc:  8 + established,
+			TCPMemory: (2 + established) * 4096,
+			UDPInUse:  3,
+		},
+		TCPStates: map[string]uint64{
+			"ESTABLISHED": established,
+			"LISTEN":      6,
+			"TIME_WAIT":   uint64(2 + 20*burst),
+		},
+	}
+}
+
+// simulatedNetConfig is a board with one Ethernet port on a home network
+func simulatedNetConfig(linkDown bool) NetConfigStats {
+	stats := NetConfigStats{
+		Interfaces: []InterfaceAddrs{
+			{Name: "lo", Up: true, Addresses: []string{"127.0.0.1/8", "::1/128"}},
+			{Name: "eth0", Up: !linkDown},
+		},
+		Routes: []Route{
+			{Family: "inet", Destination: "127.0.0.0/8", Interface: "lo"},
+		},
+		DNSServers:    []string{"192.168.1.1"},
+		SearchDomains: []string{"lan"},
+	}
+	if !linkDown {
+		stats.Interfaces[1].Addresses = []string{"192.168.1.50/24", "fe80::2/64"}
+		stats.Routes = append(stats.Routes,
+			Route{Family: "inet", Destination: "0.0.0.0/0", Gateway: "192.168.1.1", Interface: "eth0", Metric: 100},
+			Route{Family: "inet", Destination: "192.168.1.0/24", Interface: "eth0", Metric: 100})
+		stats.DefaultRoute = true
+		stats.DefaultGateway = "192.168.1.1"
+		stats.DefaultInterface = "eth0"
+	}
+	return stats
+}
+
+// storage reports an eMMC in good health and the bytes written to it
+// against the configured daily budget
+func (s *simulator) storage(sm *SystemMonitor, hour float64) (StorageStats, WriteStats) {
+	written := uint64(s.written)
+	health := FlashHealth{
+		Device:            "mmcblk0",
+		Type:              "MMC",
+		Model:             "SIM08G",
+		LifeTimeA:         2,
+		LifeTimeB:         1,
+		PreEOL:            1,
+		PreEOLState:       preEOLState(1),
+		WearPercent:       15,
+		BytesWrittenBoot:  written,
+		BytesWrittenTotal: 120<<30 + written,
+		FirstSeen:         s.started,
+	}
+
+	volume := WriteVolume{Hour: uint64(hour), Today: written, Budget: sm.cfg.WriteBudget.Daily}
+	if volume.Budget > 0 {
+		volume.BudgetPercent = float64(volume.Today) / float64(volume.Budget) * 100
+		volume.OverBudget = volume.Today > volume.Budget
+	}
+	return StorageStats{Devices: map[string]FlashHealth{"mmcblk0": health}},
+		WriteStats{
+			Devices: map[string]WriteVolume{"mmcblk0": volume},
+			Mounts:  map[string]WriteVolume{"/": volume},
+		}
+}
+
+// round2 rounds to two decimals
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
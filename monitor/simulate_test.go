@@ -0,0 +1,145 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newTestSimulation(scenarios []SimulationScenario) *SystemMonitor {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	cfg := DefaultConfig()
+	cfg.History.Enabled = false
+	cfg.Simulation.Enabled = true
+	cfg.Simulation.Seed = 1
+	cfg.Simulation.Scenarios = scenarios
+	return NewSystemMonitor(log, cfg)
+}
+
+// simulateAt returns the stats of the simulation at simulated time t
+func simulateAt(t *testing.T, sm *SystemMonitor, at time.Duration) *SystemStats {
+	sm.simulator.started = time.Now().Add(-at)
+	stats, err := sm.GetSystemStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func TestSimulationScenarioTiming(t *testing.T) {
+	sc := SimulationScenario{Start: time.Minute, Duration: 30 * time.Second, Repeat: 10 * time.Minute}
+
+	tests := []struct {
+		at       time.Duration
+		progress float64
+		active   bool
+		run      int // last run at least halfway through
+	}{
+		{0, 0, false, -1},
+		{75 * time.Second, 0.5, true, 0},
+		{5 * time.Minute, 0, false, 0},
+		{11*time.Minute + 15*time.Second, 0.5, true, 1},
+	}
+	for _, tt := range tests {
+		progress, active := sc.occurrence(tt.at.Seconds())
+		if progress != tt.progress || active != tt.active {
+			t.Errorf("occurrence at %s = %v, %v, want %v, %v", tt.at, progress, active, tt.progress, tt.active)
+		}
+		if run := sc.lastRun(tt.at.Seconds(), 15); run != tt.run {
+			t.Errorf("lastRun at %s = %d, want %d", tt.at, run, tt.run)
+		}
+	}
+}
+
+func TestSimulationFillsEveryPanel(t *testing.T) {
+	sm := newTestSimulation([]SimulationScenario{})
+	stats := simulateAt(t, sm, time.Minute)
+
+	if len(stats.Errors) > 0 {
+		t.Errorf("errors = %v, want none", stats.Errors)
+	}
+	if stats.CPU.UsagePercent <= 0 || len(stats.CPU.LoadAverage) != 3 || stats.CPU.Frequency == 0 {
+		t.Errorf("cpu = %+v", stats.CPU)
+	}
+	if stats.Temperature.CPU < 25 || stats.Temperature.CPU > 80 {
+		t.Errorf("cpu temperature = %v", stats.Temperature.CPU)
+	}
+	if len(stats.GPIO.Pins) != 4 {
+		t.Errorf("gpio pins = %v, want 4", stats.GPIO.Pins)
+	}
+	if stats.Memory.Total != 1<<30 || stats.Disk.Total != 8<<30 || stats.Disk.ReadOnly {
+		t.Errorf("memory = %+v, disk = %+v", stats.Memory, stats.Disk)
+	}
+	if !stats.NetConfig.DefaultRoute || len(stats.Interrupts.Top) == 0 || len(stats.Storage.Devices) != 1 {
+		t.Errorf("netconfig = %+v, interrupts = %+v", stats.NetConfig, stats.Interrupts)
+	}
+	if stats.Health.Level != HealthOK {
+		t.Errorf("health = %+v, want ok without faults", stats.Health)
+	}
+}
+
+func TestSimulationFaults(t *testing.T) {
+	sm := newTestSimulation([]SimulationScenario{
+		{Fault: "memory_leak", Start: 0, Duration: 10 * time.Minute},
+		{Fault: "disk_full", Start: 0, Duration: 10 * time.Minute},
+		{Fault: "read_only", Start: 0, Duration: 10 * time.Minute},
+		{Fault: "network_down", Start: 0, Duration: 10 * time.Minute},
+		{Fault: "no_such_fault", Start: 0, Duration: 10 * time.Minute},
+	})
+	if got := len(sm.simulator.scenarios); got != 4 {
+		t.Errorf("scenarios = %d, want the unknown fault skipped", got)
+	}
+
+	stats := simulateAt(t, sm, 9*time.Minute)
+	if stats.Memory.UsagePercent < 85 {
+		t.Errorf("memory at %.1f%%, want the leak near its peak", stats.Memory.UsagePercent)
+	}
+	if stats.Disk.UsagePercent < 99 || !stats.Disk.ReadOnly {
+		t.Errorf("disk = %+v, want full and read-only", stats.Disk)
+	}
+	if stats.NetConfig.DefaultRoute || stats.NetConfig.Interfaces[1].Up {
+		t.Errorf("netconfig = %+v, want eth0 down", stats.NetConfig)
+	}
+	if stats.Health.Level != HealthCritical {
+		t.Errorf("health = %+v, want critical", stats.Health)
+	}
+
+	// The OOM kill ends the leak and raises the kernel log rule's event
+	stats = simulateAt(t, sm, 9*time.Minute+40*time.Second)
+	if stats.Memory.UsagePercent > 50 || stats.VM.OOMKillsTotal != 1 {
+		t.Errorf("memory at %.1f%% after %d OOM kills, want the leak gone", stats.Memory.UsagePercent, stats.VM.OOMKillsTotal)
+	}
+	var names []string
+	for _, event := range sm.RecentEvents() {
+		names = append(names, event.Name)
+	}
+	if len(names) != 1 || names[0] != "oom_kill" {
+		t.Errorf("events = %v, want one oom_kill", names)
+	}
+}
+
+func TestSimulationSkipsProbesAndPlugins(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+
+	cfg := DefaultConfig()
+	cfg.History.Enabled = false
+	cfg.Simulation.Enabled = true
+	cfg.Probes = []ProbeConfig{{Name: "gateway", Type: "tcp", Target: "127.0.0.1:1"}}
+	cfg.Plugins = []PluginConfig{{Name: "sensor", Command: "false"}}
+	sm := NewSystemMonitor(log, cfg)
+	sm.Start()
+	defer sm.Stop()
+
+	stats, err := sm.LatestStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Probes) != 0 || len(stats.Plugins) != 0 || len(stats.Custom) != 0 {
+		t.Errorf("probes = %v, plugins = %v, custom = %v, want none in a simulation", stats.Probes, stats.Plugins, stats.Custom)
+	}
+}
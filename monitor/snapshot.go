@@ -0,0 +1,383 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SnapshotFormats lists the output formats of a SnapshotWriter
+var SnapshotFormats = []string{"json", "yaml", "table", "prometheus"}
+
+// SnapshotWriter prints stats in one of the SnapshotFormats, limited to the
+// selected fields. Fields are dotted JSON names such as "cpu" or
+// "temperature.cpu".
+type SnapshotWriter struct {
+	w       io.Writer
+	format  string
+	fields  []string
+	written int
+}
+
+// NewSnapshotWriter checks the format and that every field starts with a
+// top-level field of the stats
+func NewSnapshotWriter(w io.Writer, format string, fields []string) (*SnapshotWriter, error) {
+	known := false
+	for _, f := range SnapshotFormats {
+		known = known || f == format
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown format %q, use one of %s", format, strings.Join(SnapshotFormats, ", "))
+	}
+
+	top := statsFieldNames()
+	for _, field := range fields {
+		name := strings.Split(field, ".")[0]
+		found := false
+		for _, t := range top {
+			found = found || t == name
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown field %q, use one of %s", field, strings.Join(top, ", "))
+		}
+	}
+	return &SnapshotWriter{w: w, format: format, fields: fields}, nil
+}
+
+// statsFieldNames returns the JSON names of the fields of SystemStats
+func statsFieldNames() []string {
+	var names []string
+	t := reflect.TypeOf(SystemStats{})
+	for i := 0; i < t.NumField(); i++ {
+		names = append(names, strings.Split(t.Field(i).Tag.Get("json"), ",")[0])
+	}
+	return names
+}
+
+// Write prints one snapshot, separated from the previous one by a YAML
+// document marker or a blank line
+func (sw *SnapshotWriter) Write(stats *SystemStats) error {
+	if sw.written > 0 {
+		separator := "\n"
+		if sw.format == "yaml" {
+			separator = "---\n"
+		}
+		if _, err := io.WriteString(sw.w, separator); err != nil {
+			return err
+		}
+	}
+	sw.written++
+
+	if sw.format == "prometheus" {
+		return writePrometheus(sw.w, stats, sw.fields)
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	if len(sw.fields) > 0 {
+		data = selectJSONFields(data, sw.fields)
+	}
+
+	switch sw.format {
+	case "yaml":
+		return writeYAML(sw.w, data)
+	case "table":
+		return writeTable(sw.w, data)
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, data, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = sw.w.Write(out.Bytes())
+	return err
+}
+
+// fieldTree holds the selected values, keeping the order of the fields
+type fieldTree struct {
+	keys     []string
+	children map[string]*fieldTree
+	value    json.RawMessage
+}
+
+// selectJSONFields keeps the fields of a JSON object named by dotted paths.
+// Paths that lead nowhere are left out.
+func selectJSONFields(data []byte, fields []string) []byte {
+	root := &fieldTree{children: make(map[string]*fieldTree)}
+	for _, field := range fields {
+		value, ok := lookupJSON(data, strings.Split(field, "."))
+		if !ok {
+			continue
+		}
+		node := root
+		for _, key := range strings.Split(field, ".") {
+			child, exists := node.children[key]
+			if !exists {
+				child = &fieldTree{children: make(map[string]*fieldTree)}
+				node.children[key] = child
+				node.keys = append(node.keys, key)
+			}
+			node = child
+		}
+		node.value = value
+	}
+
+	var out bytes.Buffer
+	root.encode(&out)
+	return out.Bytes()
+}
+
+// lookupJSON returns the value at path in a JSON object
+func lookupJSON(data []byte, path []string) (json.RawMessage, bool) {
+	for _, key := range path {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			return nil, false
+		}
+		value, ok := object[key]
+		if !ok {
+			return nil, false
+		}
+		data = value
+	}
+	return data, true
+}
+
+// encode writes the tree as a JSON object
+func (ft *fieldTree) encode(out *bytes.Buffer) {
+	if ft.value != nil {
+		out.Write(ft.value)
+		return
+	}
+	out.WriteByte('{')
+	for i, key := range ft.keys {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		name, _ := json.Marshal(key)
+		out.Write(name)
+		out.WriteByte(':')
+		ft.children[key].encode(out)
+	}
+	out.WriteByte('}')
+}
+
+// writeYAML converts JSON to YAML in block style, keeping the field order
+func writeYAML(w io.Writer, data []byte) error {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	clearYAMLStyle(&node)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// clearYAMLStyle drops the flow and quoting style JSON parses into, so the
+// encoder picks plain block style
+func clearYAMLStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearYAMLStyle(child)
+	}
+}
+
+// writeTable prints every leaf of a JSON document as a dotted name and its
+// value, in document order
+func writeTable(w io.Writer, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	var walk func(prefix string) error
+	walk = func(prefix string) error {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case json.Delim('{'):
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := walk(metricName(prefix, key.(string))); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		case json.Delim('['):
+			for i := 0; dec.More(); i++ {
+				if err := walk(metricName(prefix, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			_, err = dec.Token()
+			return err
+		}
+
+		if token == nil {
+			token = "-"
+		}
+		_, err = fmt.Fprintf(tw, "%s\t%v\n", prefix, token)
+		return err
+	}
+	if err := walk(""); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// prometheusLabels names the label that holds the keys or indexes of the
+// instances below a metric path, where "*" stands for an instance. Other
+// instances go in a label named "key".
+var prometheusLabels = map[string]string{
+	"cpu.load_average":    "index",
+	"gpio.pins":           "pin",
+	"storage.devices":     "device",
+	"writes.devices":      "device",
+	"writes.mounts":       "mount",
+	"kernel.counters":     "rule",
+	"kernel.recent":       "rule",
+	"interrupts.softirqs": "softirq",
+	"limits.tcp_states":   "state",
+	"probes":              "probe",
+	"services":            "service",
+	"custom":              "plugin",
+	"custom.*":            "metric",
+	"plugins":             "plugin",
+	"files":               "file",
+	"derived":             "metric",
+	"watchdog.devices":    "device",
+}
+
+// prometheusSeries is one line of the Prometheus text format
+type prometheusSeries struct {
+	name   string
+	labels string // rendered label set, e.g. {device="sda"}
+	value  float64
+	metric string // dotted metric name
+}
+
+// writePrometheus prints the metrics of the stats in the Prometheus text
+// format. Names are emmon_ and the dotted field names with other characters
+// replaced by underscores; map keys and list indexes become labels. Two
+// metrics that end up as the same series are an error.
+func writePrometheus(w io.Writer, stats *SystemStats, fields []string) error {
+	metrics := stats.Metrics()
+
+	var series []prometheusSeries
+	walkMetrics(nil, reflect.ValueOf(*stats), func(path []metricPart, value float64) {
+		name := joinMetricPath(path)
+		if _, ok := metrics[name]; !ok {
+			return
+		}
+		if len(fields) > 0 && !matchesField(name, fields) {
+			return
+		}
+		series = append(series, newPrometheusSeries(path, value, name))
+	})
+
+	data, err := formatPrometheus(series)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// formatPrometheus sorts the series into families and formats them
+func formatPrometheus(series []prometheusSeries) ([]byte, error) {
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].name != series[j].name {
+			return series[i].name < series[j].name
+		}
+		return series[i].labels < series[j].labels
+	})
+
+	var out bytes.Buffer
+	for i, s := range series {
+		if i > 0 && s.name == series[i-1].name && s.labels == series[i-1].labels {
+			return nil, fmt.Errorf("metrics %s and %s are both %s%s", series[i-1].metric, s.metric, s.name, s.labels)
+		}
+		if i == 0 || s.name != series[i-1].name {
+			fmt.Fprintf(&out, "# TYPE %s gauge\n", s.name)
+		}
+		fmt.Fprintf(&out, "%s%s %s\n", s.name, s.labels, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+	return out.Bytes(), nil
+}
+
+// newPrometheusSeries names a metric path, moving its instances into labels
+func newPrometheusSeries(path []metricPart, value float64, metric string) prometheusSeries {
+	var names, pattern, labels []string
+	for _, part := range path {
+		if !part.instance {
+			names = append(names, part.name)
+			pattern = append(pattern, part.name)
+			continue
+		}
+		label, ok := prometheusLabels[strings.Join(pattern, ".")]
+		if !ok {
+			label = "key"
+		}
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", label, prometheusEscaper.Replace(part.name)))
+		pattern = append(pattern, "*")
+	}
+
+	s := prometheusSeries{name: prometheusName(strings.Join(names, ".")), value: value, metric: metric}
+	if len(labels) > 0 {
+		s.labels = "{" + strings.Join(labels, ",") + "}"
+	}
+	return s
+}
+
+// prometheusEscaper escapes label values for the Prometheus text format
+var prometheusEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// matchesField reports whether a dotted metric name is one of the fields or
+// lies below one
+func matchesField(name string, fields []string) bool {
+	for _, field := range fields {
+		if name == field || strings.HasPrefix(name, field+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// prometheusName turns a dotted metric name into a valid Prometheus name
+func prometheusName(name string) string {
+	var b strings.Builder
+	b.WriteString("emmon")
+	separate := true
+	for _, r := range name {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			if separate {
+				b.WriteByte('_')
+				separate = false
+			}
+			b.WriteRune(r)
+			continue
+		}
+		separate = true
+	}
+	return b.String()
+}
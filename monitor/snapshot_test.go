@@ -0,0 +1,173 @@
+package monitor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testSnapshotStats() *SystemStats {
+	return &SystemStats{
+		Timestamp:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		CPU:         CPUStats{UsagePercent: 12.5, LoadAverage: []float64{0.5, 0.25, 0.125}},
+		Temperature: TempStats{CPU: 48, Ambient: 21},
+		Writes:      WriteStats{Mounts: map[string]WriteVolume{"/": {Today: 1024}}},
+		Health:      HealthStatus{Level: HealthWarning, Reasons: []string{"temperature.cpu is high"}},
+	}
+}
+
+func writeSnapshot(t *testing.T, format string, fields []string, count int) string {
+	var out bytes.Buffer
+	sw, err := NewSnapshotWriter(&out, format, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < count; i++ {
+		if err := sw.Write(testSnapshotStats()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out.String()
+}
+
+func TestSnapshotJSONFields(t *testing.T) {
+	got := writeSnapshot(t, "json", []string{"temperature.cpu", "cpu", "temperature.ambient", "probes"}, 1)
+	want := `{
+  "temperature": {
+    "cpu": 48,
+    "ambient": 21
+  },
+  "cpu": {
+    "usage_percent": 12.5,
+    "load_average": [
+      0.5,
+      0.25,
+      0.125
+    ],
+    "temperature": 0,
+    "frequency": 0
+  }
+}
+`
+	if got != want {
+		t.Errorf("json =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSnapshotYAML(t *testing.T) {
+	got := writeSnapshot(t, "yaml", []string{"cpu.load_average", "health"}, 2)
+	doc := `cpu:
+  load_average:
+    - 0.5
+    - 0.25
+    - 0.125
+health:
+  level: warning
+  reasons:
+    - temperature.cpu is high
+`
+	if want := doc + "---\n" + doc; got != want {
+		t.Errorf("yaml =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSnapshotTable(t *testing.T) {
+	got := writeSnapshot(t, "table", []string{"timestamp", "cpu.load_average", "writes.mounts"}, 1)
+	want := `timestamp                 2024-05-01T12:00:00Z
+cpu.load_average.0        0.5
+cpu.load_average.1        0.25
+cpu.load_average.2        0.125
+writes.mounts./.hour      0
+writes.mounts./.today     1024
+writes.mounts./.budget_percent  0
+writes.mounts./.over_budget     false
+writes.mounts./.hours           -
+writes.mounts./.days            -
+`
+	// tabwriter aligns each block of lines with the same number of cells
+	got = strings.Join(strings.Fields(got), " ")
+	if want = strings.Join(strings.Fields(want), " "); got != want {
+		t.Errorf("table = %s\nwant %s", got, want)
+	}
+}
+
+func TestSnapshotPrometheus(t *testing.T) {
+	got := writeSnapshot(t, "prometheus", []string{"temperature", "writes", "cpu.load_average"}, 1)
+	want := `# TYPE emmon_cpu_load_average gauge
+emmon_cpu_load_average{index="0"} 0.5
+emmon_cpu_load_average{index="1"} 0.25
+emmon_cpu_load_average{index="2"} 0.125
+# TYPE emmon_temperature_ambient gauge
+emmon_temperature_ambient 21
+# TYPE emmon_temperature_board gauge
+emmon_temperature_board 0
+# TYPE emmon_temperature_cpu gauge
+emmon_temperature_cpu 48
+# TYPE emmon_temperature_gpu gauge
+emmon_temperature_gpu 0
+# TYPE emmon_writes_mounts_budget gauge
+emmon_writes_mounts_budget{mount="/"} 0
+# TYPE emmon_writes_mounts_budget_percent gauge
+emmon_writes_mounts_budget_percent{mount="/"} 0
+# TYPE emmon_writes_mounts_hour gauge
+emmon_writes_mounts_hour{mount="/"} 0
+# TYPE emmon_writes_mounts_over_budget gauge
+emmon_writes_mounts_over_budget{mount="/"} 0
+# TYPE emmon_writes_mounts_today gauge
+emmon_writes_mounts_today{mount="/"} 1024
+`
+	if got != want {
+		t.Errorf("prometheus =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestPrometheusLabels(t *testing.T) {
+	stats := &SystemStats{
+		Custom: map[string]map[string]float64{"sensors": {"rssi": -61}},
+		Probes: map[string]ProbeResult{`gw "1"`: {Up: true, Checks: 1}},
+	}
+	var out bytes.Buffer
+	if err := writePrometheus(&out, stats, []string{"custom", "probes"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`emmon_custom{plugin="sensors",metric="rssi"} -61`,
+		`emmon_probes_up{probe="gw \"1\""} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("prometheus output lacks %s:\n%s", line, out.String())
+		}
+	}
+
+	series := []prometheusSeries{
+		newPrometheusSeries([]metricPart{{name: "net"}, {name: "rx-bytes"}}, 1, "net.rx-bytes"),
+		newPrometheusSeries([]metricPart{{name: "net"}, {name: "rx_bytes"}}, 2, "net.rx_bytes"),
+	}
+	if _, err := formatPrometheus(series); err == nil {
+		t.Error("expected an error for metrics that sanitise to the same series")
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	tests := map[string]string{
+		"cpu.usage_percent":        "emmon_cpu_usage_percent",
+		"gpio.pins.gpio17.value":   "emmon_gpio_pins_gpio17_value",
+		"services.my-app.up":       "emmon_services_my_app_up",
+		"storage.devices.sda.wear": "emmon_storage_devices_sda_wear",
+	}
+	for name, want := range tests {
+		if got := prometheusName(name); got != want {
+			t.Errorf("prometheusName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSnapshotWriterRejectsUnknownFormatAndField(t *testing.T) {
+	if _, err := NewSnapshotWriter(&bytes.Buffer{}, "xml", nil); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if _, err := NewSnapshotWriter(&bytes.Buffer{}, "json", []string{"cpu", "cpux.usage"}); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
@@ -0,0 +1,324 @@
+package monitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	mmcHostPath = "/sys/class/mmc_host"
+	blockPath   = "/sys/block"
+	bootIDPath  = "/proc/sys/kernel/random/boot_id"
+)
+
+// StorageStats represents the health of the flash storage devices
+type StorageStats struct {
+	Devices map[string]FlashHealth `json:"devices"`
+}
+
+// FlashHealth represents the wear state of one eMMC or SD device
+type FlashHealth struct {
+	Device            string    `json:"device"`
+	Type              string    `json:"type"` // "MMC", "SD", ...
+	Model             string    `json:"model"`
+	Serial            string    `json:"serial,omitempty"`
+	LifeTimeA         int       `json:"life_time_a"` // 1-10 in 10% steps of wear, 11 past end of life, 0 unknown
+	LifeTimeB         int       `json:"life_time_b"`
+	PreEOL            int       `json:"pre_eol"` // 1 normal, 2 warning, 3 urgent, 0 unknown
+	PreEOLState       string    `json:"pre_eol_state"`
+	WearPercent       float64   `json:"wear_percent"`
+	BytesWrittenBoot  uint64    `json:"bytes_written_boot"`
+	BytesWrittenTotal uint64    `json:"bytes_written_total"` // since emmon first saw the device
+	FirstSeen         time.Time `json:"first_seen"`
+	DaysLeft          float64   `json:"days_left"`        // projected remaining lifetime, 0 if unknown
+	ProjectionBasis   string    `json:"projection_basis"` // "wear", "endurance" or empty
+	Error             string    `json:"error,omitempty"`  // why the wear attributes could not be read
+}
+
+// preEOLState describes the pre-EOL value reported by the device
+func preEOLState(preEOL int) string {
+	switch preEOL {
+	case 1:
+		return "normal"
+	case 2:
+		return "warning"
+	case 3:
+		return "urgent"
+	}
+	return "unknown"
+}
+
+// storageState is the part of the storage tracking persisted across reboots
+type storageState struct {
+	BootID  string                         `json:"boot_id"`
+	Devices map[string]*deviceStorageState `json:"devices"`
+}
+
+// deviceStorageState tracks the cumulative writes of one device
+type deviceStorageState struct {
+	FirstSeen     time.Time `json:"first_seen"`
+	FirstWear     float64   `json:"first_wear"`
+	PreviousBoots uint64    `json:"previous_boots"` // bytes written in earlier boots
+	ThisBoot      uint64    `json:"this_boot"`      // bytes written this boot at the last sample
+}
+
+// storageTracker keeps the persisted write counters of the flash devices
+type storageTracker struct {
+	mu        sync.Mutex
+	path      string
+	interval  time.Duration
+	endurance map[string]uint64
+	state     storageState
+	loaded    bool
+	readOnly  bool // another process owns the state, never save it
+	lastSave  time.Time
+}
+
+// newStorageTracker creates a tracker persisting its state to path
+func newStorageTracker(path string, cfg StorageConfig) *storageTracker {
+	return &storageTracker{
+		path:      path,
+		interval:  cfg.PersistInterval,
+		endurance: cfg.Endurance,
+	}
+}
+
+// getStorageStats collects the health of eMMC and SD devices
+func (sm *SystemMonitor) getStorageStats() (*StorageStats, error) {
+	stats := &StorageStats{
+		Devices: make(map[string]FlashHealth),
+	}
+
+	devices, err := readFlashDevices(mmcHostPath, blockPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return stats, nil // no MMC devices
+	}
+
+	diskstats, err := readDiskstats()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sm.storage.update(devices, diskstats, readBootID(), time.Now()); err != nil {
+		sm.log.Warnf("Failed to persist storage state: %v", err)
+	}
+
+	for _, device := range devices {
+		stats.Devices[device.Device] = device
+	}
+
+	return stats, nil
+}
+
+// update folds the current write counters into the persisted state, fills
+// in the cumulative figures and the lifetime projection of each device,
+// and saves the state when the persist interval has passed.
+func (st *storageTracker) update(devices []FlashHealth, diskstats map[string]diskstatsEntry, bootID string, now time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	// A state file that cannot be read is reported once; the counters start
+	// again and the next save replaces it
+	var loadErr error
+	if !st.loaded {
+		if err := loadState(st.path, &st.state); err != nil {
+			st.state = storageState{}
+			loadErr = fmt.Errorf("starting anew: %v", err)
+		}
+		st.loaded = true
+	}
+	if st.state.Devices == nil {
+		st.state.Devices = make(map[string]*deviceStorageState)
+	}
+
+	// After a reboot the kernel counters start again from zero
+	rebooted := st.state.BootID != bootID
+	if rebooted {
+		for _, dev := range st.state.Devices {
+			dev.PreviousBoots += dev.ThisBoot
+			dev.ThisBoot = 0
+		}
+		st.state.BootID = bootID
+	}
+
+	for i := range devices {
+		device := &devices[i]
+		dev, ok := st.state.Devices[device.Device]
+		if !ok {
+			dev = &deviceStorageState{FirstSeen: now, FirstWear: device.WearPercent}
+			st.state.Devices[device.Device] = dev
+		}
+
+		if entry, ok := diskstats[device.Device]; ok {
+			device.BytesWrittenBoot = entry.BytesWritten()
+			dev.ThisBoot = device.BytesWrittenBoot
+		}
+		device.BytesWrittenTotal = dev.PreviousBoots + dev.ThisBoot
+		device.FirstSeen = dev.FirstSeen
+
+		device.DaysLeft, device.ProjectionBasis = projectLifetime(device, dev, st.endurance[device.Device], now)
+	}
+
+	if loadErr != nil {
+		return loadErr
+	}
+	if st.readOnly || !rebooted && now.Sub(st.lastSave) < st.interval {
+		return nil
+	}
+	st.lastSave = now
+	return saveState(st.path, &st.state)
+}
+
+// save writes the state, if it was loaded, so a clean shutdown keeps the
+// writes since the last periodic save
+func (st *storageTracker) save(now time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if !st.loaded || st.readOnly {
+		return nil
+	}
+	st.lastSave = now
+	return saveState(st.path, &st.state)
+}
+
+// projectLifetime estimates the remaining lifetime of a device in days. It
+// prefers the wear reported by the device itself, which only moves in 10%
+// steps, and falls back to the configured endurance in bytes.
+func projectLifetime(device *FlashHealth, dev *deviceStorageState, endurance uint64, now time.Time) (float64, string) {
+	elapsedDays := now.Sub(dev.FirstSeen).Hours() / 24
+	if elapsedDays <= 0 {
+		return 0, ""
+	}
+
+	if worn := device.WearPercent - dev.FirstWear; worn > 0 {
+		perDay := worn / elapsedDays
+		return (100 - device.WearPercent) / perDay, "wear"
+	}
+
+	if endurance > 0 && device.BytesWrittenTotal > 0 {
+		perDay := float64(device.BytesWrittenTotal) / elapsedDays
+		remaining := float64(endurance) - float64(device.BytesWrittenTotal)
+		if remaining < 0 {
+			remaining = 0
+		}
+		return remaining / perDay, "endurance"
+	}
+
+	return 0, ""
+}
+
+// readFlashDevices reads the health attributes of every MMC card with a
+// block device. Cards are found under the mmc_host class directory, with
+// /sys/block/mmcblk*/device as a fallback for kernels that lack the class.
+func readFlashDevices(hostPath, blockPath string) ([]FlashHealth, error) {
+	cards := make(map[string]string) // block device -> card directory
+
+	hostCards, err := filepath.Glob(filepath.Join(hostPath, "*", "mmc*:*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, card := range hostCards {
+		blocks, err := ioutil.ReadDir(filepath.Join(card, "block"))
+		if err != nil {
+			continue // SDIO cards and the like have no block device
+		}
+		for _, block := range blocks {
+			cards[block.Name()] = card
+		}
+	}
+
+	blockDevices, err := filepath.Glob(filepath.Join(blockPath, "mmcblk*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range blockDevices {
+		name := filepath.Base(block)
+		if strings.Contains(name, "boot") || strings.Contains(name, "rpmb") {
+			continue // hardware partitions of a card already listed
+		}
+		if _, ok := cards[name]; !ok {
+			cards[name] = filepath.Join(block, "device")
+		}
+	}
+
+	// A card with unreadable attributes only marks its own entry
+	var devices []FlashHealth
+	for name, card := range cards {
+		health, err := readFlashHealth(card)
+		if err != nil {
+			health.Error = err.Error()
+		}
+		health.Device = name
+		devices = append(devices, health)
+	}
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Device < devices[j].Device })
+	return devices, nil
+}
+
+// readFlashHealth reads the health attributes of an MMC card directory
+// (also reachable as /sys/block/mmcblkN/device). life_time and pre_eol_info
+// only exist on eMMC 5.0+ devices and are left at zero otherwise.
+func readFlashHealth(cardDir string) (FlashHealth, error) {
+	health := FlashHealth{
+		Type:   readAttrString(cardDir, "type"),
+		Model:  readAttrString(cardDir, "name"),
+		Serial: readAttrString(cardDir, "serial"),
+	}
+
+	if lifeTime := strings.Fields(readAttrString(cardDir, "life_time")); len(lifeTime) == 2 {
+		a, errA := strconv.ParseInt(lifeTime[0], 0, 32)
+		b, errB := strconv.ParseInt(lifeTime[1], 0, 32)
+		if errA != nil || errB != nil {
+			return health, fmt.Errorf("invalid life_time %q", lifeTime)
+		}
+		health.LifeTimeA, health.LifeTimeB = int(a), int(b)
+	}
+
+	if preEOL := readAttrString(cardDir, "pre_eol_info"); preEOL != "" {
+		v, err := strconv.ParseInt(preEOL, 0, 32)
+		if err != nil {
+			return health, fmt.Errorf("invalid pre_eol_info %q", preEOL)
+		}
+		health.PreEOL = int(v)
+	}
+	health.PreEOLState = preEOLState(health.PreEOL)
+
+	// Each life_time step is 10% of the rated life; report the upper bound
+	worst := health.LifeTimeA
+	if health.LifeTimeB > worst {
+		worst = health.LifeTimeB
+	}
+	if worst > 0 {
+		health.WearPercent = float64(worst) * 10
+		if health.WearPercent > 100 {
+			health.WearPercent = 100
+		}
+	}
+
+	return health, nil
+}
+
+// readBootID returns the kernel's random boot ID, which changes on every boot
+func readBootID() string {
+	return readAttrString(filepath.Dir(bootIDPath), filepath.Base(bootIDPath))
+}
+
+// readAttrString reads a sysfs attribute, returning an empty string if it is missing
+func readAttrString(dir, name string) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
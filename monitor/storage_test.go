@@ -0,0 +1,198 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseDiskstats(t *testing.T) {
+	input := `   8       0 sda 1000 10 20000 300 500 5 8000 200 0 400 500 0 0 0 0
+ 179       0 mmcblk0 200 0 4000 10 100 0 2048 30 0 40 40 0 0 0 0
+`
+	entries, err := parseDiskstats(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseDiskstats: %v", err)
+	}
+	mmc, ok := entries["mmcblk0"]
+	if !ok {
+		t.Fatal("mmcblk0 missing")
+	}
+	if mmc.Writes != 100 || mmc.BytesWritten() != 2048*512 {
+		t.Errorf("unexpected mmcblk0 counters: %+v", mmc)
+	}
+}
+
+func TestReadFlashDevices(t *testing.T) {
+	root := t.TempDir()
+	card := filepath.Join(root, "mmc_host", "mmc0", "mmc0:0001")
+	writeTestFile(t, filepath.Join(card, "type"), "MMC\n")
+	writeTestFile(t, filepath.Join(card, "name"), "8GTF4R\n")
+	writeTestFile(t, filepath.Join(card, "life_time"), "0x02 0x03\n")
+	writeTestFile(t, filepath.Join(card, "pre_eol_info"), "0x01\n")
+	writeTestFile(t, filepath.Join(card, "block", "mmcblk0", "size"), "15269888\n")
+
+	devices, err := readFlashDevices(filepath.Join(root, "mmc_host"), filepath.Join(root, "block"))
+	if err != nil {
+		t.Fatalf("readFlashDevices: %v", err)
+	}
+	if len(devices) != 1 {
+		t.Fatalf("expected 1 device, got %d", len(devices))
+	}
+
+	dev := devices[0]
+	if dev.Device != "mmcblk0" || dev.Model != "8GTF4R" {
+		t.Errorf("unexpected device: %+v", dev)
+	}
+	if dev.LifeTimeA != 2 || dev.LifeTimeB != 3 || dev.WearPercent != 30 {
+		t.Errorf("unexpected wear: %+v", dev)
+	}
+	if dev.PreEOLState != "normal" {
+		t.Errorf("pre-EOL state = %s, want normal", dev.PreEOLState)
+	}
+}
+
+func TestReadFlashDevicesKeepsHealthyCards(t *testing.T) {
+	root := t.TempDir()
+	emmc := filepath.Join(root, "mmc_host", "mmc0", "mmc0:0001")
+	writeTestFile(t, filepath.Join(emmc, "life_time"), "0x01 0x01\n")
+	writeTestFile(t, filepath.Join(emmc, "block", "mmcblk0", "size"), "15269888\n")
+	sd := filepath.Join(root, "mmc_host", "mmc1", "mmc1:aaaa")
+	writeTestFile(t, filepath.Join(sd, "life_time"), "garbage 0x01\n")
+	writeTestFile(t, filepath.Join(sd, "block", "mmcblk1", "size"), "62333952\n")
+
+	devices, err := readFlashDevices(filepath.Join(root, "mmc_host"), filepath.Join(root, "block"))
+	if err != nil {
+		t.Fatalf("readFlashDevices: %v", err)
+	}
+	if len(devices) != 2 || devices[0].Error != "" || devices[0].WearPercent != 10 || devices[1].Error == "" {
+		t.Fatalf("devices = %+v, want a healthy mmcblk0 and an error on mmcblk1", devices)
+	}
+
+	stats := &SystemStats{Storage: StorageStats{Devices: map[string]FlashHealth{"mmcblk1": devices[1]}}}
+	if _, ok := stats.Metrics()["storage.devices.mmcblk1.wear_percent"]; ok {
+		t.Error("expected the unreadable wear to be left out of the metrics")
+	}
+}
+
+func TestStorageTrackerAcrossReboots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	cfg := StorageConfig{Endurance: map[string]uint64{"mmcblk0": 1 << 40}}
+	start := time.Now()
+
+	devices := []FlashHealth{{Device: "mmcblk0"}}
+	counters := map[string]diskstatsEntry{"mmcblk0": {Name: "mmcblk0", WriteSectors: 1000}}
+
+	tracker := newStorageTracker(path, cfg)
+	if err := tracker.update(devices, counters, "boot-1", start); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	// A new process after a reboot starts from the persisted state
+	devices = []FlashHealth{{Device: "mmcblk0"}}
+	counters["mmcblk0"] = diskstatsEntry{Name: "mmcblk0", WriteSectors: 10}
+	tracker = newStorageTracker(path, cfg)
+	if err := tracker.update(devices, counters, "boot-2", start.Add(48*time.Hour)); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	dev := devices[0]
+	if dev.BytesWrittenBoot != 10*512 {
+		t.Errorf("bytes this boot = %d, want %d", dev.BytesWrittenBoot, 10*512)
+	}
+	if dev.BytesWrittenTotal != 1010*512 {
+		t.Errorf("bytes total = %d, want %d", dev.BytesWrittenTotal, 1010*512)
+	}
+	if dev.ProjectionBasis != "endurance" || dev.DaysLeft <= 0 {
+		t.Errorf("expected an endurance projection, got %v days (%q)", dev.DaysLeft, dev.ProjectionBasis)
+	}
+}
+
+func TestStorageTrackerSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	start := time.Now()
+	devices := []FlashHealth{{Device: "mmcblk0"}}
+	counters := map[string]diskstatsEntry{"mmcblk0": {Name: "mmcblk0", WriteSectors: 1000}}
+
+	tracker := newStorageTracker(path, StorageConfig{PersistInterval: time.Hour})
+	if err := tracker.save(start); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no state before the first update, got %v", err)
+	}
+
+	tracker.update(devices, counters, "boot-1", start)
+	counters["mmcblk0"] = diskstatsEntry{Name: "mmcblk0", WriteSectors: 3000}
+	tracker.update(devices, counters, "boot-1", start.Add(time.Minute))
+	if err := tracker.save(start.Add(time.Minute)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	// The writes since the last periodic save survive the shutdown
+	var state storageState
+	if err := loadState(path, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Devices["mmcblk0"].ThisBoot != 3000*512 {
+		t.Errorf("saved this boot = %d, want %d", state.Devices["mmcblk0"].ThisBoot, 3000*512)
+	}
+}
+
+func TestStorageTrackerReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	devices := []FlashHealth{{Device: "mmcblk0"}}
+	counters := map[string]diskstatsEntry{"mmcblk0": {Name: "mmcblk0", WriteSectors: 1000}}
+
+	tracker := newStorageTracker(path, StorageConfig{PersistInterval: time.Hour})
+	tracker.readOnly = true
+	if err := tracker.update(devices, counters, "boot-1", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if devices[0].BytesWrittenTotal != 1000*512 {
+		t.Errorf("total = %d, want %d", devices[0].BytesWrittenTotal, 1000*512)
+	}
+	if err := tracker.save(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected a read-only tracker to write no state, got %v", err)
+	}
+}
+
+func TestStorageTrackerCorruptState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	writeTestFile(t, path, "{not json")
+	devices := []FlashHealth{{Device: "mmcblk0"}}
+	counters := map[string]diskstatsEntry{"mmcblk0": {Name: "mmcblk0", WriteSectors: 1000}}
+
+	tracker := newStorageTracker(path, StorageConfig{PersistInterval: time.Hour})
+	if err := tracker.update(devices, counters, "boot-1", time.Now()); err == nil {
+		t.Error("expected the corrupt state to be reported")
+	}
+	if devices[0].BytesWrittenTotal != 1000*512 {
+		t.Errorf("total = %d, want %d", devices[0].BytesWrittenTotal, 1000*512)
+	}
+
+	// Only once: the tracker carries on from an empty state
+	if err := tracker.update(devices, counters, "boot-1", time.Now()); err != nil {
+		t.Errorf("update: %v", err)
+	}
+	var state storageState
+	if err := loadState(path, &state); err != nil || state.Devices["mmcblk0"] == nil {
+		t.Errorf("state = %+v, %v, want the corrupt file replaced", state, err)
+	}
+}
@@ -24,6 +24,28 @@ type SystemStats struct {
 	Disk        DiskStats `json:"disk"`
 	Temperature TempStats `json:"temperature"`
 	GPIO        GPIOStats `json:"gpio"`
+
+	Storage StorageStats `json:"storage"`
+	Writes  WriteStats   `json:"writes"`
+
+	Kernel     KernelLogStats `json:"kernel"`
+	Interrupts InterruptStats `json:"interrupts"`
+	VM         VMStats        `json:"vm"`
+	Limits     LimitsStats    `json:"limits"`
+	NetConfig  NetConfigStats `json:"netconfig"`
+
+	Probes   map[string]ProbeResult   `json:"probes,omitempty"`   // probe name -> result
+	Services map[string]ServiceStatus `json:"services,omitempty"` // service name -> status
+
+	Custom  map[string]map[string]float64 `json:"custom,omitempty"`  // plugin name -> metric -> value
+	Plugins map[string]PluginStatus       `json:"plugins,omitempty"` // plugin name -> last run
+	Files   map[string]FileMetric         `json:"files,omitempty"`   // file metric name -> value
+	Derived map[string]DerivedMetric      `json:"derived,omitempty"` // derived metric name -> value
+
+	Watchdog WatchdogStats `json:"watchdog"`
+
+	Health HealthStatus      `json:"health"`
+	Errors map[string]string `json:"errors,omitempty"` // collector name -> last error
 }
 
 // CPUStats represents CPU information
@@ -51,6 +73,7 @@ type DiskStats struct {
 	UsagePercent float64 `json:"usage_percent"`
 	IORead       uint64  `json:"io_read"`
 	IOWrite      uint64  `json:"io_write"`
+	ReadOnly     bool    `json:"read_only"` // the root filesystem is mounted read-only
 }
 
 // TempStats represents temperature information
@@ -75,27 +98,210 @@ type GPIOState struct {
 
 // SystemMonitor handles system monitoring
 type SystemMonitor struct {
-	log *logrus.Logger
+	log      *logrus.Logger
+	cfg      Config
+	storage  *storageTracker
+	writes   *writeTracker
+	kmsg     *kernelLog
+	events   *eventLog
+	probes   []*probe
+	services *serviceTracker
+	plugins  []*plugin
+	stop     chan struct{}
+
+	inventory  inventoryCache
+	interrupts interruptTracker
+	vmstat     vmstatTracker
+	netconfig  netConfigTracker
+
+	fileMetrics []*fileMetric
+	derived     *derivedMetrics
+	watchdog    *watchdog
+	history     *History
+
+	historyStore *historyStore
+	replay       *replayer
+	simulator    *simulator
+
+	sampler sampler
 }
 
 // NewSystemMonitor creates a new system monitor instance
-func NewSystemMonitor(log *logrus.Logger) *SystemMonitor {
-	return &SystemMonitor{
-		log: log,
+func NewSystemMonitor(log *logrus.Logger, cfg Config) *SystemMonitor {
+	if cfg.Health.Rules == nil {
+		cfg.Health.Rules = DefaultHealthRules()
+	}
+	cfg.Health.Rules = append(cfg.Health.Rules, fileMetricRules(cfg.FileMetrics)...)
+	cfg.Health.Rules = append(cfg.Health.Rules, derivedMetricRules(cfg.Derived)...)
+	if cfg.KernelLog.Rules == nil {
+		cfg.KernelLog.Rules = DefaultKernelLogRules()
+	}
+	if cfg.SampleInterval <= 0 {
+		cfg.SampleInterval = DefaultConfig().SampleInterval
+	}
+
+	sm := &SystemMonitor{
+		log:     log,
+		cfg:     cfg,
+		storage: newStorageTracker(filepath.Join(cfg.StateDir, "storage.json"), cfg.Storage),
+		writes:  newWriteTracker(filepath.Join(cfg.StateDir, "writes.json"), cfg.WriteBudget),
+		events:  newEventLog(cfg.EventBuffer),
+	}
+	sm.storage.readOnly = cfg.ReadOnlyState
+	sm.writes.readOnly = cfg.ReadOnlyState
+	if cfg.KernelLog.Enabled {
+		sm.kmsg = newKernelLog(cfg.KernelLog, sm)
+	}
+	sm.probes = newProbes(cfg.Probes, sm)
+	sm.services = newServiceTracker(cfg.Services, sm)
+	sm.plugins = newPlugins(cfg.Plugins, sm)
+	sm.fileMetrics = newFileMetrics(cfg.FileMetrics, sm)
+	sm.derived = newDerivedMetrics(cfg.Derived, sm)
+	sm.watchdog = newWatchdog(cfg.Watchdog, sm)
+	if cfg.History.Enabled {
+		sm.history = newHistory(cfg.History, sm)
+	}
+	if cfg.Simulation.Enabled {
+		sm.simulator = newSimulator(cfg.Simulation, sm)
+	}
+
+	return sm
+}
+
+// Start launches the collectors that run in the background, such as the
+// kernel log follower, the connectivity probes and the exec plugins, arms the
+// watchdog when it is enabled, and starts the sampling loop. A simulation
+// only starts the sampling loop: real probe targets and plugin commands would
+// mix real results into the simulated device.
+func (sm *SystemMonitor) Start() {
+	sm.stop = make(chan struct{})
+
+	if sm.replay != nil {
+		go sm.runReplay(sm.stop)
+		return
+	}
+
+	if sm.simulator == nil {
+		if sm.kmsg != nil {
+			go sm.followKernelLog(sm.stop)
+		}
+		for _, p := range sm.probes {
+			go sm.runProbe(p, sm.stop)
+		}
+		for _, p := range sm.plugins {
+			go sm.runPlugin(p, sm.stop)
+		}
+	}
+
+	// A simulation stores no history
+	if sm.simulator == nil && sm.history != nil && sm.cfg.History.Persist.Enabled && sm.historyStore == nil {
+		sm.historyStore = sm.openHistoryStore()
+		if sm.historyStore.cfg.FlushInterval > 0 {
+			go sm.runHistoryStore(sm.stop)
+		}
+	}
+
+	// The first sample is taken right away, so there is one when Start returns
+	// and when the watchdog first checks
+	if _, err := sm.sample(); err != nil {
+		sm.log.Errorf("Failed to get system stats: %v", err)
+	}
+	go sm.runSampler(sm.stop)
+
+	// Only stats read from this device pet the watchdog
+	if sm.cfg.Watchdog.Enabled && sm.Live() {
+		if err := sm.watchdog.open(sm); err == nil {
+			go sm.runWatchdog(sm.stop)
+		} else {
+			sm.log.Errorf("Failed to open watchdog: %v", err)
+		}
 	}
 }
 
-// GetSystemStats collects all system statistics
+// Stop stops the background collectors, stores the history, the storage
+// counters and the write volume, and disarms the watchdog
+func (sm *SystemMonitor) Stop() {
+	if sm.stop != nil {
+		close(sm.stop)
+		sm.stop = nil
+	}
+	if err := sm.storage.save(time.Now()); err != nil {
+		sm.log.Warnf("Failed to persist storage state: %v", err)
+	}
+	if err := sm.writes.save(time.Now()); err != nil {
+		sm.log.Warnf("Failed to persist write counters: %v", err)
+	}
+	if sm.historyStore != nil {
+		if err := sm.historyStore.flush(sm.history, true); err != nil {
+			sm.log.Warnf("Failed to store the history: %v", err)
+		}
+		sm.historyStore.close()
+	}
+	sm.watchdog.close(sm)
+}
+
+// Live reports whether the stats are read from this device, rather than
+// replayed or simulated
+func (sm *SystemMonitor) Live() bool {
+	return sm.replay == nil && sm.simulator == nil
+}
+
+// GetSystemStats collects all system statistics. It advances the rates and
+// averages of the collectors, so it is meant for the sampling loop; readers
+// of a running monitor use LatestStats.
 func (sm *SystemMonitor) GetSystemStats() (*SystemStats, error) {
+	if sm.replay != nil {
+		return sm.replay.replayStats()
+	}
+
 	stats := &SystemStats{
 		Timestamp: time.Now(),
 	}
 
+	if sm.simulator != nil {
+		sm.simulator.fill(sm, stats)
+	} else {
+		sm.collectDeviceStats(stats)
+	}
+
+	// Collect the results of the background probes, which a simulation does
+	// not run
+	if sm.simulator == nil {
+		stats.Probes = sm.getProbeStats()
+	}
+
+	// Collect watched services
+	if serviceStats, err := sm.getServiceStats(); err == nil {
+		stats.Services = serviceStats
+	} else {
+		sm.log.Warnf("Failed to get service stats: %v", err)
+		stats.recordError("services", err)
+	}
+
+	// Collect the metrics of the exec plugins
+	if sm.simulator == nil {
+		stats.Custom, stats.Plugins = sm.getPluginStats()
+	}
+
+	// Collect file metrics
+	stats.Files = sm.getFileMetrics()
+
+	// Evaluate derived metrics over everything collected above
+	stats.Derived = sm.getDerivedMetrics(stats)
+
+	stats.Health = evaluateHealth(stats, sm.cfg.Health.Rules)
+
+	return stats, nil
+}
+
+// collectDeviceStats runs the collectors that read the device itself
+func (sm *SystemMonitor) collectDeviceStats(stats *SystemStats) {
 	// Collect CPU stats
 	if cpuStats, err := sm.getCPUStats(); err == nil {
 		stats.CPU = *cpuStats
 	} else {
 		sm.log.Warnf("Failed to get CPU stats: %v", err)
+		stats.recordError("cpu", err)
 	}
 
 	// Collect memory stats
@@ -103,6 +309,7 @@ func (sm *SystemMonitor) GetSystemStats() (*SystemStats, error) {
 		stats.Memory = *memStats
 	} else {
 		sm.log.Warnf("Failed to get memory stats: %v", err)
+		stats.recordError("memory", err)
 	}
 
 	// Collect disk stats
@@ -110,6 +317,7 @@ func (sm *SystemMonitor) GetSystemStats() (*SystemStats, error) {
 		stats.Disk = *diskStats
 	} else {
 		sm.log.Warnf("Failed to get disk stats: %v", err)
+		stats.recordError("disk", err)
 	}
 
 	// Collect temperature stats
@@ -117,6 +325,7 @@ func (sm *SystemMonitor) GetSystemStats() (*SystemStats, error) {
 		stats.Temperature = *tempStats
 	} else {
 		sm.log.Warnf("Failed to get temperature stats: %v", err)
+		stats.recordError("temperature", err)
 	}
 
 	// Collect GPIO stats
@@ -124,9 +333,80 @@ func (sm *SystemMonitor) GetSystemStats() (*SystemStats, error) {
 		stats.GPIO = *gpioStats
 	} else {
 		sm.log.Warnf("Failed to get GPIO stats: %v", err)
+		stats.recordError("gpio", err)
 	}
 
-	return stats, nil
+	// Collect flash storage health
+	if storageStats, err := sm.getStorageStats(); err == nil {
+		stats.Storage = *storageStats
+	} else {
+		sm.log.Warnf("Failed to get storage stats: %v", err)
+		stats.recordError("storage", err)
+	}
+
+	// Collect write volume against the daily budgets
+	if writeStats, err := sm.getWriteStats(); err == nil {
+		stats.Writes = *writeStats
+	} else {
+		sm.log.Warnf("Failed to get write stats: %v", err)
+		stats.recordError("writes", err)
+	}
+
+	// Collect kernel log counters
+	if kernelStats, err := sm.getKernelLogStats(); err == nil {
+		stats.Kernel = *kernelStats
+	} else {
+		sm.log.Warnf("Failed to get kernel log stats: %v", err)
+		stats.recordError("kernel", err)
+	}
+
+	// Collect interrupt and scheduler rates
+	if interruptStats, err := sm.getInterruptStats(); err == nil {
+		stats.Interrupts = *interruptStats
+	} else {
+		sm.log.Warnf("Failed to get interrupt stats: %v", err)
+		stats.recordError("interrupts", err)
+	}
+
+	// Collect virtual memory rates
+	if vmStats, err := sm.getVMStats(); err == nil {
+		stats.VM = *vmStats
+	} else {
+		sm.log.Warnf("Failed to get vmstat stats: %v", err)
+		stats.recordError("vm", err)
+	}
+
+	// Collect kernel table usage
+	if limitsStats, err := sm.getLimitsStats(); err == nil {
+		stats.Limits = *limitsStats
+	} else {
+		sm.log.Warnf("Failed to get limits stats: %v", err)
+		stats.recordError("limits", err)
+	}
+
+	// Collect network configuration
+	if netConfigStats, err := sm.getNetConfigStats(); err == nil {
+		stats.NetConfig = *netConfigStats
+	} else {
+		sm.log.Warnf("Failed to get network config: %v", err)
+		stats.recordError("netconfig", err)
+	}
+
+	// Collect watchdog status
+	if watchdogStats, err := sm.getWatchdogStats(); err == nil {
+		stats.Watchdog = *watchdogStats
+	} else {
+		sm.log.Warnf("Failed to get watchdog stats: %v", err)
+		stats.recordError("watchdog", err)
+	}
+}
+
+// recordError remembers that a collector failed during this sample
+func (s *SystemStats) recordError(collector string, err error) {
+	if s.Errors == nil {
+		s.Errors = make(map[string]string)
+	}
+	s.Errors[collector] = err.Error()
 }
 
 // getCPUStats collects CPU information
@@ -185,6 +465,10 @@ func (sm *SystemMonitor) getDiskStats() (*DiskStats, error) {
 			stats.IOWrite = ioStats.Write
 		}
 
+		if readOnly, err := readMountReadOnly("/proc/self/mountinfo", "/"); err == nil {
+			stats.ReadOnly = readOnly
+		}
+
 		return stats, nil
 	}
 }
@@ -299,19 +583,8 @@ func (sm *SystemMonitor) readCPUFrequency() (float64, error) {
 
 // readTemperature reads temperature from a sensor file
 func (sm *SystemMonitor) readTemperature(path string) (float64, error) {
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return 0, err
-	}
-
-	tempStr := strings.TrimSpace(string(data))
-	temp, err := strconv.ParseFloat(tempStr, 64)
-	if err != nil {
-		return 0, err
-	}
-
 	// Convert from millidegrees to degrees Celsius
-	return temp / 1000.0, nil
+	return readFileMetric(path, nil, 0, 0.001, 0)
 }
 
 // readGPIOState reads the state of a GPIO pin
@@ -347,27 +620,65 @@ type DiskIOStats struct {
 
 // readDiskIO reads disk I/O statistics from /proc/diskstats
 func (sm *SystemMonitor) readDiskIO() (*DiskIOStats, error) {
-	file, err := os.Open("/proc/diskstats")
+	entries, err := readDiskstats()
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Text()
-		fields := strings.Fields(line)
-		if len(fields) >= 14 && (fields[2] == "sda" || fields[2] == "mmcblk0") {
-			// Fields: major minor name reads reads_merged reads_sectors reads_time writes writes_merged writes_sectors writes_time
-			reads, _ := strconv.ParseUint(fields[3], 10, 64)
-			writes, _ := strconv.ParseUint(fields[7], 10, 64)
 
+	for _, name := range []string{"sda", "mmcblk0"} {
+		if entry, ok := entries[name]; ok {
 			return &DiskIOStats{
-				Read:  reads,
-				Write: writes,
+				Read:  entry.Reads,
+				Write: entry.Writes,
 			}, nil
 		}
 	}
 
 	return &DiskIOStats{}, nil
 }
+
+// readMountReadOnly reports whether the filesystem at mountPoint is mounted
+// read-only, by itself or because its superblock is, e.g. after ext4 remounted
+// it on errors. The last mount on the mount point is the visible one.
+func readMountReadOnly(mountinfo, mountPoint string) (bool, error) {
+	file, err := os.Open(mountinfo)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	readOnly, found := false, false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Fields: id parent major:minor root mount_point options [optional...] - type source super_options
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || unescapeMountPath(fields[4]) != mountPoint {
+			continue
+		}
+		found = true
+		readOnly = hasMountOption(fields[5], "ro")
+		for i := 6; i+3 < len(fields); i++ {
+			if fields[i] == "-" {
+				readOnly = readOnly || hasMountOption(fields[i+3], "ro")
+				break
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+	if !found {
+		return false, fmt.Errorf("%s is not mounted", mountPoint)
+	}
+	return readOnly, nil
+}
+
+// hasMountOption reports whether a comma-separated option list contains option
+func hasMountOption(options, option string) bool {
+	for _, o := range strings.Split(options, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package monitor
 
 import (
+	"path/filepath"
 	"testing"
 
 	"github.com/sirupsen/logrus"
@@ -9,7 +10,7 @@ import (
 func newTestMonitor() *SystemMonitor {
 	log := logrus.New()
 	log.SetOutput(nil) // Silence output
-	return NewSystemMonitor(log)
+	return NewSystemMonitor(log, DefaultConfig())
 }
 
 func TestGetCPUStats(t *testing.T) {
@@ -58,3 +59,24 @@ func TestGetTemperatureStats(t *testing.T) {
 		t.Logf("Temperature stats not available: %v (this is OK on some systems)", err)
 	}
 }
+
+func TestReadMountReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	writeTestFile(t, path, `22 1 179:2 / / rw,relatime shared:1 - ext4 /dev/root rw
+24 22 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+30 22 179:1 / /boot ro,relatime shared:2 - vfat /dev/mmcblk0p1 rw
+31 22 179:3 / /data rw,relatime shared:3 - ext4 /dev/mmcblk0p3 ro,errors=remount-ro
+32 22 0:30 / /mnt/my\040disk rw shared:4 - tmpfs tmpfs rw
+`)
+
+	cases := map[string]bool{"/": false, "/boot": true, "/data": true, "/mnt/my disk": false}
+	for mountPoint, want := range cases {
+		got, err := readMountReadOnly(path, mountPoint)
+		if err != nil || got != want {
+			t.Errorf("readMountReadOnly(%q) = %v, %v; want %v", mountPoint, got, err, want)
+		}
+	}
+	if _, err := readMountReadOnly(path, "/missing"); err == nil {
+		t.Error("expected an error for a mount point that is not mounted")
+	}
+}
@@ -0,0 +1,137 @@
+package monitor
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VMStats represents virtual memory activity rates from /proc/vmstat
+type VMStats struct {
+	PageFaults    float64 `json:"page_faults"`    // per second
+	MajorFaults   float64 `json:"major_faults"`   // per second, faults that needed I/O
+	SwapIn        float64 `json:"swap_in"`        // pages per second
+	SwapOut       float64 `json:"swap_out"`       // pages per second
+	PageScan      float64 `json:"page_scan"`      // pages scanned for reclaim per second
+	PageSteal     float64 `json:"page_steal"`     // pages reclaimed per second
+	OOMKills      float64 `json:"oom_kills"`      // per second
+	CompactStalls float64 `json:"compact_stalls"` // per second
+	AllocStalls   float64 `json:"alloc_stalls"`   // per second
+	OOMKillsTotal uint64  `json:"oom_kills_total"`
+}
+
+// vmstatTracker keeps the previous sample to compute rates from
+type vmstatTracker struct {
+	mu       sync.Mutex
+	time     time.Time
+	counters vmstatCounters
+}
+
+// vmstatCounters holds the /proc/vmstat counters we report, with the per-zone
+// and per-reclaimer variants summed up
+type vmstatCounters struct {
+	PageFaults    uint64
+	MajorFaults   uint64
+	SwapIn        uint64
+	SwapOut       uint64
+	PageScan      uint64
+	PageSteal     uint64
+	OOMKills      uint64
+	CompactStalls uint64
+	AllocStalls   uint64
+}
+
+// getVMStats collects virtual memory rates since the previous sample
+func (sm *SystemMonitor) getVMStats() (*VMStats, error) {
+	file, err := os.Open("/proc/vmstat")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	counters, err := parseVMStat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return sm.vmstat.update(counters, time.Now()), nil
+}
+
+// update computes the rates against the previous sample and stores the new one
+func (vt *vmstatTracker) update(counters vmstatCounters, now time.Time) *VMStats {
+	vt.mu.Lock()
+	defer vt.mu.Unlock()
+
+	stats := &VMStats{OOMKillsTotal: counters.OOMKills}
+
+	seconds := now.Sub(vt.time).Seconds()
+	if !vt.time.IsZero() && seconds > 0 {
+		prev := vt.counters
+		stats.PageFaults = counterRate(prev.PageFaults, counters.PageFaults, seconds)
+		stats.MajorFaults = counterRate(prev.MajorFaults, counters.MajorFaults, seconds)
+		stats.SwapIn = counterRate(prev.SwapIn, counters.SwapIn, seconds)
+		stats.SwapOut = counterRate(prev.SwapOut, counters.SwapOut, seconds)
+		stats.PageScan = counterRate(prev.PageScan, counters.PageScan, seconds)
+		stats.PageSteal = counterRate(prev.PageSteal, counters.PageSteal, seconds)
+		stats.OOMKills = counterRate(prev.OOMKills, counters.OOMKills, seconds)
+		stats.CompactStalls = counterRate(prev.CompactStalls, counters.CompactStalls, seconds)
+		stats.AllocStalls = counterRate(prev.AllocStalls, counters.AllocStalls, seconds)
+	}
+
+	vt.time = now
+	vt.counters = counters
+
+	return stats
+}
+
+// parseVMStat parses /proc/vmstat. Reclaim counters are split by reclaimer
+// (pgscan_kswapd, pgscan_direct, ...) and, on older kernels, by zone
+// (pgscan_kswapd_normal, allocstall_dma32, ...), so every variant is summed.
+// Newer kernels also split them by LRU (pgscan_anon, pgscan_file); those
+// repeat the same pages and are skipped, as is pgscan_direct_throttle, which
+// counts throttling events rather than pages.
+func parseVMStat(r io.Reader) (vmstatCounters, error) {
+	var counters vmstatCounters
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		name := fields[0]
+		switch {
+		case name == "pgfault":
+			counters.PageFaults = value
+		case name == "pgmajfault":
+			counters.MajorFaults = value
+		case name == "pswpin":
+			counters.SwapIn = value
+		case name == "pswpout":
+			counters.SwapOut = value
+		case name == "oom_kill":
+			counters.OOMKills = value
+		case name == "compact_stall":
+			counters.CompactStalls = value
+		case name == "allocstall" || strings.HasPrefix(name, "allocstall_"):
+			counters.AllocStalls += value
+		case name == "pgscan_anon" || name == "pgscan_file" || name == "pgscan_direct_throttle":
+		case strings.HasPrefix(name, "pgscan_"):
+			counters.PageScan += value
+		case name == "pgsteal_anon" || name == "pgsteal_file":
+		case strings.HasPrefix(name, "pgsteal_"):
+			counters.PageSteal += value
+		}
+	}
+
+	return counters, scanner.Err()
+}
@@ -0,0 +1,63 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testVMStat = `nr_free_pages 12345
+pgfault 1000
+pgmajfault 10
+pswpin 0
+pswpout 4
+pgsteal_kswapd 100
+pgsteal_direct 20
+pgsteal_anon 60
+pgsteal_file 60
+pgscan_kswapd 200
+pgscan_direct 50
+pgscan_direct_throttle 3
+pgscan_anon 125
+pgscan_file 125
+oom_kill 1
+compact_stall 2
+allocstall_dma 1
+allocstall_normal 5
+`
+
+func TestParseVMStat(t *testing.T) {
+	counters, err := parseVMStat(strings.NewReader(testVMStat))
+	if err != nil {
+		t.Fatalf("parseVMStat: %v", err)
+	}
+
+	want := vmstatCounters{
+		PageFaults:    1000,
+		MajorFaults:   10,
+		SwapOut:       4,
+		PageScan:      250,
+		PageSteal:     120,
+		OOMKills:      1,
+		CompactStalls: 2,
+		AllocStalls:   6,
+	}
+	if counters != want {
+		t.Errorf("parseVMStat = %+v, want %+v", counters, want)
+	}
+}
+
+func TestVMStatRates(t *testing.T) {
+	var tracker vmstatTracker
+	now := time.Now()
+
+	first := tracker.update(vmstatCounters{PageFaults: 1000, MajorFaults: 10, OOMKills: 1}, now)
+	if first.PageFaults != 0 || first.OOMKillsTotal != 1 {
+		t.Errorf("first sample should only report totals: %+v", first)
+	}
+
+	stats := tracker.update(vmstatCounters{PageFaults: 3000, MajorFaults: 50, OOMKills: 3}, now.Add(4*time.Second))
+	if stats.PageFaults != 500 || stats.MajorFaults != 10 || stats.OOMKills != 0.5 || stats.OOMKillsTotal != 3 {
+		t.Errorf("unexpected rates: %+v", stats)
+	}
+}
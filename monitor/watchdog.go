@@ -0,0 +1,283 @@
+package monitor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WatchdogStats represents the state of the hardware watchdog
+type WatchdogStats struct {
+	Enabled bool                      `json:"enabled"` // emmon holds the watchdog device open
+	Healthy bool                      `json:"healthy"` // every check passed at the last check
+	LastPet time.Time                 `json:"last_pet"`
+	Reasons []string                  `json:"reasons,omitempty"` // checks that failed
+	Devices map[string]WatchdogDevice `json:"devices,omitempty"` // watchdog name -> status
+}
+
+// WatchdogDevice represents a watchdog in /sys/class/watchdog
+type WatchdogDevice struct {
+	Identity   string `json:"identity"`
+	Active     bool   `json:"active"`
+	Timeout    uint64 `json:"timeout"`  // seconds
+	TimeLeft   uint64 `json:"timeleft"` // seconds, 0 if the driver does not report it
+	Pretimeout uint64 `json:"pretimeout"`
+	BootStatus uint64 `json:"bootstatus"` // WDIOF_* flags of the last reset, e.g. 0x20 for a watchdog reset
+	Nowayout   bool   `json:"nowayout"`   // the watchdog cannot be stopped once started
+}
+
+// watchdog owns the watchdog device and pets it while the checks pass
+type watchdog struct {
+	cfg        WatchdogConfig
+	conditions []*Expression
+	sysfsPath  string
+
+	mu      sync.Mutex
+	file    *os.File
+	healthy bool
+	checked bool
+	lastPet time.Time
+	reasons []string
+}
+
+// newWatchdog compiles the watchdog conditions, skipping invalid ones
+func newWatchdog(cfg WatchdogConfig, sm *SystemMonitor) *watchdog {
+	if cfg.Collectors == nil {
+		cfg.Collectors = []string{"cpu", "memory", "disk"}
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+
+	w := &watchdog{cfg: cfg, sysfsPath: "/sys/class/watchdog"}
+	for _, source := range cfg.Conditions {
+		expr, err := ParseExpression(source)
+		if err != nil {
+			sm.log.Errorf("Invalid watchdog condition %q: %v", source, err)
+			continue
+		}
+		w.conditions = append(w.conditions, expr)
+	}
+	return w
+}
+
+// open opens the watchdog device, which starts the watchdog
+func (w *watchdog) open(sm *SystemMonitor) error {
+	file, err := os.OpenFile(w.cfg.Device, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.file = file
+	w.mu.Unlock()
+	sm.log.Infof("Watchdog %s armed, checking every %s", w.cfg.Device, w.cfg.Interval)
+
+	// /dev/watchdog is the first watchdog, /dev/watchdogN the Nth
+	name := filepath.Base(w.cfg.Device)
+	if name == "watchdog" {
+		name = "watchdog0"
+	}
+	dir := filepath.Join(w.sysfsPath, name)
+	if timeout := readProcUint(filepath.Join(dir, "timeout")); timeout > 0 &&
+		w.cfg.Interval >= time.Duration(timeout)*time.Second/2 {
+		sm.log.Warnf("Watchdog interval %s is not well below the %ds timeout", w.cfg.Interval, timeout)
+	}
+	return nil
+}
+
+// close disarms the watchdog with the magic close character. A watchdog
+// with nowayout set keeps running and still resets the device.
+func (w *watchdog) close(sm *SystemMonitor) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return
+	}
+	if _, err := w.file.Write([]byte("V")); err != nil {
+		sm.log.Errorf("Failed to disarm watchdog: %v", err)
+	}
+	if err := w.file.Close(); err != nil {
+		sm.log.Errorf("Failed to close watchdog: %v", err)
+	}
+	w.file = nil
+	sm.log.Infof("Watchdog %s disarmed", w.cfg.Device)
+}
+
+// runWatchdog checks the latest sample of the sampling loop and pets the
+// watchdog while it passes the checks. A sampling loop that hangs leaves the
+// sample stale, which stops the petting as well.
+func (sm *SystemMonitor) runWatchdog(stop <-chan struct{}) {
+	ticker := time.NewTicker(sm.watchdog.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := sm.LatestStats()
+		switch {
+		case err != nil:
+			sm.watchdog.update(sm, []string{err.Error()}, time.Now())
+		case sm.Stalled():
+			sm.watchdog.update(sm, []string{"no sample since " + stats.Timestamp.Format(time.RFC3339)}, time.Now())
+		default:
+			sm.watchdog.check(sm, stats)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check pets the watchdog if the stats pass every check
+func (w *watchdog) check(sm *SystemMonitor, stats *SystemStats) {
+	w.update(sm, w.failures(stats), stats.Timestamp)
+}
+
+// update pets the watchdog if there are no reasons not to, and raises events
+// when the watchdog stops or resumes being petted
+func (w *watchdog) update(sm *SystemMonitor, reasons []string, now time.Time) {
+	healthy := len(reasons) == 0
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return
+	}
+	if healthy {
+		if _, err := w.file.Write([]byte("1")); err == nil {
+			w.lastPet = now
+		} else {
+			sm.log.Errorf("Failed to pet watchdog: %v", err)
+		}
+	}
+
+	switch {
+	case !healthy && (w.healthy || !w.checked):
+		sm.emitEvent(Event{
+			Source:   "watchdog",
+			Name:     w.cfg.Device,
+			Severity: HealthCritical,
+			Message:  "Watchdog no longer petted: " + strings.Join(reasons, ", "),
+		})
+	case healthy && !w.healthy && w.checked:
+		sm.emitEvent(Event{
+			Source:   "watchdog",
+			Name:     w.cfg.Device,
+			Severity: HealthOK,
+			Message:  "Watchdog petted again",
+		})
+	}
+	w.healthy, w.checked, w.reasons = healthy, true, reasons
+}
+
+// failures returns the reasons the watchdog must not be petted
+func (w *watchdog) failures(stats *SystemStats) []string {
+	var reasons []string
+
+	for _, collector := range w.cfg.Collectors {
+		if err, ok := stats.Errors[collector]; ok {
+			reasons = append(reasons, fmt.Sprintf("%s collector failed: %s", collector, err))
+		}
+	}
+
+	for _, name := range w.cfg.Services {
+		if status, ok := stats.Services[name]; !ok || !status.Up {
+			reasons = append(reasons, fmt.Sprintf("service %s is not running", name))
+		}
+	}
+
+	if w.cfg.RequireWritable && stats.Disk.ReadOnly {
+		reasons = append(reasons, "root filesystem is read-only")
+	}
+
+	if w.cfg.MaxTemperature > 0 {
+		temps := []struct {
+			name  string
+			value float64
+		}{{"cpu", stats.Temperature.CPU}, {"gpu", stats.Temperature.GPU}, {"board", stats.Temperature.Board}}
+		for _, temp := range temps {
+			if temp.value >= w.cfg.MaxTemperature {
+				reasons = append(reasons, fmt.Sprintf("%s temperature is %.1f°C (max %.1f°C)",
+					temp.name, temp.value, w.cfg.MaxTemperature))
+			}
+		}
+	}
+
+	if len(w.conditions) > 0 {
+		metrics := stats.Metrics()
+		for _, expr := range w.conditions {
+			value, err := expr.Eval(metrics, stats.Timestamp)
+			switch {
+			case err != nil:
+				reasons = append(reasons, fmt.Sprintf("condition %s: %v", expr, err))
+			case value == 0:
+				reasons = append(reasons, fmt.Sprintf("condition %s is false", expr))
+			}
+		}
+	}
+
+	return reasons
+}
+
+// getWatchdogStats reports the watchdogs in sysfs and the state of the one
+// emmon owns
+func (sm *SystemMonitor) getWatchdogStats() (*WatchdogStats, error) {
+	devices, err := readWatchdogDevices(sm.watchdog.sysfsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	sm.watchdog.mu.Lock()
+	defer sm.watchdog.mu.Unlock()
+
+	return &WatchdogStats{
+		Enabled: sm.watchdog.file != nil,
+		Healthy: sm.watchdog.healthy,
+		LastPet: sm.watchdog.lastPet,
+		Reasons: append([]string(nil), sm.watchdog.reasons...),
+		Devices: devices,
+	}, nil
+}
+
+// readWatchdogDevices reads the watchdogs under /sys/class/watchdog. A system
+// without watchdogs has no such directory and reports none.
+func readWatchdogDevices(sysfsPath string) (map[string]WatchdogDevice, error) {
+	entries, err := ioutil.ReadDir(sysfsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	devices := make(map[string]WatchdogDevice, len(entries))
+	for _, entry := range entries {
+		dir := filepath.Join(sysfsPath, entry.Name())
+		device := WatchdogDevice{
+			Timeout:    readProcUint(filepath.Join(dir, "timeout")),
+			TimeLeft:   readProcUint(filepath.Join(dir, "timeleft")),
+			Pretimeout: readProcUint(filepath.Join(dir, "pretimeout")),
+			Nowayout:   readProcUint(filepath.Join(dir, "nowayout")) == 1,
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "identity")); err == nil {
+			device.Identity = strings.TrimSpace(string(data))
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "state")); err == nil {
+			device.Active = strings.TrimSpace(string(data)) == "active"
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "bootstatus")); err == nil {
+			device.BootStatus, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 0, 64)
+		}
+		devices[entry.Name()] = device
+	}
+	return devices, nil
+}
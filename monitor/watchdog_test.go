@@ -0,0 +1,163 @@
+package monitor
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestWatchdogPetsOnlyWhileHealthy(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "watchdog")
+	writeTestFile(t, device, "")
+
+	cfg := DefaultConfig()
+	cfg.StateDir = dir
+	cfg.Watchdog.Enabled = true
+	cfg.Watchdog.Device = device
+	cfg.Watchdog.Services = []string{"app"}
+	cfg.Watchdog.Conditions = []string{"memory.usage_percent < 95", "not a valid ("}
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	sm := NewSystemMonitor(log, cfg)
+	sm.watchdog.sysfsPath = filepath.Join(dir, "sys")
+
+	if err := sm.watchdog.open(sm); err != nil {
+		t.Fatal(err)
+	}
+
+	healthy := &SystemStats{
+		Timestamp: time.Now(),
+		Memory:    MemStats{UsagePercent: 50},
+		Services:  map[string]ServiceStatus{"app": {Up: true}},
+	}
+	sm.watchdog.check(sm, healthy)
+	sm.watchdog.check(sm, healthy)
+
+	failing := []*SystemStats{
+		{Memory: MemStats{UsagePercent: 50}, Services: map[string]ServiceStatus{"app": {}}},
+		{Memory: MemStats{UsagePercent: 99}, Services: map[string]ServiceStatus{"app": {Up: true}}},
+		{Memory: MemStats{UsagePercent: 50}, Services: map[string]ServiceStatus{"app": {Up: true}},
+			Disk: DiskStats{ReadOnly: true}},
+		{Memory: MemStats{UsagePercent: 50}, Services: map[string]ServiceStatus{"app": {Up: true}},
+			Temperature: TempStats{CPU: 101}},
+		{Memory: MemStats{UsagePercent: 50}, Services: map[string]ServiceStatus{"app": {Up: true}},
+			Errors: map[string]string{"cpu": "stat failed"}},
+	}
+	for i, stats := range failing {
+		sm.watchdog.check(sm, stats)
+		if reasons := sm.watchdog.reasons; len(reasons) != 1 {
+			t.Errorf("case %d: reasons = %q, want one", i, reasons)
+		}
+	}
+
+	stats, err := sm.getWatchdogStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stats.Enabled || stats.Healthy {
+		t.Errorf("watchdog stats = %+v", stats)
+	}
+
+	sm.watchdog.check(sm, healthy)
+	sm.Stop()
+
+	data, err := ioutil.ReadFile(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "111V" {
+		t.Errorf("device writes = %q, want three pets and the magic close", got)
+	}
+
+	var messages []string
+	for _, event := range sm.RecentEvents() {
+		if event.Source == "watchdog" {
+			messages = append(messages, event.Message)
+		}
+	}
+	if len(messages) != 2 || !strings.Contains(messages[0], "service app is not running") {
+		t.Errorf("watchdog events = %q", messages)
+	}
+}
+
+func TestReadWatchdogDevices(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "watchdog0", "identity"), "Broadcom BCM2835 Watchdog timer\n")
+	writeTestFile(t, filepath.Join(dir, "watchdog0", "state"), "active\n")
+	writeTestFile(t, filepath.Join(dir, "watchdog0", "timeout"), "15\n")
+	writeTestFile(t, filepath.Join(dir, "watchdog0", "timeleft"), "12\n")
+	writeTestFile(t, filepath.Join(dir, "watchdog0", "bootstatus"), "32\n")
+	writeTestFile(t, filepath.Join(dir, "watchdog0", "nowayout"), "1\n")
+	writeTestFile(t, filepath.Join(dir, "watchdog1", "identity"), "Software Watchdog\n")
+	writeTestFile(t, filepath.Join(dir, "watchdog1", "state"), "inactive\n")
+
+	devices, err := readWatchdogDevices(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := WatchdogDevice{Identity: "Broadcom BCM2835 Watchdog timer", Active: true, Timeout: 15, TimeLeft: 12,
+		BootStatus: 32, Nowayout: true}
+	if got := devices["watchdog0"]; got != want {
+		t.Errorf("watchdog0 = %+v, want %+v", got, want)
+	}
+	if got := devices["watchdog1"]; got.Active || got.Identity != "Software Watchdog" {
+		t.Errorf("watchdog1 = %+v", got)
+	}
+
+	if devices, err := readWatchdogDevices(filepath.Join(dir, "missing")); err != nil || devices != nil {
+		t.Errorf("missing sysfs class = %v, %v", devices, err)
+	}
+}
+
+func TestWatchdogStopsOnStaleSample(t *testing.T) {
+	dir := t.TempDir()
+	device := filepath.Join(dir, "watchdog")
+	writeTestFile(t, device, "")
+
+	cfg := DefaultConfig()
+	cfg.StateDir = dir
+	cfg.Watchdog.Enabled = true
+	cfg.Watchdog.Device = device
+	log := logrus.New()
+	log.SetOutput(ioutil.Discard)
+	sm := NewSystemMonitor(log, cfg)
+	sm.watchdog.sysfsPath = filepath.Join(dir, "sys")
+
+	if err := sm.watchdog.open(sm); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	close(stop)
+
+	// Without a sample there is nothing to vouch for the device
+	sm.runWatchdog(stop)
+	if sm.watchdog.healthy {
+		t.Error("expected no pet before the first sample")
+	}
+
+	sm.publish(&SystemStats{Timestamp: time.Now()})
+	sm.runWatchdog(stop)
+	if !sm.watchdog.healthy {
+		t.Errorf("expected a fresh sample to pet the watchdog, reasons %q", sm.watchdog.reasons)
+	}
+
+	sm.sampler.published = time.Now().Add(-staleSamples*sm.cfg.SampleInterval - time.Second)
+	sm.runWatchdog(stop)
+	if reasons := sm.watchdog.reasons; len(reasons) != 1 || !strings.HasPrefix(reasons[0], "no sample since") {
+		t.Errorf("reasons = %q, want a stale sample", reasons)
+	}
+
+	sm.Stop()
+	data, err := ioutil.ReadFile(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "1V" {
+		t.Errorf("device writes = %q, want one pet and the magic close", got)
+	}
+}
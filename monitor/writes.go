@@ -0,0 +1,485 @@
+package monitor
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	hourKeyFormat = "2006-01-02T15"
+	dayKeyFormat  = "2006-01-02"
+)
+
+// WriteStats represents the write volume per device, per mount and per process
+type WriteStats struct {
+	Devices    map[string]WriteVolume `json:"devices"`
+	Mounts     map[string]WriteVolume `json:"mounts"`
+	TopWriters []ProcessWrites        `json:"top_writers"`
+}
+
+// WriteVolume represents the bytes written to one device or mount
+type WriteVolume struct {
+	Hour          uint64         `json:"hour"`  // bytes written in the current hour
+	Today         uint64         `json:"today"` // bytes written since midnight
+	Budget        uint64         `json:"budget,omitempty"`
+	BudgetPercent float64        `json:"budget_percent"`
+	OverBudget    bool           `json:"over_budget"`
+	Hours         []VolumeSample `json:"hours"` // the last 24 hours, oldest first
+	Days          []VolumeSample `json:"days"`  // the retained days, oldest first
+}
+
+// VolumeSample represents the bytes written during one hour or day
+type VolumeSample struct {
+	Start time.Time `json:"start"`
+	Bytes uint64    `json:"bytes"`
+}
+
+// ProcessWrites represents the storage writes attributed to one process
+type ProcessWrites struct {
+	PID         int     `json:"pid"`
+	Name        string  `json:"name"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+	Today       uint64  `json:"today"` // bytes written today by processes with this name
+}
+
+// writeBucket holds the bytes written during one hour or day
+type writeBucket struct {
+	Devices   map[string]uint64 `json:"devices"`
+	Mounts    map[string]uint64 `json:"mounts"`
+	Processes map[string]uint64 `json:"processes,omitempty"`
+}
+
+// newWriteBucket creates an empty bucket
+func newWriteBucket() *writeBucket {
+	return &writeBucket{
+		Devices:   make(map[string]uint64),
+		Mounts:    make(map[string]uint64),
+		Processes: make(map[string]uint64),
+	}
+}
+
+// writeState is the part of the write tracking persisted across reboots
+type writeState struct {
+	BootID   string                  `json:"boot_id"`
+	Counters map[string]uint64       `json:"counters"` // device -> bytes written since boot at the last sample
+	Hours    map[string]*writeBucket `json:"hours"`
+	Days     map[string]*writeBucket `json:"days"`
+}
+
+// writeTracker accumulates the write counters into hourly and daily buckets
+type writeTracker struct {
+	mu       sync.Mutex
+	path     string
+	cfg      WriteBudgetConfig
+	state    writeState
+	loaded   bool
+	readOnly bool // another process owns the state, never save it
+	lastSave time.Time
+	alerted  map[string]string // device or mount -> day the budget alert was logged
+
+	processes  map[int]uint64 // pid -> write_bytes at the last sample
+	lastSample time.Time
+}
+
+// newWriteTracker creates a tracker persisting its state to path
+func newWriteTracker(path string, cfg WriteBudgetConfig) *writeTracker {
+	return &writeTracker{
+		path:      path,
+		cfg:       cfg,
+		alerted:   make(map[string]string),
+		processes: make(map[int]uint64),
+	}
+}
+
+// getWriteStats collects the write volume and checks it against the budgets
+func (sm *SystemMonitor) getWriteStats() (*WriteStats, error) {
+	diskstats, err := readDiskstats()
+	if err != nil {
+		return nil, err
+	}
+
+	mounts, err := readMountDevices(diskstats)
+	if err != nil {
+		return nil, err
+	}
+
+	processes, err := readProcessWrites()
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := sm.writes.update(diskstats, mounts, processes, readBootID(), time.Now())
+	if err != nil {
+		sm.log.Warnf("Failed to persist write counters: %v", err)
+	}
+
+	for name, volume := range stats.Devices {
+		sm.writes.alert(sm, name, volume)
+	}
+	for name, volume := range stats.Mounts {
+		sm.writes.alert(sm, name, volume)
+	}
+
+	return stats, nil
+}
+
+// alert logs a warning the first time a budget is exceeded each day
+func (wt *writeTracker) alert(sm *SystemMonitor, name string, volume WriteVolume) {
+	if !volume.OverBudget {
+		return
+	}
+
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	today := time.Now().Format(dayKeyFormat)
+	if wt.alerted[name] == today {
+		return
+	}
+	wt.alerted[name] = today
+	sm.log.Warnf("Daily write budget exceeded for %s: %d of %d bytes", name, volume.Today, volume.Budget)
+}
+
+// update adds the writes since the last sample to the current buckets and
+// returns the resulting volumes. The state is saved when the persist
+// interval has passed or the device rebooted.
+func (wt *writeTracker) update(diskstats map[string]diskstatsEntry, mounts map[string]string,
+	processes map[int]processIO, bootID string, now time.Time) (*WriteStats, error) {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	var loadErr error
+	if !wt.loaded {
+		loadErr = loadState(wt.path, &wt.state)
+		wt.loaded = true
+	}
+	if wt.state.Counters == nil {
+		wt.state.Counters = make(map[string]uint64)
+	}
+	if wt.state.Hours == nil {
+		wt.state.Hours = make(map[string]*writeBucket)
+	}
+	if wt.state.Days == nil {
+		wt.state.Days = make(map[string]*writeBucket)
+	}
+
+	// Without a previous sample there is no telling when the writes since
+	// boot happened; after a reboot they all happened during this boot.
+	firstRun := wt.state.BootID == ""
+	rebooted := wt.state.BootID != bootID
+	wt.state.BootID = bootID
+
+	hour := wt.bucket(wt.state.Hours, now.Format(hourKeyFormat))
+	day := wt.bucket(wt.state.Days, now.Format(dayKeyFormat))
+
+	deltas := make(map[string]uint64)
+	for name, entry := range diskstats {
+		if skipWriteDevice(name) || entry.WriteSectors == 0 {
+			continue
+		}
+
+		current := entry.BytesWritten()
+		previous, seen := wt.state.Counters[name]
+		wt.state.Counters[name] = current
+
+		var delta uint64
+		switch {
+		case firstRun:
+		case rebooted || current < previous:
+			delta = current
+		case seen:
+			delta = current - previous
+		}
+		deltas[name] = delta
+		hour.Devices[name] += delta
+		day.Devices[name] += delta
+	}
+
+	for mount, device := range mounts {
+		hour.Mounts[mount] += deltas[device]
+		day.Mounts[mount] += deltas[device]
+	}
+
+	topWriters := wt.attributeProcesses(processes, day, now)
+	wt.prune(now)
+
+	stats := &WriteStats{
+		Devices:    make(map[string]WriteVolume),
+		Mounts:     make(map[string]WriteVolume),
+		TopWriters: topWriters,
+	}
+	for name := range day.Devices {
+		if _, ok := deltas[name]; ok {
+			stats.Devices[name] = wt.volume(name, now, func(b *writeBucket) map[string]uint64 { return b.Devices }, wt.cfg.Daily)
+		}
+	}
+	for mount := range mounts {
+		stats.Mounts[mount] = wt.volume(mount, now, func(b *writeBucket) map[string]uint64 { return b.Mounts }, 0)
+	}
+
+	if loadErr != nil {
+		return stats, loadErr
+	}
+	if wt.readOnly || !rebooted && now.Sub(wt.lastSave) < wt.cfg.PersistInterval {
+		return stats, nil
+	}
+	wt.lastSave = now
+	return stats, saveState(wt.path, &wt.state)
+}
+
+// save writes the state, if it was loaded, so a clean shutdown keeps the
+// writes since the last periodic save
+func (wt *writeTracker) save(now time.Time) error {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	if !wt.loaded || wt.readOnly {
+		return nil
+	}
+	wt.lastSave = now
+	return saveState(wt.path, &wt.state)
+}
+
+// bucket returns the bucket for key, creating it if needed
+func (wt *writeTracker) bucket(buckets map[string]*writeBucket, key string) *writeBucket {
+	b, ok := buckets[key]
+	if !ok {
+		b = newWriteBucket()
+		buckets[key] = b
+	}
+	if b.Devices == nil {
+		b.Devices = make(map[string]uint64)
+	}
+	if b.Mounts == nil {
+		b.Mounts = make(map[string]uint64)
+	}
+	if b.Processes == nil {
+		b.Processes = make(map[string]uint64)
+	}
+	return b
+}
+
+// attributeProcesses charges the write_bytes deltas of each process to
+// today's bucket and returns the top writers of the day
+func (wt *writeTracker) attributeProcesses(processes map[int]processIO, day *writeBucket, now time.Time) []ProcessWrites {
+	elapsed := now.Sub(wt.lastSample).Seconds()
+	first := wt.lastSample.IsZero()
+	wt.lastSample = now
+
+	rates := make(map[string]float64)
+	pids := make(map[string]int)
+	current := make(map[int]uint64, len(processes))
+	for pid, proc := range processes {
+		current[pid] = proc.WriteBytes
+		previous, ok := wt.processes[pid]
+		if first || !ok || proc.WriteBytes < previous {
+			continue
+		}
+		delta := proc.WriteBytes - previous
+		if delta == 0 {
+			continue
+		}
+		day.Processes[proc.Name] += delta
+		if elapsed > 0 {
+			rates[proc.Name] += float64(delta) / elapsed
+		}
+		pids[proc.Name] = pid
+	}
+	wt.processes = current
+
+	writers := make([]ProcessWrites, 0, len(day.Processes))
+	for name, today := range day.Processes {
+		writers = append(writers, ProcessWrites{
+			PID:         pids[name],
+			Name:        name,
+			BytesPerSec: rates[name],
+			Today:       today,
+		})
+	}
+	sort.Slice(writers, func(i, j int) bool { return writers[i].Today > writers[j].Today })
+
+	if len(writers) > wt.cfg.TopProcesses {
+		writers = writers[:wt.cfg.TopProcesses]
+	}
+	return writers
+}
+
+// volume builds the write volume of a device or mount from the buckets
+func (wt *writeTracker) volume(name string, now time.Time, counters func(*writeBucket) map[string]uint64, defaultBudget uint64) WriteVolume {
+	volume := WriteVolume{
+		Hour:  counters(wt.state.Hours[now.Format(hourKeyFormat)])[name],
+		Today: counters(wt.state.Days[now.Format(dayKeyFormat)])[name],
+	}
+
+	// Hours start on the hour in local time; Truncate works on absolute time
+	// and is off in zones with a non-whole-hour offset
+	for i := 23; i >= 0; i-- {
+		start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour()-i, 0, 0, 0, now.Location())
+		if b, ok := wt.state.Hours[start.Format(hourKeyFormat)]; ok {
+			volume.Hours = append(volume.Hours, VolumeSample{Start: start, Bytes: counters(b)[name]})
+		}
+	}
+
+	days := make([]string, 0, len(wt.state.Days))
+	for key := range wt.state.Days {
+		days = append(days, key)
+	}
+	sort.Strings(days)
+	for _, key := range days {
+		start, err := time.ParseInLocation(dayKeyFormat, key, now.Location())
+		if err != nil {
+			continue
+		}
+		volume.Days = append(volume.Days, VolumeSample{Start: start, Bytes: counters(wt.state.Days[key])[name]})
+	}
+
+	volume.Budget = defaultBudget
+	if budget, ok := wt.cfg.Budgets[name]; ok {
+		volume.Budget = budget
+	}
+	if volume.Budget > 0 {
+		volume.BudgetPercent = float64(volume.Today) / float64(volume.Budget) * 100
+		volume.OverBudget = volume.Today > volume.Budget
+	}
+
+	return volume
+}
+
+// prune drops hourly buckets older than a day and daily buckets past the retention
+func (wt *writeTracker) prune(now time.Time) {
+	oldestHour := now.Add(-23 * time.Hour).Format(hourKeyFormat)
+	for key := range wt.state.Hours {
+		if key < oldestHour {
+			delete(wt.state.Hours, key)
+		}
+	}
+
+	keepDays := wt.cfg.KeepDays
+	if keepDays < 1 {
+		keepDays = 1
+	}
+	oldestDay := now.AddDate(0, 0, 1-keepDays).Format(dayKeyFormat)
+	for key := range wt.state.Days {
+		if key < oldestDay {
+			delete(wt.state.Days, key)
+		}
+	}
+}
+
+// skipWriteDevice reports whether a diskstats device is not backed by storage
+func skipWriteDevice(name string) bool {
+	for _, prefix := range []string{"loop", "ram", "zram", "nbd"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// readMountDevices maps mount points to their diskstats device names using
+// the major:minor numbers in /proc/self/mountinfo, which also resolves
+// /dev/root and by-uuid mounts
+func readMountDevices(diskstats map[string]diskstatsEntry) (map[string]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	devices := make(map[string]string)
+	for name, entry := range diskstats {
+		devices[entry.Major+":"+entry.Minor] = name
+	}
+
+	mounts := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Fields: id parent major:minor root mount_point options ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		if device, ok := devices[fields[2]]; ok {
+			mounts[unescapeMountPath(fields[4])] = device
+		}
+	}
+
+	return mounts, scanner.Err()
+}
+
+// unescapeMountPath decodes the octal escapes the kernel uses in mount paths
+func unescapeMountPath(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if c, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// processIO holds the storage write counter of one process
+type processIO struct {
+	Name       string
+	WriteBytes uint64
+}
+
+// readProcessWrites reads write_bytes from /proc/[pid]/io for every process
+// it is allowed to see. Other users' processes need root or CAP_SYS_PTRACE.
+func readProcessWrites() (map[int]processIO, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	processes := make(map[int]processIO)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join("/proc", entry.Name(), "io"))
+		if err != nil {
+			continue // exited or not permitted
+		}
+		writeBytes, ok := parseProcIOWriteBytes(string(data))
+		if !ok {
+			continue
+		}
+
+		processes[pid] = processIO{
+			Name:       readAttrString(filepath.Join("/proc", entry.Name()), "comm"),
+			WriteBytes: writeBytes,
+		}
+	}
+
+	return processes, nil
+}
+
+// parseProcIOWriteBytes extracts write_bytes from the /proc/[pid]/io format
+func parseProcIOWriteBytes(data string) (uint64, bool) {
+	for _, line := range strings.Split(data, "\n") {
+		if strings.HasPrefix(line, "write_bytes:") {
+			v, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "write_bytes:")), 10, 64)
+			return v, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteTrackerBudget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "writes.json")
+	cfg := DefaultConfig().WriteBudget
+	cfg.Budgets = map[string]uint64{"/data": 1 << 20}
+
+	tracker := newWriteTracker(path, cfg)
+	now := time.Date(2024, 5, 1, 10, 30, 0, 0, time.Local)
+	mounts := map[string]string{"/data": "mmcblk0p3"}
+	counters := func(sectors uint64) map[string]diskstatsEntry {
+		return map[string]diskstatsEntry{"mmcblk0p3": {Name: "mmcblk0p3", WriteSectors: sectors}}
+	}
+
+	// The first sample only establishes the baseline
+	if _, err := tracker.update(counters(100), mounts, nil, "boot", now); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	stats, err := tracker.update(counters(100+4096), mounts, nil, "boot", now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	data := stats.Mounts["/data"]
+	if data.Today != 4096*512 || data.Hour != 4096*512 {
+		t.Errorf("unexpected volume: hour %d today %d", data.Hour, data.Today)
+	}
+	if !data.OverBudget || data.BudgetPercent != 200 {
+		t.Errorf("expected /data over budget at 200%%, got %v at %.1f%%", data.OverBudget, data.BudgetPercent)
+	}
+
+	// The next hour starts a new hourly bucket but the same day
+	stats, err = tracker.update(counters(100+4096+8), mounts, nil, "boot", now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	dev := stats.Devices["mmcblk0p3"]
+	if dev.Hour != 8*512 || dev.Today != 4104*512 {
+		t.Errorf("unexpected device volume: hour %d today %d", dev.Hour, dev.Today)
+	}
+	if len(dev.Hours) != 2 {
+		t.Errorf("expected 2 hourly samples, got %d", len(dev.Hours))
+	}
+}
+
+func TestAttributeProcesses(t *testing.T) {
+	tracker := newWriteTracker("", DefaultConfig().WriteBudget)
+	day := newWriteBucket()
+	now := time.Now()
+
+	tracker.attributeProcesses(map[int]processIO{
+		10: {Name: "logger", WriteBytes: 1000},
+		20: {Name: "app", WriteBytes: 500},
+	}, day, now)
+	writers := tracker.attributeProcesses(map[int]processIO{
+		10: {Name: "logger", WriteBytes: 11000},
+		20: {Name: "app", WriteBytes: 600},
+	}, day, now.Add(10*time.Second))
+
+	if len(writers) != 2 || writers[0].Name != "logger" {
+		t.Fatalf("expected logger as top writer, got %+v", writers)
+	}
+	if writers[0].Today != 10000 || writers[0].BytesPerSec != 1000 {
+		t.Errorf("unexpected logger writes: %+v", writers[0])
+	}
+}
+
+func TestParseProcIOWriteBytes(t *testing.T) {
+	data := "rchar: 100\nwchar: 200\nread_bytes: 4096\nwrite_bytes: 8192\ncancelled_write_bytes: 0\n"
+	if v, ok := parseProcIOWriteBytes(data); !ok || v != 8192 {
+		t.Errorf("write_bytes = %d (%v), want 8192", v, ok)
+	}
+}
+
+func TestUnescapeMountPath(t *testing.T) {
+	if got := unescapeMountPath(`/media/my\040disk`); got != "/media/my disk" {
+		t.Errorf("unescapeMountPath = %q", got)
+	}
+}
+
+func TestWriteTrackerHoursInHalfHourZone(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "writes.json")
+	tracker := newWriteTracker(path, DefaultConfig().WriteBudget)
+	zone := time.FixedZone("IST", 5*3600+1800)
+	now := time.Date(2024, 5, 1, 10, 45, 0, 0, zone)
+	counters := func(sectors uint64) map[string]diskstatsEntry {
+		return map[string]diskstatsEntry{"mmcblk0": {Name: "mmcblk0", WriteSectors: sectors}}
+	}
+
+	tracker.update(counters(100), nil, nil, "boot", now)
+	tracker.update(counters(200), nil, nil, "boot", now.Add(time.Hour))
+	stats, err := tracker.update(counters(300), nil, nil, "boot", now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	hours := stats.Devices["mmcblk0"].Hours
+	if len(hours) != 3 {
+		t.Fatalf("expected 3 hourly samples, got %+v", hours)
+	}
+	for i, sample := range hours {
+		want := time.Date(2024, 5, 1, 10+i, 0, 0, 0, zone)
+		if !sample.Start.Equal(want) {
+			t.Errorf("hour %d starts at %s, want %s", i, sample.Start, want)
+		}
+	}
+
+	// A clean shutdown saves the writes since the last periodic save
+	if err := tracker.save(now.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	var state writeState
+	if err := loadState(path, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Counters["mmcblk0"] != 300*512 {
+		t.Errorf("saved counter = %d, want %d", state.Counters["mmcblk0"], 300*512)
+	}
+}
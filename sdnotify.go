@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends a state such as "READY=1" to the service manager, if it
+// gave us a NOTIFY_SOCKET. Without one it does nothing.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdReloading returns the state that reports the start of a reload. systemd
+// needs the CLOCK_MONOTONIC time with it to tell this reload from a later one.
+func sdReloading() string {
+	if usec := monotonicUsec(); usec > 0 {
+		return "RELOADING=1\nMONOTONIC_USEC=" + strconv.FormatInt(usec, 10)
+	}
+	return "RELOADING=1"
+}
+
+// sdWatchdogInterval returns how often the service manager expects a
+// "WATCHDOG=1" keepalive: half its WATCHDOG_USEC, or 0 when the watchdog is
+// off or meant for another process
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
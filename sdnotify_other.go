@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// monotonicUsec is only needed for systemd, which only Linux has
+func monotonicUsec() int64 {
+	return 0
+}
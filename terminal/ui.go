@@ -2,7 +2,10 @@ package terminal
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"emmon/monitor"
@@ -11,12 +14,29 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Pages of the terminal UI, selected with the number keys or Tab
+const (
+	pageOverview = iota
+	pageKernelLog
+	pageInterrupts
+	pageLimits
+	pageNetwork
+	pageServices
+	pageCustom
+)
+
+// pageNames are the titles of the pages, in page order
+var pageNames = []string{"Overview", "Kernel Log", "Interrupts", "Limits", "Network", "Services", "Custom"}
+
 // TerminalUI handles the terminal interface
 type TerminalUI struct {
 	screen  tcell.Screen
 	monitor *monitor.SystemMonitor
 	log     *logrus.Logger
 	quit    chan struct{}
+	once    sync.Once // closes quit
+	redraw  chan struct{}
+	page    int32 // current page, accessed atomically
 }
 
 // NewTerminalUI creates a new terminal UI instance
@@ -25,6 +45,7 @@ func NewTerminalUI(monitor *monitor.SystemMonitor, log *logrus.Logger) *Terminal
 		monitor: monitor,
 		log:     log,
 		quit:    make(chan struct{}),
+		redraw:  make(chan struct{}, 1),
 	}
 }
 
@@ -54,21 +75,33 @@ func (tui *TerminalUI) Start() error {
 		select {
 		case <-ticker.C:
 			tui.render()
+		case <-tui.redraw:
+			tui.render()
 		case <-tui.quit:
 			return nil
 		}
 	}
 }
 
+// Stop makes Start restore the terminal and return
+func (tui *TerminalUI) Stop() {
+	tui.once.Do(func() { close(tui.quit) })
+}
+
 // handleEvents handles keyboard and mouse events
 func (tui *TerminalUI) handleEvents() {
 	for {
 		event := tui.screen.PollEvent()
 		switch ev := event.(type) {
 		case *tcell.EventKey:
-			if ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyCtrlC {
-				close(tui.quit)
+			switch {
+			case ev.Key() == tcell.KeyEscape || ev.Key() == tcell.KeyCtrlC:
+				tui.Stop()
 				return
+			case ev.Key() == tcell.KeyTab:
+				tui.setPage((int(atomic.LoadInt32(&tui.page)) + 1) % len(pageNames))
+			case ev.Key() == tcell.KeyRune && ev.Rune() >= '1' && ev.Rune() < '1'+rune(len(pageNames)):
+				tui.setPage(int(ev.Rune() - '1'))
 			}
 		case *tcell.EventResize:
 			tui.screen.Sync()
@@ -76,12 +109,21 @@ func (tui *TerminalUI) handleEvents() {
 	}
 }
 
+// setPage switches to the given page and redraws right away
+func (tui *TerminalUI) setPage(page int) {
+	atomic.StoreInt32(&tui.page, int32(page))
+	select {
+	case tui.redraw <- struct{}{}:
+	default:
+	}
+}
+
 // render renders the current system stats
 func (tui *TerminalUI) render() {
 	tui.screen.Clear()
 
 	// Get system stats
-	stats, err := tui.monitor.GetSystemStats()
+	stats, err := tui.monitor.LatestStats()
 	if err != nil {
 		tui.log.Errorf("Failed to get system stats: %v", err)
 		return
@@ -92,12 +134,43 @@ func (tui *TerminalUI) render() {
 
 	// Draw header
 	tui.drawHeader(width)
+	tui.drawServiceAlert(stats.Services, width)
+
+	switch atomic.LoadInt32(&tui.page) {
+	case pageKernelLog:
+		tui.drawKernelLog(stats.Kernel, 0, 3, width, height-4)
+	case pageInterrupts:
+		tui.drawInterrupts(stats.Interrupts, 0, 3, width, height-4)
+	case pageLimits:
+		tui.drawLimits(stats.Limits, 0, 3, width)
+	case pageNetwork:
+		tui.drawNetConfig(stats.NetConfig, stats.Probes, 0, 3, width, height-4)
+	case pageServices:
+		rows := tui.drawServices(stats.Services, 0, 3, width)
+		tui.drawWatchdog(stats.Watchdog, 0, 4+rows, width)
+	case pageCustom:
+		tui.drawCustom(stats.Custom, stats.Plugins, 0, 3, width/2, height-4)
+		tui.drawFileMetrics(stats.Files, stats.Derived, width/2, 3, width/2, height-4)
+	default:
+		tui.drawOverview(stats, width)
+	}
+
+	// Draw footer
+	tui.drawFooter(width, height)
 
+	// Show the screen
+	tui.screen.Show()
+}
+
+// drawOverview draws the main page with all system sections
+func (tui *TerminalUI) drawOverview(stats *monitor.SystemStats, width int) {
 	// Draw CPU section
 	tui.drawCPU(stats.CPU, 0, 3, width)
+	tui.drawScheduler(stats.Interrupts, 0, 7)
 
 	// Draw Memory section
 	tui.drawMemory(stats.Memory, 0, 12, width)
+	tui.drawVM(stats.VM, 0, 18)
 
 	// Draw Disk section
 	tui.drawDisk(stats.Disk, 0, 21, width)
@@ -108,17 +181,27 @@ func (tui *TerminalUI) render() {
 	// Draw GPIO section
 	tui.drawGPIO(stats.GPIO, width/2, 12, width/2)
 
-	// Draw footer
-	tui.drawFooter(width, height)
+	// Draw Storage health section
+	tui.drawStorage(stats.Storage, width/2, 21, width/2)
 
-	// Show the screen
-	tui.screen.Show()
+	// Draw write volume section
+	tui.drawWrites(stats.Writes, 0, 28, width)
 }
 
 // drawHeader draws the application header
 func (tui *TerminalUI) drawHeader(width int) {
-	title := "🧠 Embedded Linux Monitor"
-	subtitle := "Press ESC or Ctrl+C to exit"
+	inv := tui.monitor.GetInventory()
+
+	title := "🧠 Embedded Linux Monitor - " + inv.Hostname
+	identity := []string{}
+	if inv.Model != "" {
+		identity = append(identity, inv.Model)
+	}
+	if inv.Serial != "" {
+		identity = append(identity, "S/N "+inv.Serial)
+	}
+	identity = append(identity, "Linux "+inv.KernelRelease, "up "+tui.formatUptime(inv.Uptime))
+	subtitle := strings.Join(identity, " | ")
 
 	// Center the title
 	titleX := (width - len(title)) / 2
@@ -140,6 +223,28 @@ func (tui *TerminalUI) drawHeader(width int) {
 	tui.drawText(0, 2, separator, tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
 }
 
+// drawServiceAlert names the watched services that are down on the header
+// separator, so they stand out on every page
+func (tui *TerminalUI) drawServiceAlert(services map[string]monitor.ServiceStatus, width int) {
+	var down []string
+	for name, service := range services {
+		if !service.Up {
+			down = append(down, name)
+		}
+	}
+	if len(down) == 0 {
+		return
+	}
+	sort.Strings(down)
+
+	alert := " SERVICES DOWN: " + strings.Join(down, ", ") + " "
+	alertX := (width - len(alert)) / 2
+	if alertX < 0 {
+		alertX = 0
+	}
+	tui.drawText(alertX, 2, alert, tcell.ColorWhite, tcell.ColorRed, tcell.StyleDefault.Bold(true))
+}
+
 // drawCPU draws CPU information
 func (tui *TerminalUI) drawCPU(cpu monitor.CPUStats, x, y, width int) {
 	tui.drawText(x, y, "CPU", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
@@ -163,6 +268,19 @@ func (tui *TerminalUI) drawCPU(cpu monitor.CPUStats, x, y, width int) {
 	tui.drawText(x, y+3, freqText, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
 }
 
+// drawScheduler draws the scheduler and interrupt rates below the CPU section
+func (tui *TerminalUI) drawScheduler(irq monitor.InterruptStats, x, y int) {
+	schedText := fmt.Sprintf("Ctx:  %8.0f/s  Forks: %6.1f/s", irq.ContextSwitches, irq.Forks)
+	tui.drawText(x, y, schedText, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+
+	procsText := fmt.Sprintf("IRQ:  %8.0f/s  Procs: %d run, %d blocked", irq.Total, irq.ProcsRunning, irq.ProcsBlocked)
+	color := tcell.ColorWhite
+	if irq.ProcsBlocked > 0 {
+		color = tcell.ColorOrange
+	}
+	tui.drawText(x, y+1, procsText, color, tcell.ColorDefault, tcell.StyleDefault)
+}
+
 // drawMemory draws memory information
 func (tui *TerminalUI) drawMemory(mem monitor.MemStats, x, y, width int) {
 	tui.drawText(x, y, "Memory", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
@@ -188,9 +306,29 @@ func (tui *TerminalUI) drawMemory(mem monitor.MemStats, x, y, width int) {
 	tui.drawText(x, y+5, availText, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
 }
 
+// drawVM draws the page fault, swap and OOM rates below the memory section
+func (tui *TerminalUI) drawVM(vm monitor.VMStats, x, y int) {
+	faultText := fmt.Sprintf("Faults: %8.0f/s  Major: %6.1f/s", vm.PageFaults, vm.MajorFaults)
+	color := tcell.ColorWhite
+	if vm.MajorFaults > 0 {
+		color = tcell.ColorOrange
+	}
+	tui.drawText(x, y, faultText, color, tcell.ColorDefault, tcell.StyleDefault)
+
+	swapText := fmt.Sprintf("Swap:  %5.0f in %5.0f out/s  OOM kills: %d", vm.SwapIn, vm.SwapOut, vm.OOMKillsTotal)
+	color = tcell.ColorWhite
+	if vm.OOMKills > 0 {
+		color = tcell.ColorRed
+	}
+	tui.drawText(x, y+1, swapText, color, tcell.ColorDefault, tcell.StyleDefault)
+}
+
 // drawDisk draws disk information
 func (tui *TerminalUI) drawDisk(disk monitor.DiskStats, x, y, width int) {
 	tui.drawText(x, y, "Disk", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	if disk.ReadOnly {
+		tui.drawText(x+5, y, "READ-ONLY", tcell.ColorRed, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	}
 
 	// Disk Usage
 	usageText := fmt.Sprintf("Usage: %6.1f%%", disk.UsagePercent)
@@ -269,6 +407,530 @@ func (tui *TerminalUI) drawGPIO(gpio monitor.GPIOStats, x, y, width int) {
 	}
 }
 
+// drawStorage draws flash storage health information
+func (tui *TerminalUI) drawStorage(storage monitor.StorageStats, x, y, width int) {
+	tui.drawText(x, y, "Storage Health", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+
+	if len(storage.Devices) == 0 {
+		tui.drawText(x, y+1, "No eMMC/SD devices", tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+		return
+	}
+
+	names := make([]string, 0, len(storage.Devices))
+	for name := range storage.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	row := 1
+	for _, name := range names {
+		if row >= 7 { // Limit display to 3 devices
+			break
+		}
+		dev := storage.Devices[name]
+
+		wearText := fmt.Sprintf("%s: wear %3.0f%% EOL %s", name, dev.WearPercent, dev.PreEOLState)
+		color := tcell.ColorGreen
+		switch {
+		case dev.PreEOL >= 3 || dev.WearPercent >= 90:
+			color = tcell.ColorRed
+		case dev.PreEOL == 2 || dev.WearPercent >= 70:
+			color = tcell.ColorOrange
+		}
+		tui.drawText(x, y+row, wearText, color, tcell.ColorDefault, tcell.StyleDefault)
+
+		lifeText := "life: unknown"
+		if dev.DaysLeft > 0 {
+			lifeText = fmt.Sprintf("life: ~%.0f days", dev.DaysLeft)
+		}
+		writtenText := fmt.Sprintf("  W boot:%s total:%s %s",
+			tui.formatBytes(dev.BytesWrittenBoot), tui.formatBytes(dev.BytesWrittenTotal), lifeText)
+		tui.drawText(x, y+row+1, writtenText, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+		row += 2
+	}
+}
+
+// drawWrites draws the write volume per mount and the top writing processes
+func (tui *TerminalUI) drawWrites(writes monitor.WriteStats, x, y, width int) {
+	tui.drawText(x, y, "Writes Today", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+
+	mounts := make([]string, 0, len(writes.Mounts))
+	for mount := range writes.Mounts {
+		mounts = append(mounts, mount)
+	}
+	sort.Strings(mounts)
+
+	row := 1
+	for _, mount := range mounts {
+		if row > 4 { // Limit display to 4 mounts
+			break
+		}
+		volume := writes.Mounts[mount]
+
+		text := fmt.Sprintf("%-12s hour:%-10s today:%-10s", mount, tui.formatBytes(volume.Hour), tui.formatBytes(volume.Today))
+		color := tcell.ColorWhite
+		if volume.Budget > 0 {
+			text += fmt.Sprintf(" budget:%5.1f%%", volume.BudgetPercent)
+			if volume.OverBudget {
+				color = tcell.ColorRed
+			}
+		}
+		tui.drawText(x, y+row, text, color, tcell.ColorDefault, tcell.StyleDefault)
+		row++
+	}
+
+	for i, writer := range writes.TopWriters {
+		if i >= 3 { // Limit display to 3 processes
+			break
+		}
+		text := fmt.Sprintf("%-16s %10s today %10s/s", writer.Name, tui.formatBytes(writer.Today), tui.formatBytes(uint64(writer.BytesPerSec)))
+		tui.drawText(width/2, y+1+i, text, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+	}
+}
+
+// drawKernelLog draws the kernel log counters and the most recent messages
+func (tui *TerminalUI) drawKernelLog(kernel monitor.KernelLogStats, x, y, width, height int) {
+	tui.drawText(x, y, "Kernel Log", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+
+	if kernel.Error != "" {
+		tui.drawText(x, y+1, "Unavailable: "+kernel.Error, tcell.ColorRed, tcell.ColorDefault, tcell.StyleDefault)
+		return
+	}
+
+	names := make([]string, 0, len(kernel.Counters))
+	for name := range kernel.Counters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	counters := make([]string, 0, len(names))
+	for _, name := range names {
+		counters = append(counters, fmt.Sprintf("%s:%d/%d", name, kernel.Recent[name], kernel.Counters[name]))
+	}
+	tui.drawText(x, y+1, "Recent/total: "+strings.Join(counters, "  "), tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+
+	messages := tui.monitor.KernelMessages()
+	rows := height - 3
+	if rows < 0 {
+		rows = 0
+	}
+	if len(messages) > rows {
+		messages = messages[len(messages)-rows:]
+	}
+
+	for i, msg := range messages {
+		color := tcell.ColorWhite
+		switch {
+		case msg.Priority <= 3:
+			color = tcell.ColorRed
+		case msg.Priority == 4:
+			color = tcell.ColorOrange
+		case msg.Rule != "":
+			color = tcell.ColorYellow
+		}
+
+		line := fmt.Sprintf("[%12.3f] %-7s %s", msg.Uptime, msg.Level, msg.Message)
+		if len(line) > width {
+			line = line[:width]
+		}
+		tui.drawText(x, y+3+i, line, color, tcell.ColorDefault, tcell.StyleDefault)
+	}
+}
+
+// drawLimits draws the usage of the kernel tables against their limits
+func (tui *TerminalUI) drawLimits(limits monitor.LimitsStats, x, y, width int) {
+	tui.drawText(x, y, "Kernel Limits", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+
+	row := 1
+	drawUsage := func(name string, usage monitor.LimitUsage) {
+		text := fmt.Sprintf("%-12s %10d / %-10d %6.1f%%", name, usage.Used, usage.Max, usage.Percent)
+		tui.drawText(x, y+row, text, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+		tui.drawProgressBar(x+len(text)+2, y+row, usage.Percent, 20)
+		row++
+	}
+	drawUsage("Files", limits.Files)
+	drawUsage("PIDs", limits.PIDs)
+	if limits.Conntrack != nil {
+		drawUsage("Conntrack", *limits.Conntrack)
+	}
+	drawUsage("Entropy", limits.Entropy)
+
+	row++
+	tui.drawText(x, y+row, "Sockets", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	row++
+	sockets := limits.Sockets
+	lines := []string{
+		fmt.Sprintf("Used: %d  TCP in use: %d  UDP in use: %d", sockets.Used, sockets.TCPInUse, sockets.UDPInUse),
+		fmt.Sprintf("Orphans: %d (%.1f%%)  Time-wait: %d (%.1f%%)", sockets.TCPOrphan, sockets.OrphanPercent,
+			sockets.TCPTimeWait, sockets.TimeWaitPercent),
+		fmt.Sprintf("TCP memory: %s (%.1f%%)", tui.formatBytes(sockets.TCPMemory), sockets.MemoryPercent),
+	}
+	for _, line := range lines {
+		tui.drawText(x, y+row, line, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+		row++
+	}
+
+	row++
+	tui.drawText(x, y+row, "TCP States", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	row++
+	states := make([]string, 0, len(limits.TCPStates))
+	for state := range limits.TCPStates {
+		states = append(states, state)
+	}
+	sort.Strings(states)
+
+	col := 0
+	for _, state := range states {
+		text := fmt.Sprintf("%-12s %6d", state, limits.TCPStates[state])
+		tui.drawText(x+col, y+row, text, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+		col += 22
+		if col+22 > width {
+			col = 0
+			row++
+		}
+	}
+}
+
+// drawNetConfig draws the addresses, routes, DNS servers and probe results
+func (tui *TerminalUI) drawNetConfig(nc monitor.NetConfigStats, probes map[string]monitor.ProbeResult, x, y, width, height int) {
+	tui.drawText(x, y, "Default Route", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	if nc.DefaultRoute {
+		gateway := nc.DefaultGateway
+		if gateway == "" {
+			gateway = "direct"
+		}
+		tui.drawText(x+15, y, fmt.Sprintf("via %s on %s", gateway, nc.DefaultInterface),
+			tcell.ColorGreen, tcell.ColorDefault, tcell.StyleDefault)
+	} else {
+		tui.drawText(x+15, y, "none", tcell.ColorRed, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	}
+
+	dns := strings.Join(nc.DNSServers, ", ")
+	if dns == "" {
+		dns = "none"
+	}
+	tui.drawText(x, y+1, "DNS", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	tui.drawText(x+15, y+1, dns, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+	if len(nc.SearchDomains) > 0 {
+		tui.drawText(x+15+len(dns)+2, y+1, "search "+strings.Join(nc.SearchDomains, " "),
+			tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+	}
+
+	row := 3
+	if len(probes) > 0 {
+		tui.drawText(x, y+row, "Probes", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+		row++
+		names := make([]string, 0, len(probes))
+		for name := range probes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			probe := probes[name]
+			color := tcell.ColorGreen
+			state := "up"
+			switch {
+			case probe.Checks == 0:
+				color = tcell.ColorGray
+				state = "?"
+			case !probe.Up:
+				color = tcell.ColorRed
+				state = "down"
+			}
+			line := fmt.Sprintf("%-16s %-5s %-4s %8.1f ms %5.0f%%  %s %s", name, probe.Type, state,
+				probe.LatencyMs, probe.SuccessRatio*100, probe.Target, probe.LastError)
+			if len(line) > width {
+				line = line[:width]
+			}
+			tui.drawText(x, y+row, line, color, tcell.ColorDefault, tcell.StyleDefault)
+			row++
+		}
+		row++
+	}
+
+	tui.drawText(x, y+row, "Addresses", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	row++
+	for _, iface := range nc.Interfaces {
+		color := tcell.ColorWhite
+		state := "up"
+		if !iface.Up {
+			color = tcell.ColorGray
+			state = "down"
+		}
+		line := fmt.Sprintf("%-12s %-5s %s", iface.Name, state, strings.Join(iface.Addresses, " "))
+		if len(line) > width {
+			line = line[:width]
+		}
+		tui.drawText(x, y+row, line, color, tcell.ColorDefault, tcell.StyleDefault)
+		row++
+	}
+
+	row++
+	tui.drawText(x, y+row, "Routes", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	row++
+	tui.drawText(x, y+row, fmt.Sprintf("%-44s %-40s %-12s %s", "Destination", "Gateway", "Interface", "Metric"),
+		tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+	row++
+	for _, route := range nc.Routes {
+		if row >= height {
+			break
+		}
+		line := fmt.Sprintf("%-44s %-40s %-12s %d", route.Destination, route.Gateway, route.Interface, route.Metric)
+		if len(line) > width {
+			line = line[:width]
+		}
+		tui.drawText(x, y+row, line, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+		row++
+	}
+}
+
+// drawServices draws the state of the watched services
+func (tui *TerminalUI) drawServices(services map[string]monitor.ServiceStatus, x, y, width int) int {
+	tui.drawText(x, y, "Services", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	if len(services) == 0 {
+		tui.drawText(x, y+1, "No services configured", tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+		return 2
+	}
+
+	tui.drawText(x, y+1, fmt.Sprintf("%-20s %-5s %8s %8s %12s %7s %10s", "Name", "State", "PID", "Restarts",
+		"Uptime", "CPU", "RSS"), tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		service := services[name]
+		var line string
+		color := tcell.ColorGreen
+		if service.Up {
+			line = fmt.Sprintf("%-20s %-5s %8d %8d %12s %6.1f%% %10s", name, "up", service.PID, service.Restarts,
+				tui.formatUptime(service.Uptime), service.CPUPercent, tui.formatBytes(service.RSS))
+			if service.Restarts > 0 {
+				color = tcell.ColorOrange
+			}
+		} else {
+			line = fmt.Sprintf("%-20s %-5s %8s %8d", name, "DOWN", "-", service.Restarts)
+			color = tcell.ColorRed
+		}
+		if len(line) > width {
+			line = line[:width]
+		}
+		tui.drawText(x, y+2+i, line, color, tcell.ColorDefault, tcell.StyleDefault)
+	}
+	return 2 + len(names)
+}
+
+// drawWatchdog draws the state of the owned watchdog and the watchdogs in sysfs
+func (tui *TerminalUI) drawWatchdog(wd monitor.WatchdogStats, x, y, width int) {
+	tui.drawText(x, y, "Watchdog", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+
+	row := 1
+	switch {
+	case !wd.Enabled:
+		tui.drawText(x, y+row, "Not armed by emmon", tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+	case wd.Healthy:
+		text := "Armed, petting"
+		if !wd.LastPet.IsZero() {
+			text += fmt.Sprintf(" (last %s ago)", time.Since(wd.LastPet).Round(time.Second))
+		}
+		tui.drawText(x, y+row, text, tcell.ColorGreen, tcell.ColorDefault, tcell.StyleDefault)
+	default:
+		tui.drawText(x, y+row, "Armed, NOT PETTING", tcell.ColorRed, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+		for _, reason := range wd.Reasons {
+			row++
+			line := "  " + reason
+			if len(line) > width {
+				line = line[:width]
+			}
+			tui.drawText(x, y+row, line, tcell.ColorRed, tcell.ColorDefault, tcell.StyleDefault)
+		}
+	}
+	row++
+
+	names := make([]string, 0, len(wd.Devices))
+	for name := range wd.Devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		device := wd.Devices[name]
+		state := "inactive"
+		if device.Active {
+			state = "active"
+		}
+		line := fmt.Sprintf("%-10s %-8s timeout %3ds  left %3ds  %s", name, state, device.Timeout, device.TimeLeft,
+			device.Identity)
+		if device.Nowayout {
+			line += " (nowayout)"
+		}
+		if len(line) > width {
+			line = line[:width]
+		}
+		tui.drawText(x, y+row, line, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+		row++
+	}
+}
+
+// drawCustom draws the metrics reported by the exec plugins
+func (tui *TerminalUI) drawCustom(custom map[string]map[string]float64, plugins map[string]monitor.PluginStatus,
+	x, y, width, height int) {
+	tui.drawText(x, y, "Custom Metrics", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	if len(plugins) == 0 {
+		tui.drawText(x, y+1, "No plugins configured", tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+		return
+	}
+
+	names := make([]string, 0, len(plugins))
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	row := 1
+	for _, name := range names {
+		if row >= height {
+			break
+		}
+		status := plugins[name]
+		header := fmt.Sprintf("%s (%.0f ms)", name, status.DurationMs)
+		color := tcell.ColorGreen
+		if !status.OK {
+			header = fmt.Sprintf("%s: %s", name, status.Error)
+			color = tcell.ColorRed
+		}
+		if len(header) > width {
+			header = header[:width]
+		}
+		tui.drawText(x, y+row, header, color, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+		row++
+
+		metrics := make([]string, 0, len(custom[name]))
+		for metric := range custom[name] {
+			metrics = append(metrics, metric)
+		}
+		sort.Strings(metrics)
+		for _, metric := range metrics {
+			if row >= height {
+				break
+			}
+			line := fmt.Sprintf("  %-40s %14.2f", metric, custom[name][metric])
+			tui.drawText(x, y+row, line, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+			row++
+		}
+	}
+}
+
+// drawFileMetrics draws the values read from the configured files and, below
+// them, the derived metrics
+func (tui *TerminalUI) drawFileMetrics(files map[string]monitor.FileMetric, derived map[string]monitor.DerivedMetric,
+	x, y, width, height int) {
+	values := make(map[string]monitor.FileMetric, len(files))
+	for name, metric := range files {
+		values[name] = metric
+	}
+	rows := tui.drawMetricValues("File Metrics", "No file metrics configured", values, x, y, width, height)
+
+	values = make(map[string]monitor.FileMetric, len(derived))
+	for name, metric := range derived {
+		values[name] = monitor.FileMetric{Value: metric.Value, Unit: metric.Unit, Error: metric.Error}
+	}
+	tui.drawMetricValues("Derived Metrics", "No derived metrics configured", values, x, y+rows+1, width, height-rows-1)
+}
+
+// drawMetricValues draws a titled list of named values and returns the rows used
+func (tui *TerminalUI) drawMetricValues(title, empty string, values map[string]monitor.FileMetric, x, y, width, height int) int {
+	if height < 2 {
+		return 0
+	}
+	tui.drawText(x, y, title, tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	if len(values) == 0 {
+		tui.drawText(x, y+1, empty, tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+		return 2
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := 1
+	for i, name := range names {
+		if i+1 >= height {
+			break
+		}
+		metric := values[name]
+		line := fmt.Sprintf("%-24s %12.3f %s", name, metric.Value, metric.Unit)
+		color := tcell.ColorWhite
+		if metric.Error != "" {
+			line = fmt.Sprintf("%-24s %s", name, metric.Error)
+			color = tcell.ColorRed
+		}
+		if len(line) > width {
+			line = line[:width]
+		}
+		tui.drawText(x, y+1+i, line, color, tcell.ColorDefault, tcell.StyleDefault)
+		rows++
+	}
+	return rows
+}
+
+// drawInterrupts draws the busiest IRQ sources and the softirq rates
+func (tui *TerminalUI) drawInterrupts(irq monitor.InterruptStats, x, y, width, height int) {
+	tui.drawText(x, y, "Top IRQ Sources", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	tui.drawText(x, y+1, fmt.Sprintf("%-6s %10s  %-30s %s", "IRQ", "Rate/s", "Devices", "Per CPU"),
+		tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+
+	row := 2
+	for _, source := range irq.Top {
+		if row >= height-8 {
+			break
+		}
+		perCPU := make([]string, len(source.PerCPU))
+		for i, rate := range source.PerCPU {
+			perCPU[i] = fmt.Sprintf("%.0f", rate)
+		}
+		devices := source.Devices
+		if len(devices) > 30 {
+			devices = devices[:30]
+		}
+		line := fmt.Sprintf("%-6s %10.0f  %-30s %s", source.IRQ, source.Rate, devices, strings.Join(perCPU, " "))
+		if len(line) > width {
+			line = line[:width]
+		}
+		tui.drawText(x, y+row, line, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+		row++
+	}
+	if len(irq.Top) == 0 {
+		tui.drawText(x, y+row, "No interrupt activity yet", tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+		row++
+	}
+
+	row++
+	tui.drawText(x, y+row, "Softirqs", tcell.ColorYellow, tcell.ColorDefault, tcell.StyleDefault.Bold(true))
+	names := make([]string, 0, len(irq.SoftIRQs))
+	for name := range irq.SoftIRQs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	col := 0
+	row++
+	for _, name := range names {
+		text := fmt.Sprintf("%-8s %8.0f/s", name, irq.SoftIRQs[name])
+		tui.drawText(x+col, y+row, text, tcell.ColorWhite, tcell.ColorDefault, tcell.StyleDefault)
+		col += 22
+		if col+22 > width {
+			col = 0
+			row++
+		}
+	}
+}
+
 // drawFooter draws the footer with timestamp
 func (tui *TerminalUI) drawFooter(width, height int) {
 	timestamp := time.Now().Format("2006-01-02 15:04:05")
@@ -276,6 +938,28 @@ func (tui *TerminalUI) drawFooter(width, height int) {
 
 	// Draw at bottom of screen
 	tui.drawText(0, height-1, timestampText, tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+
+	page := atomic.LoadInt32(&tui.page)
+	pageText := fmt.Sprintf("Page %d/%d: %s - 1-%d or Tab to switch, ESC or Ctrl+C to exit",
+		page+1, len(pageNames), pageNames[page], len(pageNames))
+	pageX := width - len(pageText)
+	if pageX < len(timestampText)+1 {
+		pageX = len(timestampText) + 1
+	}
+	tui.drawText(pageX, height-1, pageText, tcell.ColorGray, tcell.ColorDefault, tcell.StyleDefault)
+}
+
+// formatUptime formats an uptime in seconds as days, hours and minutes
+func (tui *TerminalUI) formatUptime(seconds float64) string {
+	d := time.Duration(seconds) * time.Second
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	}
+	return fmt.Sprintf("%dh %dm", hours, minutes)
 }
 
 // drawText draws text at the specified position
@@ -1,9 +1,17 @@
 package web
 
 import (
+	"encoding/binary"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -38,18 +46,38 @@ func NewWebServer(port string, log *logrus.Logger, monitor *monitor.SystemMonito
 	}
 }
 
-// Start starts the web server
+// Start starts the web server, sampling the stats for the WebSocket clients
 func (ws *WebServer) Start() error {
+	listener, err := ws.Listen()
+	if err != nil {
+		return err
+	}
+
+	return ws.Serve(listener)
+}
+
+// Listen opens the port of the web server
+func (ws *WebServer) Listen() (net.Listener, error) {
+	return net.Listen("tcp", ":"+ws.port)
+}
+
+// Serve serves the web interface and API on listener
+func (ws *WebServer) Serve(listener net.Listener) error {
+	// Start WebSocket broadcast goroutine
+	go ws.broadcastStats()
+
 	// Serve static files
 	http.HandleFunc("/", ws.handleIndex)
 	http.HandleFunc("/ws", ws.handleWebSocket)
 	http.HandleFunc("/api/stats", ws.handleStats)
-
-	// Start WebSocket broadcast goroutine
-	go ws.broadcastStats()
+	http.HandleFunc("/api/kmsg", ws.handleKernelLog)
+	http.HandleFunc("/api/events", ws.handleEvents)
+	http.HandleFunc("/api/inventory", ws.handleInventory)
+	http.HandleFunc("/api/v1/history", ws.handleHistory)
+	http.HandleFunc("/api/v1/bundle", ws.handleBundle)
 
 	ws.log.Infof("Starting web server on port %s", ws.port)
-	return http.ListenAndServe(":"+ws.port, nil)
+	return http.Serve(listener, nil)
 }
 
 // handleIndex serves the main HTML page
@@ -98,7 +126,7 @@ func (ws *WebServer) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 // handleStats serves current system stats as JSON
 func (ws *WebServer) handleStats(w http.ResponseWriter, r *http.Request) {
-	stats, err := ws.monitor.GetSystemStats()
+	stats, err := ws.monitor.LatestStats()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -108,27 +136,273 @@ func (ws *WebServer) handleStats(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
-// broadcastStats broadcasts system stats to all connected WebSocket clients
-func (ws *WebServer) broadcastStats() {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+// handleKernelLog serves the recent kernel log messages as JSON
+func (ws *WebServer) handleKernelLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ws.monitor.KernelMessages())
+}
+
+// handleEvents serves the recent events as JSON
+func (ws *WebServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ws.monitor.RecentEvents())
+}
+
+// handleInventory serves the device inventory as JSON
+func (ws *WebServer) handleInventory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ws.monitor.GetInventory())
+}
+
+// historySeries is the history of one metric in a history response
+type historySeries struct {
+	Metric string                  `json:"metric"`
+	Points []monitor.HistorySample `json:"points"`
+}
+
+// historyResponse is the JSON body of /api/v1/history
+type historyResponse struct {
+	Start       time.Time       `json:"start"`
+	End         time.Time       `json:"end"`
+	Step        float64         `json:"step"` // seconds
+	Aggregation string          `json:"aggregation"`
+	Series      []historySeries `json:"series"`
+}
 
-	for range ticker.C {
-		stats, err := ws.monitor.GetSystemStats()
+// maxHistoryPoints limits the points of one series in a history response
+const maxHistoryPoints = 10000
+
+// handleHistory serves the metrics history. Without a metric it lists the
+// metrics and tiers kept; otherwise it returns the metrics between start and
+// end, aggregated per step, as JSON, with format=csv as CSV, or with
+// format=gorilla in the compact binary encoding.
+func (ws *WebServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	history := ws.monitor.History()
+	if history == nil {
+		http.Error(w, "history is disabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	var metrics []string
+	for _, value := range query["metric"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				metrics = append(metrics, name)
+			}
+		}
+	}
+	if len(metrics) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"metrics": history.Metrics(),
+			"tiers":   history.Tiers(),
+			"stats":   history.Stats(),
+		})
+		return
+	}
+
+	now := time.Now()
+	end, err := parseHistoryTime(query.Get("end"), now, now)
+	if err != nil {
+		http.Error(w, "invalid end: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	start, err := parseHistoryTime(query.Get("start"), end.Add(-time.Hour), now)
+	if err != nil {
+		http.Error(w, "invalid start: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !start.Before(end) {
+		http.Error(w, "start must be before end", http.StatusBadRequest)
+		return
+	}
+	var step time.Duration
+	if value := query.Get("step"); value != "" {
+		if step, err = parseHistoryDuration(value); err != nil || step < 0 {
+			http.Error(w, "invalid step: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+	aggregation := query.Get("agg")
+	if aggregation == "" {
+		aggregation = "avg"
+	}
+
+	// Refuse an oversized range before doing any of the work
+	used, err := history.Step(start, step)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if end.Sub(start)/used > maxHistoryPoints {
+		http.Error(w, fmt.Sprintf("more than %d points per metric, use a larger step", maxHistoryPoints),
+			http.StatusBadRequest)
+		return
+	}
+
+	response := historyResponse{Start: start, End: end, Aggregation: aggregation, Step: used.Seconds()}
+	for _, metric := range metrics {
+		points, _, err := history.Range(metric, start, end, step, aggregation)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, monitor.ErrNoHistory) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		response.Series = append(response.Series, historySeries{Metric: metric, Points: points})
+	}
+
+	switch query.Get("format") {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=history.csv")
+		writeHistoryCSV(w, response.Series)
+		return
+	case "gorilla":
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", "attachment; filename=history.emg")
+		writeHistoryGorilla(w, response.Series)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// parseHistoryTime parses an RFC 3339 time, Unix seconds, or a duration
+// before now such as "12h" or "-12h". An empty value gives def.
+func parseHistoryTime(value string, def, now time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+	d, err := parseHistoryDuration(strings.TrimPrefix(value, "-"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a time, Unix seconds or duration", value)
+	}
+	return now.Add(-d), nil
+}
+
+// parseHistoryDuration parses a Go duration, also accepting days such as "7d"
+func parseHistoryDuration(value string) (time.Duration, error) {
+	if days := strings.TrimSuffix(value, "d"); days != value {
+		n, err := strconv.ParseFloat(days, 64)
 		if err != nil {
-			ws.log.Errorf("Failed to get system stats: %v", err)
-			continue
+			return 0, err
 		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(value)
+}
 
-		ws.mu.RLock()
-		for client := range ws.clients {
-			err := client.WriteJSON(stats)
-			if err != nil {
-				ws.log.Errorf("Failed to send stats to client: %v", err)
-				client.Close()
-				delete(ws.clients, client)
+// writeHistoryCSV writes one row per time with a column per metric. Cells
+// of metrics without a value at that time are left empty.
+func writeHistoryCSV(w io.Writer, series []historySeries) {
+	values := make(map[time.Time][]string)
+	var times []time.Time
+	for i, s := range series {
+		for _, point := range s.Points {
+			row, ok := values[point.Time]
+			if !ok {
+				row = make([]string, len(series))
+				values[point.Time] = row
+				times = append(times, point.Time)
 			}
+			row[i] = strconv.FormatFloat(point.Value, 'g', -1, 64)
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	out := csv.NewWriter(w)
+	header := []string{"time"}
+	for _, s := range series {
+		header = append(header, s.Metric)
+	}
+	out.Write(header)
+	for _, t := range times {
+		out.Write(append([]string{t.UTC().Format(time.RFC3339)}, values[t]...))
+	}
+	out.Flush()
+}
+
+// historyGorillaMagic starts a binary history export
+const historyGorillaMagic = "EMG1"
+
+// writeHistoryGorilla writes the magic and the number of series, then per
+// series the name and the samples as written by monitor.GorillaEncoder, with
+// timestamps in Unix milliseconds. Lengths and counts are uvarints.
+func writeHistoryGorilla(w io.Writer, series []historySeries) {
+	var buf []byte
+	putUvarint := func(v uint64) {
+		var n [binary.MaxVarintLen64]byte
+		buf = append(buf, n[:binary.PutUvarint(n[:], v)]...)
+	}
+
+	buf = append(buf, historyGorillaMagic...)
+	putUvarint(uint64(len(series)))
+	for _, s := range series {
+		enc := monitor.NewGorillaEncoder()
+		for _, point := range s.Points {
+			enc.Append(point.Time.UnixNano()/int64(time.Millisecond), point.Value)
+		}
+		data := enc.Bytes()
+		putUvarint(uint64(len(s.Metric)))
+		buf = append(buf, s.Metric...)
+		putUvarint(uint64(len(data)))
+		buf = append(buf, data...)
+	}
+	w.Write(buf)
+}
+
+// handleBundle serves a diagnostic bundle as a tar.gz download, with the
+// history of the period given by history, e.g. "24h" or "2d"
+func (ws *WebServer) handleBundle(w http.ResponseWriter, r *http.Request) {
+	var history time.Duration
+	if value := r.URL.Query().Get("history"); value != "" {
+		var err error
+		if history, err = parseHistoryDuration(value); err != nil || history <= 0 {
+			http.Error(w, "invalid history: "+value, http.StatusBadRequest)
+			return
+		}
+	}
+
+	name := monitor.BundleName(time.Now())
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename="+name+".tar.gz")
+	if _, err := ws.monitor.WriteBundle(w, name, history); err != nil {
+		ws.log.Errorf("Failed to write bundle: %v", err)
+	}
+}
+
+// broadcastStats broadcasts every sample to all connected WebSocket clients
+func (ws *WebServer) broadcastStats() {
+	samples, cancel := ws.monitor.Subscribe()
+	defer cancel()
+
+	for stats := range samples {
+		ws.broadcast(stats)
+	}
+}
+
+// broadcast sends stats to all connected WebSocket clients
+func (ws *WebServer) broadcast(stats *monitor.SystemStats) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	for client := range ws.clients {
+		err := client.WriteJSON(stats)
+		if err != nil {
+			ws.log.Errorf("Failed to send stats to client: %v", err)
+			client.Close()
+			delete(ws.clients, client)
 		}
-		ws.mu.RUnlock()
 	}
 }
@@ -35,6 +35,11 @@ func GetHTML() string {
             padding-bottom: 10px;
         }
         
+        .identity {
+            color: #00cc00;
+            margin: 5px 0 10px;
+        }
+        
         .grid {
             display: grid;
             grid-template-columns: repeat(auto-fit, minmax(300px, 1fr));
@@ -121,6 +126,36 @@ func GetHTML() string {
             color: #000;
         }
         
+        .alert {
+            display: none;
+            text-align: center;
+            padding: 10px;
+            margin-bottom: 20px;
+            border-radius: 5px;
+            background: #ff0000;
+            color: #fff;
+            font-weight: bold;
+        }
+        
+        .log-view {
+            max-height: 300px;
+            overflow-y: auto;
+            white-space: pre-wrap;
+            font-size: 12px;
+        }
+        
+        .log-line.err {
+            color: #ff0000;
+        }
+        
+        .log-line.warning {
+            color: #ffaa00;
+        }
+        
+        .log-line.matched {
+            font-weight: bold;
+        }
+        
         @media (max-width: 768px) {
             body {
                 font-size: 12px;
@@ -137,9 +172,12 @@ func GetHTML() string {
     <div class="container">
         <div class="header">
             <h1>🧠 Embedded Linux Monitor</h1>
+            <div id="identity" class="identity">--</div>
             <div id="status" class="status disconnected">Disconnected</div>
         </div>
         
+        <div id="service-alert" class="alert"></div>
+        
         <div class="grid">
             <div class="card">
                 <h3>CPU</h3>
@@ -166,6 +204,18 @@ func GetHTML() string {
                     <span>Frequency:</span>
                     <span id="cpu-freq">--</span>
                 </div>
+                <div class="metric">
+                    <span>Context Switches:</span>
+                    <span id="cpu-ctxt">--</span>
+                </div>
+                <div class="metric">
+                    <span>Forks:</span>
+                    <span id="cpu-forks">--</span>
+                </div>
+                <div class="metric">
+                    <span>Procs Running/Blocked:</span>
+                    <span id="cpu-procs">--</span>
+                </div>
             </div>
             
             <div class="card">
@@ -193,6 +243,22 @@ func GetHTML() string {
                     <span>Available:</span>
                     <span id="mem-available">--</span>
                 </div>
+                <div class="metric">
+                    <span>Page Faults (major):</span>
+                    <span id="mem-faults">--</span>
+                </div>
+                <div class="metric">
+                    <span>Swap In/Out:</span>
+                    <span id="mem-swap">--</span>
+                </div>
+                <div class="metric">
+                    <span>Reclaim Scan/Steal:</span>
+                    <span id="mem-reclaim">--</span>
+                </div>
+                <div class="metric">
+                    <span>OOM Kills:</span>
+                    <span id="mem-oom">--</span>
+                </div>
             </div>
             
             <div class="card">
@@ -224,6 +290,10 @@ func GetHTML() string {
                     <span>I/O Write:</span>
                     <span id="disk-io-write">--</span>
                 </div>
+                <div class="metric">
+                    <span>Mode:</span>
+                    <span id="disk-mode">--</span>
+                </div>
             </div>
             
             <div class="card">
@@ -253,6 +323,93 @@ func GetHTML() string {
                 <div class="gpio-pin">No GPIO data</div>
             </div>
         </div>
+        
+        <div class="card">
+            <h3>Writes Today</h3>
+            <div id="writes-container">
+                <div class="metric">No write data</div>
+            </div>
+            <h3>Top Writers</h3>
+            <div id="writers-container">
+                <div class="metric">No process data</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Storage Health</h3>
+            <div id="storage-container">
+                <div class="metric">No eMMC/SD devices</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Services</h3>
+            <div id="services-container">
+                <div class="metric">No services configured</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Watchdog</h3>
+            <div id="watchdog-container">
+                <div class="metric">No watchdog</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Custom Metrics</h3>
+            <div id="custom-container">
+                <div class="metric">No plugins configured</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>File Metrics</h3>
+            <div id="files-container">
+                <div class="metric">No file metrics configured</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Derived Metrics</h3>
+            <div id="derived-container">
+                <div class="metric">No derived metrics configured</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Probes</h3>
+            <div id="probes-container">
+                <div class="metric">No probes configured</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Network Config</h3>
+            <div id="netconfig-container">
+                <div class="metric">--</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Kernel Limits</h3>
+            <div id="limits-container">
+                <div class="metric">--</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Top IRQ Sources</h3>
+            <div id="irq-container">
+                <div class="metric">No interrupt activity yet</div>
+            </div>
+        </div>
+        
+        <div class="card">
+            <h3>Kernel Log</h3>
+            <div id="kmsg-counters"></div>
+            <div id="kmsg-log" class="log-view">No kernel messages</div>
+        </div>
     </div>
 
     <script>
@@ -313,6 +470,10 @@ func GetHTML() string {
             document.getElementById('mem-used').textContent = formatBytes(data.memory.used);
             document.getElementById('mem-free').textContent = formatBytes(data.memory.free);
             document.getElementById('mem-available').textContent = formatBytes(data.memory.available);
+            document.getElementById('mem-faults').textContent = data.vm.page_faults.toFixed(0) + '/s (' + data.vm.major_faults.toFixed(1) + '/s)';
+            document.getElementById('mem-swap').textContent = data.vm.swap_in.toFixed(0) + ' / ' + data.vm.swap_out.toFixed(0) + ' pages/s';
+            document.getElementById('mem-reclaim').textContent = data.vm.page_scan.toFixed(0) + ' / ' + data.vm.page_steal.toFixed(0) + ' pages/s';
+            document.getElementById('mem-oom').textContent = data.vm.oom_kills_total;
             
             // Update Disk
             document.getElementById('disk-usage').textContent = data.disk.usage_percent.toFixed(1) + '%';
@@ -322,6 +483,9 @@ func GetHTML() string {
             document.getElementById('disk-free').textContent = formatBytes(data.disk.free);
             document.getElementById('disk-io-read').textContent = formatBytes(data.disk.io_read);
             document.getElementById('disk-io-write').textContent = formatBytes(data.disk.io_write);
+            const diskMode = document.getElementById('disk-mode');
+            diskMode.textContent = data.disk.read_only ? 'READ-ONLY' : 'read-write';
+            diskMode.style.color = data.disk.read_only ? '#ff0000' : '';
             
             // Update Temperature
             document.getElementById('temp-cpu').textContent = data.temperature.cpu.toFixed(1) + '°C';
@@ -331,6 +495,421 @@ func GetHTML() string {
             
             // Update GPIO
             updateGPIO(data.gpio.pins);
+            
+            // Update Storage health
+            updateStorage(data.storage.devices);
+            
+            // Update write volume
+            updateWrites(data.writes);
+            
+            // Update kernel log counters
+            updateKernelCounters(data.kernel);
+            
+            // Update interrupts
+            updateInterrupts(data.interrupts);
+            
+            // Update kernel limits
+            updateLimits(data.limits);
+            
+            // Update network configuration
+            updateNetConfig(data.netconfig);
+            
+            // Update probes
+            updateProbes(data.probes || {});
+            
+            // Update services
+            updateServices(data.services || {});
+            
+            // Update watchdog
+            updateWatchdog(data.watchdog || {});
+            
+            // Update custom metrics
+            updateCustom(data.custom || {}, data.plugins || {});
+            
+            // Update file metrics
+            updateFiles(data.files || {});
+            
+            // Update derived metrics
+            updateDerived(data.derived || {});
+        }
+        
+        function updateFiles(files) {
+            updateNamedValues('files-container', files, 'No file metrics configured');
+        }
+        
+        function updateDerived(derived) {
+            updateNamedValues('derived-container', derived, 'No derived metrics configured');
+        }
+        
+        function updateNamedValues(id, values, empty) {
+            const container = document.getElementById(id);
+            const names = Object.keys(values).sort();
+            container.innerHTML = '';
+            if (names.length === 0) {
+                container.innerHTML = '<div class="metric">' + empty + '</div>';
+                return;
+            }
+            
+            for (const name of names) {
+                const metric = values[name];
+                const row = document.createElement('div');
+                row.className = 'metric';
+                if (metric.error) {
+                    row.title = metric.error;
+                    row.innerHTML = '<span>' + name + ':</span><span style="color: #ff0000">error</span>';
+                } else {
+                    row.innerHTML = '<span>' + name + ':</span><span>' + Number(metric.value.toFixed(3)) +
+                        (metric.unit ? ' ' + metric.unit : '') + '</span>';
+                }
+                container.appendChild(row);
+            }
+        }
+        
+        function updateCustom(custom, plugins) {
+            const container = document.getElementById('custom-container');
+            const names = Object.keys(plugins).sort();
+            container.innerHTML = '';
+            if (names.length === 0) {
+                container.innerHTML = '<div class="metric">No plugins configured</div>';
+                return;
+            }
+            
+            for (const name of names) {
+                const status = plugins[name];
+                if (!status.ok) {
+                    const row = document.createElement('div');
+                    row.className = 'metric';
+                    row.title = status.error || '';
+                    row.innerHTML = '<span>' + name + ':</span><span style="color: #ff0000">' +
+                        (status.error ? 'failed' : 'pending') + '</span>';
+                    container.appendChild(row);
+                }
+                const metrics = custom[name] || {};
+                for (const metric of Object.keys(metrics).sort()) {
+                    const row = document.createElement('div');
+                    row.className = 'metric';
+                    row.innerHTML = '<span>' + name + '.' + metric + ':</span><span>' +
+                        Number(metrics[metric].toFixed(2)) + '</span>';
+                    container.appendChild(row);
+                }
+            }
+        }
+        
+        function updateWatchdog(watchdog) {
+            const container = document.getElementById('watchdog-container');
+            container.innerHTML = '';
+            
+            const state = document.createElement('div');
+            state.className = 'metric';
+            if (!watchdog.enabled) {
+                state.innerHTML = '<span>emmon:</span><span>not armed</span>';
+            } else if (watchdog.healthy) {
+                state.innerHTML = '<span>emmon:</span><span style="color: #00ff00">petting</span>';
+            } else {
+                state.innerHTML = '<span>emmon:</span><span style="color: #ff0000; font-weight: bold">NOT PETTING</span>';
+            }
+            container.appendChild(state);
+            
+            if (watchdog.enabled && !watchdog.healthy) {
+                for (const reason of watchdog.reasons || []) {
+                    const row = document.createElement('div');
+                    row.className = 'metric';
+                    row.innerHTML = '<span style="color: #ff0000">' + reason + '</span>';
+                    container.appendChild(row);
+                }
+            }
+            
+            const devices = watchdog.devices || {};
+            for (const name of Object.keys(devices).sort()) {
+                const device = devices[name];
+                const row = document.createElement('div');
+                row.className = 'metric';
+                row.title = device.identity + (device.nowayout ? ' (nowayout)' : '');
+                row.innerHTML = '<span>' + name + ':</span><span>' + (device.active ? 'active' : 'inactive') +
+                    ', timeout ' + device.timeout + 's' + (device.active ? ', ' + device.timeleft + 's left' : '') +
+                    '</span>';
+                container.appendChild(row);
+            }
+        }
+        
+        function updateServices(services) {
+            const names = Object.keys(services).sort();
+            const down = names.filter(function(name) { return !services[name].up; });
+            const alert = document.getElementById('service-alert');
+            alert.textContent = 'Services down: ' + down.join(', ');
+            alert.style.display = down.length > 0 ? 'block' : 'none';
+            
+            const container = document.getElementById('services-container');
+            container.innerHTML = '';
+            if (names.length === 0) {
+                container.innerHTML = '<div class="metric">No services configured</div>';
+                return;
+            }
+            
+            for (const name of names) {
+                const service = services[name];
+                const row = document.createElement('div');
+                row.className = 'metric';
+                if (service.up) {
+                    row.title = 'pid ' + service.pid + ', ' + service.processes + ' process(es), up ' +
+                        formatUptime(service.uptime);
+                    row.innerHTML = '<span>' + name + ':</span><span' +
+                        (service.restarts > 0 ? ' style="color: #ffaa00"' : '') + '>' +
+                        service.cpu_percent.toFixed(1) + '% CPU, ' + formatBytes(service.rss) +
+                        (service.restarts > 0 ? ', ' + service.restarts + ' restarts' : '') + '</span>';
+                } else {
+                    row.innerHTML = '<span>' + name + ':</span><span style="color: #ff0000; font-weight: bold">DOWN</span>';
+                }
+                container.appendChild(row);
+            }
+        }
+        
+        function updateProbes(probes) {
+            const container = document.getElementById('probes-container');
+            const names = Object.keys(probes).sort();
+            container.innerHTML = '';
+            if (names.length === 0) {
+                container.innerHTML = '<div class="metric">No probes configured</div>';
+                return;
+            }
+            
+            for (const name of names) {
+                const probe = probes[name];
+                const row = document.createElement('div');
+                row.className = 'metric';
+                row.title = probe.type + ' ' + probe.target + (probe.last_error ? ': ' + probe.last_error : '');
+                if (probe.checks === 0) {
+                    row.innerHTML = '<span>' + name + ':</span><span style="color: #999">pending</span>';
+                    container.appendChild(row);
+                    continue;
+                }
+                const color = probe.up ? '#4CAF50' : '#f44336';
+                row.innerHTML = '<span>' + name + ':</span><span style="color: ' + color + '">' +
+                    (probe.up ? probe.latency_ms.toFixed(1) + ' ms' : 'down') +
+                    ' (' + (probe.success_ratio * 100).toFixed(0) + '%)</span>';
+                container.appendChild(row);
+            }
+        }
+        
+        function updateNetConfig(nc) {
+            const container = document.getElementById('netconfig-container');
+            container.innerHTML = '';
+            const addRow = function(name, value, color) {
+                const row = document.createElement('div');
+                row.className = 'metric';
+                row.innerHTML = '<span>' + name + ':</span><span' +
+                    (color ? ' style="color: ' + color + '"' : '') + '>' + value + '</span>';
+                container.appendChild(row);
+            };
+            
+            if (nc.default_route) {
+                addRow('Default Route', 'via ' + (nc.default_gateway || 'direct') + ' on ' + nc.default_interface);
+            } else {
+                addRow('Default Route', 'none', '#f44336');
+            }
+            addRow('DNS', (nc.dns_servers || []).join(', ') || 'none');
+            for (const iface of nc.interfaces || []) {
+                addRow(iface.name + (iface.up ? '' : ' (down)'), iface.addresses.join('<br>') || '--');
+            }
+            for (const route of nc.routes || []) {
+                addRow(route.destination, (route.gateway ? 'via ' + route.gateway + ' ' : '') + 'dev ' +
+                    route.interface + ' metric ' + route.metric);
+            }
+        }
+        
+        function updateLimits(limits) {
+            const rows = [
+                ['Open Files', limits.files],
+                ['PIDs', limits.pids],
+                ['Conntrack', limits.conntrack],
+                ['Entropy', limits.entropy]
+            ];
+            const container = document.getElementById('limits-container');
+            container.innerHTML = '';
+            for (const [name, usage] of rows) {
+                if (!usage) {
+                    continue;
+                }
+                const row = document.createElement('div');
+                row.className = 'metric';
+                row.innerHTML = '<span>' + name + ':</span><span>' + usage.used + ' / ' + usage.max +
+                    ' (' + usage.percent.toFixed(1) + '%)</span>';
+                container.appendChild(row);
+            }
+            
+            const sockets = limits.sockets;
+            const socketRows = [
+                ['TCP In Use', sockets.tcp_inuse],
+                ['TCP Orphans', sockets.tcp_orphan + ' (' + sockets.orphan_percent.toFixed(1) + '%)'],
+                ['TCP Time-Wait', sockets.tcp_tw + ' (' + sockets.tw_percent.toFixed(1) + '%)'],
+                ['TCP Memory', formatBytes(sockets.tcp_memory) + ' (' + sockets.memory_percent.toFixed(1) + '%)']
+            ];
+            for (const [name, value] of socketRows) {
+                const row = document.createElement('div');
+                row.className = 'metric';
+                row.innerHTML = '<span>' + name + ':</span><span>' + value + '</span>';
+                container.appendChild(row);
+            }
+            
+            const states = Object.keys(limits.tcp_states || {}).sort();
+            if (states.length > 0) {
+                const row = document.createElement('div');
+                row.className = 'metric';
+                row.innerHTML = '<span>TCP States:</span><span>' +
+                    states.map(function(s) { return s + ' ' + limits.tcp_states[s]; }).join(', ') + '</span>';
+                container.appendChild(row);
+            }
+        }
+        
+        function updateInterrupts(irq) {
+            document.getElementById('cpu-ctxt').textContent = irq.context_switches.toFixed(0) + '/s';
+            document.getElementById('cpu-forks').textContent = irq.forks.toFixed(1) + '/s';
+            document.getElementById('cpu-procs').textContent = irq.procs_running + ' / ' + irq.procs_blocked;
+            
+            const container = document.getElementById('irq-container');
+            container.innerHTML = '';
+            if (!irq.top || irq.top.length === 0) {
+                container.innerHTML = '<div class="metric">No interrupt activity yet</div>';
+                return;
+            }
+            
+            const total = document.createElement('div');
+            total.className = 'metric';
+            total.innerHTML = '<span>Total:</span><span>' + irq.total.toFixed(0) + '/s</span>';
+            container.appendChild(total);
+            for (const source of irq.top) {
+                const row = document.createElement('div');
+                row.className = 'metric';
+                row.title = 'Per CPU: ' + source.per_cpu.map(function(r) { return r.toFixed(0); }).join(' ');
+                row.innerHTML = '<span>' + source.irq + ' ' + source.devices + '</span><span>' + source.rate.toFixed(0) + '/s</span>';
+                container.appendChild(row);
+            }
+        }
+        
+        function updateKernelCounters(kernel) {
+            const container = document.getElementById('kmsg-counters');
+            container.innerHTML = '';
+            
+            if (kernel.error) {
+                container.innerHTML = '<div class="metric"><span>Unavailable:</span><span>' + kernel.error + '</span></div>';
+                return;
+            }
+            for (const name of Object.keys(kernel.counters || {}).sort()) {
+                const row = document.createElement('div');
+                row.className = 'metric';
+                row.innerHTML = '<span>' + name + ':</span><span>' + kernel.counters[name] + ' (' + kernel.recent[name] + ' recent)</span>';
+                container.appendChild(row);
+            }
+        }
+        
+        function refreshInventory() {
+            fetch('/api/inventory').then(function(response) {
+                return response.json();
+            }).then(function(inv) {
+                const parts = [inv.hostname];
+                if (inv.model) parts.push(inv.model);
+                if (inv.serial) parts.push('S/N ' + inv.serial);
+                if (inv.os_name) parts.push(inv.os_name);
+                parts.push('Linux ' + inv.kernel_release);
+                parts.push(inv.cpu_cores + 'x ' + (inv.cpu_model || 'CPU') + ', ' + formatBytes(inv.memory_total) + ' RAM');
+                parts.push('up ' + formatUptime(inv.uptime));
+                document.getElementById('identity').textContent = parts.join(' | ');
+            }).catch(function(error) {
+                console.error('Inventory error:', error);
+            });
+        }
+        
+        function formatUptime(seconds) {
+            const days = Math.floor(seconds / 86400);
+            const hours = Math.floor((seconds % 86400) / 3600);
+            const minutes = Math.floor((seconds % 3600) / 60);
+            return (days > 0 ? days + 'd ' : '') + hours + 'h ' + minutes + 'm';
+        }
+        
+        function refreshKernelLog() {
+            fetch('/api/kmsg').then(function(response) {
+                return response.json();
+            }).then(function(messages) {
+                const container = document.getElementById('kmsg-log');
+                if (!messages || messages.length === 0) {
+                    container.textContent = 'No kernel messages';
+                    return;
+                }
+                container.innerHTML = '';
+                for (const msg of messages.slice(-200)) {
+                    const line = document.createElement('div');
+                    line.className = 'log-line' + (msg.priority <= 3 ? ' err' : msg.priority === 4 ? ' warning' : '') + (msg.rule ? ' matched' : '');
+                    line.textContent = '[' + msg.uptime.toFixed(3).padStart(12) + '] ' + msg.level.padEnd(7) + ' ' + msg.message;
+                    container.appendChild(line);
+                }
+                container.scrollTop = container.scrollHeight;
+            }).catch(function(error) {
+                console.error('Kernel log error:', error);
+            });
+        }
+        
+        function updateWrites(writes) {
+            const container = document.getElementById('writes-container');
+            container.innerHTML = '';
+            
+            const mounts = writes.mounts || {};
+            if (Object.keys(mounts).length === 0) {
+                container.innerHTML = '<div class="metric">No write data</div>';
+            }
+            for (const mount of Object.keys(mounts).sort()) {
+                const volume = mounts[mount];
+                let value = formatBytes(volume.hour) + '/h, ' + formatBytes(volume.today) + ' today';
+                if (volume.budget) {
+                    value += ' (' + volume.budget_percent.toFixed(0) + '% of budget)';
+                }
+                const row = document.createElement('div');
+                row.className = 'metric';
+                if (volume.over_budget) {
+                    row.style.color = '#ff0000';
+                }
+                row.innerHTML = '<span>' + mount + '</span><span>' + value + '</span>';
+                container.appendChild(row);
+            }
+            
+            const writers = document.getElementById('writers-container');
+            writers.innerHTML = '';
+            if (!writes.top_writers || writes.top_writers.length === 0) {
+                writers.innerHTML = '<div class="metric">No process data</div>';
+                return;
+            }
+            for (const writer of writes.top_writers) {
+                const row = document.createElement('div');
+                row.className = 'metric';
+                row.innerHTML = '<span>' + writer.name + '</span><span>' + formatBytes(writer.today) + ' (' + formatBytes(writer.bytes_per_sec) + '/s)</span>';
+                writers.appendChild(row);
+            }
+        }
+        
+        function updateStorage(devices) {
+            const container = document.getElementById('storage-container');
+            container.innerHTML = '';
+            
+            if (!devices || Object.keys(devices).length === 0) {
+                container.innerHTML = '<div class="metric">No eMMC/SD devices</div>';
+                return;
+            }
+            
+            for (const [name, dev] of Object.entries(devices)) {
+                const life = dev.days_left > 0 ? '~' + dev.days_left.toFixed(0) + ' days' : 'unknown';
+                const rows = [
+                    [name + ' (' + dev.type + ' ' + dev.model + ')', ''],
+                    ['Wear:', dev.wear_percent.toFixed(0) + '% (pre-EOL ' + dev.pre_eol_state + ')'],
+                    ['Written since boot:', formatBytes(dev.bytes_written_boot)],
+                    ['Written total:', formatBytes(dev.bytes_written_total)],
+                    ['Projected lifetime:', life]
+                ];
+                for (const [label, value] of rows) {
+                    const row = document.createElement('div');
+                    row.className = 'metric';
+                    row.innerHTML = '<span>' + label + '</span><span>' + value + '</span>';
+                    container.appendChild(row);
+                }
+            }
         }
         
         function updateGPIO(pins) {
@@ -360,6 +939,10 @@ func GetHTML() string {
         
         // Connect on page load
         connect();
+        refreshKernelLog();
+        setInterval(refreshKernelLog, 5000);
+        refreshInventory();
+        setInterval(refreshInventory, 60000);
     </script>
 </body>
 </html>`